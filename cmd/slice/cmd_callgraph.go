@@ -0,0 +1,670 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/noperator/slice/pkg/codeql"
+	"github.com/noperator/slice/pkg/llm"
+	"github.com/noperator/slice/pkg/parser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	callgraphOutput    string
+	callgraphFunctions []string
+	callgraphMaxChains int
+	callgraphIndex     int
+	callgraphSource    string
+	callgraphOld       string
+	callgraphNew       string
+	callgraphRepo      string
+	callgraphEntryFile string
+	callgraphFindings  string
+	callgraphMaxPaths  int
+)
+
+var callgraphCmd = &cobra.Command{
+	Use:   "callgraph",
+	Short: "Export and query the call graph used for call chain validation",
+	Long: `Build the same call graph "slice query" validates findings against, with direct calls,
+calls through struct members holding function pointers (indirect) and functions passed as
+arguments (callback), and export or query it.
+
+  slice callgraph export ./src -f dot -o graph.dot
+  slice callgraph callers ./src kfree_skb -d 2
+  slice callgraph paths ./src dev_close buf_release
+  slice callgraph reach ./src --from main
+  slice callgraph metrics ./src
+  slice callgraph finding results.json -i 3 | dot -Tsvg > finding.svg
+  slice callgraph diff --old v6.8:drivers/net --new v6.9:drivers/net --repo linux`,
+}
+
+var callgraphExportCmd = &cobra.Command{
+	Use:   "export <directory>",
+	Short: "Export the call graph as DOT, GraphML or JSON",
+	Long: `Export the whole call graph, or with --function the functions named and their callers and
+callees up to --depth calls away.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, depth := callgraphFlags(cmd)
+		callGraph, err := loadCallGraph(args[0])
+		if err != nil {
+			return err
+		}
+
+		var ids []string
+		if len(callgraphFunctions) > 0 {
+			var start []string
+			for _, function := range callgraphFunctions {
+				found := callGraph.Lookup(function)
+				if len(found) == 0 {
+					return fmt.Errorf("function %s not found in call graph", function)
+				}
+				start = append(start, found...)
+			}
+			ids = callGraph.Neighbourhood(start, depth)
+		}
+		return writeGraph(callGraph.Export(ids), args[0], format)
+	},
+}
+
+var callgraphCallersCmd = &cobra.Command{
+	Use:   "callers <directory> <function>",
+	Short: "List the callers of a function",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, depth := callgraphFlags(cmd)
+		return walkCallGraph(args[0], []string{args[1]}, true, format, depth)
+	},
+}
+
+var callgraphCalleesCmd = &cobra.Command{
+	Use:   "callees <directory> <function>",
+	Short: "List the functions a function calls",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, depth := callgraphFlags(cmd)
+		return walkCallGraph(args[0], []string{args[1]}, false, format, depth)
+	},
+}
+
+var callgraphReachCmd = &cobra.Command{
+	Use:   "reach <directory>",
+	Short: "List the functions reachable from entry functions",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(callgraphFunctions) == 0 {
+			return fmt.Errorf("at least one --from function is required")
+		}
+		format, depth := callgraphFlags(cmd)
+		return walkCallGraph(args[0], callgraphFunctions, false, format, depth)
+	},
+}
+
+var callgraphPathsCmd = &cobra.Command{
+	Use:   "paths <directory> <from> <to>",
+	Short: "List the shortest call chains from one function to another",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, depth := callgraphFlags(cmd)
+		callGraph, err := loadCallGraph(args[0])
+		if err != nil {
+			return err
+		}
+		for _, function := range args[1:] {
+			if len(callGraph.Lookup(function)) == 0 {
+				return fmt.Errorf("function %s not found in call graph", function)
+			}
+		}
+
+		chains := callGraph.Chains(args[1], args[2], depth, callgraphMaxChains)
+		for i := range chains {
+			for j := range chains[i].Hops {
+				chains[i].Hops[j].File = relativeFile(args[0], chains[i].Hops[j].File)
+			}
+		}
+
+		out, closeOut, err := openOutput()
+		if err != nil {
+			return err
+		}
+		defer closeOut()
+
+		if format == "json" {
+			return writeJSON(out, chains)
+		}
+		if len(chains) == 0 {
+			fmt.Fprintf(out, "No call chain from %s to %s within %d calls\n", args[1], args[2], depth)
+			return nil
+		}
+		for i, chain := range chains {
+			var names []string
+			for j, function := range chain.Functions {
+				if edge := chain.EdgeInto(j); edge != "" && edge != codeql.EdgeDirect {
+					function = fmt.Sprintf("(%s) %s", edge, function)
+				}
+				names = append(names, function)
+			}
+			fmt.Fprintf(out, "%d. %s — %d call(s)\n", i+1, strings.Join(names, " → "), chain.Length)
+			var calls []string
+			for _, hop := range chain.Hops {
+				if hop.Calls != "" {
+					calls = append(calls, fmt.Sprintf("%s:%d calls %s()", hop.File, hop.CallLine, hop.Calls))
+				}
+			}
+			if len(calls) > 0 {
+				fmt.Fprintf(out, "   %s\n", strings.Join(calls, " → "))
+			}
+		}
+		return nil
+	},
+}
+
+var callgraphMetricsCmd = &cobra.Command{
+	Use:   "metrics <directory> [function...]",
+	Short: "List call graph and complexity metrics per function",
+	Long: `List each function's fan-in, fan-out, betweenness, recursion and cyclomatic complexity,
+highest betweenness first, or only those of the functions named.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		analysisResult, err := parser.GetCachedAnalysisResult(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to analyze directory: %w", err)
+		}
+		callGraph, err := loadCallGraph(args[0])
+		if err != nil {
+			return err
+		}
+
+		wanted := make(map[string]bool)
+		for _, function := range args[1:] {
+			if len(callGraph.Lookup(function)) == 0 {
+				return fmt.Errorf("function %s not found in call graph", function)
+			}
+			for _, id := range callGraph.Lookup(function) {
+				wanted[id] = true
+			}
+		}
+		var metrics []*codeql.FunctionMetrics
+		for i := range analysisResult.Functions {
+			function := &analysisResult.Functions[i]
+			if len(wanted) == 0 || wanted[function.ID] {
+				metrics = append(metrics, callGraph.Metrics(function))
+			}
+		}
+		sort.SliceStable(metrics, func(i, j int) bool {
+			return metrics[i].Betweenness > metrics[j].Betweenness
+		})
+
+		out, closeOut, err := openOutput()
+		if err != nil {
+			return err
+		}
+		defer closeOut()
+
+		if format == "json" {
+			return writeJSON(out, metrics)
+		}
+		fmt.Fprintf(out, "%-32s %6s %7s %11s %6s %10s %5s\n", "function", "fan_in", "fan_out", "betweenness", "pct", "complexity", "lines")
+		for _, m := range metrics {
+			name := m.Function
+			if m.Recursive {
+				name += fmt.Sprintf(" (scc %d)", m.SCCSize)
+			}
+			fmt.Fprintf(out, "%-32s %6d %7d %11.6f %6.2f %10d %5d\n", name, m.FanIn, m.FanOut, m.Betweenness, m.BetweennessPct, m.Complexity, m.Lines)
+		}
+		return nil
+	},
+}
+
+var callgraphFindingCmd = &cobra.Command{
+	Use:   "finding <results.json>",
+	Short: "Export the call graph around a finding",
+	Long: `Export the free and use functions of a finding from "slice query" output, the functions on
+its call chains and its common callers, plus their callers and callees up to --depth calls
+away. A finding without validated chains (e.g. from a --no-validate run) is validated again,
+and the reason it does or does not validate is logged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, depth := callgraphFlags(cmd)
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read results: %w", err)
+		}
+		var output llm.UnifiedOutput
+		if err := json.Unmarshal(data, &output); err != nil {
+			return fmt.Errorf("failed to parse results: %w", err)
+		}
+		if callgraphIndex < 1 || callgraphIndex > len(output.Results) {
+			return fmt.Errorf("finding %d out of range (1-%d)", callgraphIndex, len(output.Results))
+		}
+		result := output.Results[callgraphIndex-1]
+
+		sourceDir := output.SrcDir
+		if callgraphSource != "" {
+			sourceDir = callgraphSource
+		}
+		if sourceDir == "" {
+			return fmt.Errorf("results do not record a source directory; pass --source")
+		}
+		callGraph, err := loadCallGraph(sourceDir)
+		if err != nil {
+			return err
+		}
+
+		query := result.CodeQLResult
+		freeID := fmt.Sprintf("%s:%d:%s", filepath.Join(sourceDir, query.FreeFunctionFile), query.FreeFunctionDefLine, query.FreeFunctionName)
+		useID := fmt.Sprintf("%s:%d:%s", filepath.Join(sourceDir, query.UseFunctionFile), query.UseFunctionDefLine, query.UseFunctionName)
+
+		analysis := result.CallValidation
+		if analysis == nil || !analysis.IsValid {
+			analysis = callGraph.ValidateCallRelationship(query.FreeFunctionName, query.UseFunctionName, codeql.DefaultPathOptions.MaxDepth)
+		}
+		slog.Info("finding call relationship", "component", "callgraph",
+			"free_func", query.FreeFunctionName,
+			"use_func", query.UseFunctionName,
+			"valid", analysis.IsValid,
+			"reason", analysis.Reason,
+			"details", analysis.Details)
+
+		seeds := []string{freeID, useID}
+		for _, chain := range analysis.CallChains {
+			for _, hop := range chain.Hops {
+				seeds = append(seeds, hop.FunctionID)
+			}
+		}
+		for _, ancestor := range analysis.Ancestors {
+			seeds = append(seeds, ancestor.CallerID)
+		}
+
+		graph := callGraph.Export(callGraph.Neighbourhood(seeds, depth))
+		graph.MarkFinding(freeID, useID, analysis)
+		return writeGraph(graph, sourceDir, format)
+	},
+}
+
+var callgraphDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the call graphs of two source revisions",
+	Long: `Compare the call graphs of two revisions of a source tree: the functions and calls added and
+removed, and the new paths from entry points to functions calling a deallocator. Functions are
+matched by file and name, so both revisions must be rooted at the same directory.
+
+--old and --new each take a directory or a git revision of the --repo repository; REV:path
+compares only that subdirectory. New paths start at main, LLVMFuzzerTestOneInput,
+SYSCALL_DEFINE* and the --entry-points rules of "slice query", or at functions nothing calls
+when the new revision has no entry point. An added call is marked as a new route when its caller
+did not reach the callee before.
+
+With --findings, the findings of "slice query" output whose call chains the changes touch are
+listed too: a chain function or call removed, a chain function's calls changed, or a new path to
+its free or use function.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if callgraphOld == "" || callgraphNew == "" {
+			return fmt.Errorf("both --old and --new are required")
+		}
+
+		entryConfig := codeql.DefaultEntryConfig()
+		if callgraphEntryFile != "" {
+			var err error
+			if entryConfig, err = codeql.LoadEntryConfig(callgraphEntryFile); err != nil {
+				return err
+			}
+		}
+
+		var output llm.UnifiedOutput
+		if callgraphFindings != "" {
+			data, err := os.ReadFile(callgraphFindings)
+			if err != nil {
+				return fmt.Errorf("failed to read results: %w", err)
+			}
+			if err := json.Unmarshal(data, &output); err != nil {
+				return fmt.Errorf("failed to parse results: %w", err)
+			}
+		}
+
+		var graphs [2]*codeql.CallGraph
+		var dirs [2]string
+		var analyses [2]*parser.AnalysisResult
+		for i, revision := range []string{callgraphOld, callgraphNew} {
+			dir, cleanup, err := revisionDir(callgraphRepo, revision)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			if analyses[i], err = parser.GetCachedAnalysisResult(dir); err != nil {
+				return fmt.Errorf("failed to analyze %s: %w", revision, err)
+			}
+			if graphs[i], err = loadCallGraph(dir); err != nil {
+				return err
+			}
+			dirs[i] = dir
+		}
+
+		entries := graphs[1].SetEntryPoints(entryConfig.Resolve(analyses[1]))
+		slog.Info("entry points found", "component", "callgraph", "entry_points", entries)
+
+		targets := codeql.FreeSites(analyses[1], dirs[1])
+		for _, result := range output.Results {
+			query := result.CodeQLResult
+			targets = append(targets,
+				codeql.FunctionKey(query.FreeFunctionFile, query.FreeFunctionName),
+				codeql.FunctionKey(query.UseFunctionFile, query.UseFunctionName))
+		}
+
+		diff := codeql.DiffCallGraphs(graphs[0], graphs[1], dirs[0], dirs[1], codeql.DiffOptions{Targets: targets, MaxPaths: callgraphMaxPaths})
+		for i, result := range output.Results {
+			if reasons := diff.Affects(result.CodeQLResult, result.CallValidation); len(reasons) > 0 {
+				diff.Findings = append(diff.Findings, codeql.AffectedFinding{
+					Index:        i + 1,
+					FreeFunction: result.CodeQLResult.FreeFunctionName,
+					UseFunction:  result.CodeQLResult.UseFunctionName,
+					Reasons:      reasons,
+				})
+			}
+		}
+
+		out, closeOut, err := openOutput()
+		if err != nil {
+			return err
+		}
+		defer closeOut()
+
+		if format == "json" {
+			return writeJSON(out, diff)
+		}
+		writeGraphDiff(out, diff)
+		return nil
+	},
+}
+
+// writeGraphDiff writes a call graph diff as text
+func writeGraphDiff(out io.Writer, diff *codeql.GraphDiff) {
+	fmt.Fprintf(out, "functions: +%d -%d\n", len(diff.AddedFunctions), len(diff.RemovedFunctions))
+	for _, function := range diff.AddedFunctions {
+		fmt.Fprintf(out, "  + %s\t%s:%d\n", function.Function, function.File, function.DefLine)
+	}
+	for _, function := range diff.RemovedFunctions {
+		fmt.Fprintf(out, "  - %s\t%s:%d\n", function.Function, function.File, function.DefLine)
+	}
+
+	fmt.Fprintf(out, "calls: +%d -%d\n", len(diff.AddedEdges), len(diff.RemovedEdges))
+	for _, edges := range []struct {
+		sign  string
+		edges []codeql.DiffEdge
+	}{{"+", diff.AddedEdges}, {"-", diff.RemovedEdges}} {
+		for _, edge := range edges.edges {
+			line := fmt.Sprintf("  %s %s -> %s\t%s:%d", edges.sign, edge.From, edge.To, edge.FromFile, edge.CallLine)
+			if edge.Edge != codeql.EdgeDirect {
+				line += " (" + edge.Edge + ")"
+			}
+			if edge.NewRoute {
+				line += " [new route]"
+			}
+			fmt.Fprintln(out, line)
+		}
+	}
+
+	fmt.Fprintf(out, "new paths: %d\n", len(diff.NewPaths)+diff.MorePaths)
+	for _, path := range diff.NewPaths {
+		kind := path.Kind
+		if kind == "" {
+			kind = "root"
+		}
+		fmt.Fprintf(out, "  [%s] %s\n", kind, strings.Join(path.Chain.Functions, " -> "))
+	}
+	if diff.MorePaths > 0 {
+		fmt.Fprintf(out, "  ... %d more\n", diff.MorePaths)
+	}
+
+	if len(diff.Findings) > 0 {
+		fmt.Fprintf(out, "affected findings: %d\n", len(diff.Findings))
+		for _, finding := range diff.Findings {
+			fmt.Fprintf(out, "  #%d %s/%s\n", finding.Index, finding.FreeFunction, finding.UseFunction)
+			for _, reason := range finding.Reasons {
+				fmt.Fprintf(out, "      %s\n", reason)
+			}
+		}
+	}
+}
+
+// revisionDir returns a directory holding a source revision: the directory itself, or a git
+// revision of the repository extracted into a temporary directory
+func revisionDir(repo, revision string) (string, func(), error) {
+	if info, err := os.Stat(revision); err == nil && info.IsDir() {
+		return revision, func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "slice-diff-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create directory for %s: %w", revision, err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	archive := exec.Command("git", "-C", repo, "archive", "--format=tar", revision)
+	var stderr bytes.Buffer
+	archive.Stderr = &stderr
+	stdout, err := archive.StdoutPipe()
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := archive.Start(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to run git: %w", err)
+	}
+	extractErr := extractSources(tar.NewReader(stdout), dir)
+	io.Copy(io.Discard, stdout)
+	if err := archive.Wait(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("%s is neither a directory nor a git revision of %s: %s", revision, repo, strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to extract %s: %w", revision, extractErr)
+	}
+	slog.Info("extracted revision", "component", "callgraph", "revision", revision, "directory", dir)
+	return dir, cleanup, nil
+}
+
+// extractSources writes the C sources and headers of a tar stream under a directory
+func extractSources(archive *tar.Reader, dir string) error {
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(header.Name)
+		ext := filepath.Ext(name)
+		if header.Typeflag != tar.TypeReg || (ext != ".c" && ext != ".h") || !filepath.IsLocal(name) {
+			continue
+		}
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, archive)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// loadCallGraph parses a source tree and builds its call graph
+func loadCallGraph(sourceDir string) (*codeql.CallGraph, error) {
+	analysisResult, err := parser.GetCachedAnalysisResult(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze directory: %w", err)
+	}
+	callGraph := codeql.BuildCallGraph(analysisResult.Functions, analysisResult.FunctionPointers...)
+	functions, calls, components := callGraph.Size()
+	slog.Info("call graph built", "component", "callgraph",
+		"functions", functions,
+		"calls", calls,
+		"components", components)
+	return callGraph, nil
+}
+
+// walkCallGraph lists the callers or callees of functions up to --depth calls away
+func walkCallGraph(sourceDir string, functions []string, callers bool, format string, depth int) error {
+	callGraph, err := loadCallGraph(sourceDir)
+	if err != nil {
+		return err
+	}
+	var start []string
+	for _, function := range functions {
+		found := callGraph.Lookup(function)
+		if len(found) == 0 {
+			return fmt.Errorf("function %s not found in call graph", function)
+		}
+		start = append(start, found...)
+	}
+
+	var reached []codeql.ReachedFunction
+	if callers {
+		reached = callGraph.Callers(start, depth)
+	} else {
+		reached = callGraph.Callees(start, depth)
+	}
+	for i := range reached {
+		reached[i].File = relativeFile(sourceDir, reached[i].File)
+	}
+
+	out, closeOut, err := openOutput()
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	if format == "json" {
+		return writeJSON(out, reached)
+	}
+	for _, function := range reached {
+		via := function.Via
+		if function.Edge != "" && function.Edge != codeql.EdgeDirect {
+			via += " (" + function.Edge + ")"
+		}
+		fmt.Fprintf(out, "%d\t%s\t%s:%d\tvia %s\n", function.Depth, function.Function, function.File, function.DefLine, via)
+	}
+	return nil
+}
+
+// writeGraph writes an exported graph in the --format requested, with files relative to the
+// source directory
+func writeGraph(graph *codeql.GraphExport, sourceDir, format string) error {
+	for i := range graph.Nodes {
+		graph.Nodes[i].File = relativeFile(sourceDir, graph.Nodes[i].File)
+	}
+
+	out, closeOut, err := openOutput()
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	switch format {
+	case "dot", "text":
+		return graph.WriteDOT(out)
+	case "graphml":
+		return graph.WriteGraphML(out)
+	case "json":
+		return writeJSON(out, graph)
+	default:
+		return fmt.Errorf("unknown format %q (want dot, graphml or json)", format)
+	}
+}
+
+// callgraphFlags returns the --format and --depth flags of a subcommand, which each subcommand
+// defines with its own default
+func callgraphFlags(cmd *cobra.Command) (string, int) {
+	format, _ := cmd.Flags().GetString("format")
+	depth, _ := cmd.Flags().GetInt("depth")
+	return format, depth
+}
+
+// openOutput returns the --output file, or stdout
+func openOutput() (io.Writer, func(), error) {
+	if callgraphOutput == "" {
+		return os.Stdout, func() {}, nil
+	}
+	file, err := os.Create(callgraphOutput)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// writeJSON writes a value as indented JSON
+func writeJSON(out io.Writer, value any) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// relativeFile returns a file relative to the source directory when it lies inside it
+func relativeFile(sourceDir, file string) string {
+	if rel, err := filepath.Rel(sourceDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+func init() {
+	callgraphCmd.PersistentFlags().StringVarP(&callgraphOutput, "output", "o", "", "Write to a file instead of stdout")
+
+	callgraphExportCmd.Flags().StringP("format", "f", "dot", "Output format: dot, graphml or json")
+	callgraphExportCmd.Flags().StringSliceVarP(&callgraphFunctions, "function", "F", nil, "Export only these functions and their neighbours (repeatable)")
+	callgraphExportCmd.Flags().IntP("depth", "d", 1, "Calls away from --function to include")
+
+	for _, walkCmd := range []*cobra.Command{callgraphCallersCmd, callgraphCalleesCmd} {
+		walkCmd.Flags().StringP("format", "f", "text", "Output format: text or json")
+		walkCmd.Flags().IntP("depth", "d", 1, "Maximum calls away (-1 = no limit)")
+	}
+
+	callgraphReachCmd.Flags().StringSliceVar(&callgraphFunctions, "from", nil, "Entry function to start from (repeatable)")
+	callgraphReachCmd.Flags().StringP("format", "f", "text", "Output format: text or json")
+	callgraphReachCmd.Flags().IntP("depth", "d", -1, "Maximum calls away (-1 = no limit)")
+
+	callgraphPathsCmd.Flags().StringP("format", "f", "text", "Output format: text or json")
+	callgraphPathsCmd.Flags().IntP("depth", "d", codeql.DefaultPathOptions.MaxDepth, "Maximum calls per chain")
+	callgraphPathsCmd.Flags().IntVarP(&callgraphMaxChains, "max-chains", "k", codeql.DefaultPathOptions.MaxPaths, "Maximum chains, shortest first")
+
+	callgraphMetricsCmd.Flags().StringP("format", "f", "text", "Output format: text or json")
+
+	callgraphFindingCmd.Flags().IntVarP(&callgraphIndex, "index", "i", 1, "Finding to export, counting from 1")
+	callgraphFindingCmd.Flags().StringVarP(&callgraphSource, "source", "s", "", "Source directory (default: the one recorded in the results)")
+	callgraphFindingCmd.Flags().StringP("format", "f", "dot", "Output format: dot, graphml or json")
+	callgraphFindingCmd.Flags().IntP("depth", "d", 1, "Calls away from the finding's functions to include")
+
+	callgraphDiffCmd.Flags().StringVar(&callgraphOld, "old", "", "Old revision: a directory or git revision (required)")
+	callgraphDiffCmd.Flags().StringVar(&callgraphNew, "new", "", "New revision: a directory or git revision (required)")
+	callgraphDiffCmd.Flags().StringVar(&callgraphRepo, "repo", ".", "Git repository the revisions belong to")
+	callgraphDiffCmd.Flags().StringVarP(&callgraphEntryFile, "entry-points", "e", "", "YAML file declaring entry points in addition to main, LLVMFuzzerTestOneInput and SYSCALL_DEFINE*")
+	callgraphDiffCmd.Flags().StringVar(&callgraphFindings, "findings", "", "\"slice query\" output whose affected findings to list")
+	callgraphDiffCmd.Flags().IntVarP(&callgraphMaxPaths, "max-paths", "k", 50, "Maximum new paths listed, shortest first (0 = no limit)")
+	callgraphDiffCmd.Flags().StringP("format", "f", "text", "Output format: text or json")
+
+	callgraphCmd.AddCommand(callgraphExportCmd, callgraphCallersCmd, callgraphCalleesCmd, callgraphReachCmd, callgraphPathsCmd, callgraphMetricsCmd, callgraphFindingCmd, callgraphDiffCmd)
+	rootCmd.AddCommand(callgraphCmd)
+}
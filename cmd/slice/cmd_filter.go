@@ -10,17 +10,19 @@ import (
 )
 
 var (
-	llmBaseURL         string
-	llmModel           string
-	llmTemp            float32
-	llmMaxTokens       int
-	llmReasoningEffort string
-	promptTemplate     string
-	inputFile          string
-	outputFile         string
-	timeout            int
-	concurrency        int
-	outputAll          bool
+	llmBaseURL            string
+	llmModel              string
+	llmTemp               float32
+	llmMaxTokens          int
+	llmReasoningEffort    string
+	promptTemplate        string
+	inputFile             string
+	outputFile            string
+	timeout               int
+	concurrency           int
+	outputAll             bool
+	filterSkipPrefiltered bool
+	filterContext         string
 )
 
 
@@ -36,9 +38,15 @@ Template metadata can specify custom behavior via comments:
   {{/* type: custom_type */}}
   {{/* timeout: 180 */}}
   {{/* max_tokens: 32000 */}}
+  {{/* context: slice */}}  (show functions sliced around the freed object instead of in full;
+                              --context full|slice overrides it)
+  {{/* token_budget: 24000 */}}  (pack each finding's function code into this many o200k_base tokens)
   {{/* schema: {"type": "object", "properties": {"valid": {"type": "boolean"}}} */}}
 
 By default, only valid/vulnerable results are output. Use --all to output everything.
+Findings that slice query's prefilter marked as likely false positives are still sent to
+the LLM, with the verdict kept in their "prefilter" field; use --skip-prefiltered to mark
+them invalid without a model call.
 
 Examples:
   # Process with custom template
@@ -50,9 +58,19 @@ Examples:
   # Use custom template with embedded schema
   slice filter --input query-results.json -p custom-template.tmpl
   
+  # Triage with functions sliced around the freed object
+  slice filter --context slice --input results.json -p spec/uaf/triage.tmpl --model gpt-4
+
   # Output all results including invalid ones
-  slice filter --all --input results.json -p spec/uaf/detailed.tmpl --model gpt-4`,
+  slice filter --all --input results.json -p spec/uaf/detailed.tmpl --model gpt-4
+
+  # Ask whether the free and use can race, using the lock and entry point annotations
+  slice filter --input results.json -p spec/uaf/race.tmpl --model gpt-4`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if filterContext != "" && filterContext != "full" && filterContext != "slice" {
+			return fmt.Errorf("invalid --context %q: must be full or slice", filterContext)
+		}
+
 		processorConfig := llm.Config{
 			APIKey:          "",
 			BaseURL:         llmBaseURL,
@@ -69,10 +87,12 @@ Examples:
 		}
 
 		pipelineConfig := llm.PipelineConfig{
-			Timeout:        time.Duration(timeout) * time.Second,
-			Concurrency:    concurrency,
-			PromptTemplate: promptTemplate,
-			OutputAll:      outputAll,
+			Timeout:         time.Duration(timeout) * time.Second,
+			Concurrency:     concurrency,
+			PromptTemplate:  promptTemplate,
+			OutputAll:       outputAll,
+			SkipPrefiltered: filterSkipPrefiltered,
+			Context:         filterContext,
 		}
 
 
@@ -115,6 +135,12 @@ func init() {
 	filterCmd.Flags().BoolVarP(&outputAll, "all", "a", false,
 		"Output all results regardless of validity (default: only output valid/vulnerable results)")
 
+	filterCmd.Flags().BoolVar(&filterSkipPrefiltered, "skip-prefiltered", false,
+		"Mark findings the query prefilter flagged as likely false positives invalid without sending them to the LLM")
+
+	filterCmd.Flags().StringVar(&filterContext, "context", "",
+		"Show functions in full or sliced around the freed object: full or slice (default: the template's context)")
+
 	rootCmd.AddCommand(filterCmd)
 }
 
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/noperator/slice/pkg/codeql"
+	"github.com/noperator/slice/pkg/parser"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	inferOutput string
+	inferFormat string
+	inferModel  string
+	inferNames  bool
+)
+
+var inferCmd = &cobra.Command{
+	Use:   "infer <directory>",
+	Short: "Infer allocator and deallocator wrappers",
+	Long: `Infer the memory model of a codebase: functions that unconditionally pass a parameter
+to a known deallocator (e.g. obj_put calling kfree) and functions whose every non-NULL
+return comes from a known allocator. Wrappers of wrappers are found by iterating to a
+fixpoint.
+
+Inference starts from the built-in functions, or from a YAML model file given with --model.
+The inferred model lists every deallocator it found and turns off the "%free%" name
+heuristic, so functions such as freeze_queue are not taken for deallocators; pass
+--name-heuristic to keep it. The result can be written as a YAML model file for
+"slice query --model", as the QL library spec/uaf/query.ql imports, or as JSON:
+
+  slice infer ./src -o models.yaml
+  slice query --model models.yaml ...`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var seed *parser.MemoryModel
+		if inferModel != "" {
+			var err error
+			if seed, err = codeql.LoadModelFile(inferModel); err != nil {
+				return err
+			}
+		}
+
+		result, err := parser.GetCachedAnalysisResult(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to analyze directory: %w", err)
+		}
+
+		model := parser.InferMemoryModel(result.Functions, seed)
+		model.NameHeuristic = inferNames
+		slog.Info("Inferred memory model", "component", "infer",
+			"deallocators", len(model.Deallocators), "allocators", len(model.Allocators))
+
+		var write func(io.Writer) error
+		switch inferFormat {
+		case "yaml":
+			write = func(w io.Writer) error {
+				encoder := yaml.NewEncoder(w)
+				encoder.SetIndent(2)
+				if err := encoder.Encode(codeql.NewModelConfig(model)); err != nil {
+					return err
+				}
+				return encoder.Close()
+			}
+		case "qll":
+			write = func(w io.Writer) error { return codeql.WriteModelQLL(w, model) }
+		case "json":
+			write = func(w io.Writer) error {
+				encoder := json.NewEncoder(w)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(model)
+			}
+		default:
+			return fmt.Errorf("unknown format %q (want yaml, qll or json)", inferFormat)
+		}
+
+		if inferOutput == "" {
+			return write(os.Stdout)
+		}
+		file, err := os.Create(inferOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		if err := write(file); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	},
+}
+
+func init() {
+	inferCmd.Flags().StringVarP(&inferOutput, "output", "o", "", "Write the model to a file instead of stdout")
+	inferCmd.Flags().StringVarP(&inferFormat, "format", "f", "yaml", "Output format: yaml, qll or json")
+	inferCmd.Flags().StringVarP(&inferModel, "model", "m", "", "YAML model file with project-specific functions to start from")
+	inferCmd.Flags().BoolVar(&inferNames, "name-heuristic", false, "Also treat any function named like \"free\" as a deallocator")
+	rootCmd.AddCommand(inferCmd)
+}
@@ -3,16 +3,21 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/noperator/slice/pkg/parser"
 	"github.com/spf13/cobra"
 )
 
+var parseCFG bool
+
 var parseCmd = &cobra.Command{
 	Use:   "parse <directory>",
 	Short: "Parse code and extract function information",
 	Long: `Parse source code in the specified directory and extract detailed function information
-including signatures, parameters, variables, function calls, and definitions.`,
+including signatures, parameters, variables, function calls, and definitions.
+
+With --cfg, also emit a statement-level control-flow graph for each function.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		directory := args[0]
@@ -22,7 +27,24 @@ including signatures, parameters, variables, function calls, and definitions.`,
 			return fmt.Errorf("failed to analyze directory: %w", err)
 		}
 
-		output, err := json.MarshalIndent(result, "", "  ")
+		var out interface{} = result
+		if parseCFG {
+			cfgs := make([]*parser.CFG, 0, len(result.Functions))
+			for i := range result.Functions {
+				cfg, err := parser.BuildCFG(&result.Functions[i])
+				if err != nil {
+					slog.Warn("Failed to build CFG", "component", "parse", "function", result.Functions[i].ID, "error", err)
+					continue
+				}
+				cfgs = append(cfgs, cfg)
+			}
+			out = struct {
+				*parser.AnalysisResult
+				CFGs []*parser.CFG `json:"cfgs"`
+			}{result, cfgs}
+		}
+
+		output, err := json.MarshalIndent(out, "", "  ")
 		if err != nil {
 			return fmt.Errorf("failed to marshal JSON: %w", err)
 		}
@@ -33,5 +55,6 @@ including signatures, parameters, variables, function calls, and definitions.`,
 }
 
 func init() {
+	parseCmd.Flags().BoolVar(&parseCFG, "cfg", false, "Include a control-flow graph for each function")
 	rootCmd.AddCommand(parseCmd)
 }
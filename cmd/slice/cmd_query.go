@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/noperator/slice/pkg/codeql"
@@ -18,8 +19,15 @@ var (
 	codeqlBin       string
 	sourceDir       string
 	noValidate      bool
+	noPrefilter     bool
 	callDepth       int
 	queryConcurrency int
+	modelFile       string
+	maxChains       int
+	chainBudget     time.Duration
+	entryFile       string
+	tokenBudget     int
+	countTokens     bool
 )
 
 var queryLogger *slog.Logger
@@ -31,7 +39,35 @@ var queryCmd = &cobra.Command{
 with full source code context using TreeSitter parsing.
 
 This command integrates CodeQL-based vulnerability detection with the existing 
-TreeSitter parsing infrastructure to provide comprehensive vulnerability reports.`,
+TreeSitter parsing infrastructure to provide comprehensive vulnerability reports.
+
+With --model, project-specific allocators, deallocators and barriers declared in a YAML
+file replace the query's models.qll for this run, and enrichment uses the same model:
+
+  name_heuristic: false    # don't treat every "%free%" function as a deallocator
+  functions:
+    - {name: obj_put, kind: deallocator, arg: 0}
+    - {name: obj_new, kind: allocator}
+    - {name: obj_reinit, kind: barrier, arg: 0}
+
+"slice infer" generates such a file from the wrappers it finds in the source.
+
+Each validated finding records whether entry points reach its free and use functions, by the
+shortest path from one. main, LLVMFuzzerTestOneInput and SYSCALL_DEFINE* handlers are entry
+points by default; --entry-points adds project-specific ones from a YAML file:
+
+  entry_points:
+    - {kind: api, prefix: png_}             # exported functions named png_*
+    - {kind: ops, field: unlocked_ioctl}    # functions stored into ->unlocked_ioctl
+    - {kind: ops}                           # any function stored into a struct member
+
+With --count-tokens or --token-budget, each finding records the o200k_base tokens of its
+function code, counted offline. With --token-budget, findings over it are packed: intermediate
+functions are cut down to their slices, then the free and use functions, then intermediate
+functions to the lines around their chain calls, then the free and use functions to the lines
+around the free and use. A finding still over budget has its functions truncated, intermediate
+ones first. Each cut is marked in the code. Templates set their own budget with a token_budget
+comment, applied when "slice filter" renders them.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		queryLogger = logging.NewLoggerFromEnv()
 
@@ -54,13 +90,36 @@ TreeSitter parsing infrastructure to provide comprehensive vulnerability reports
 			return fmt.Errorf("CodeQL not available: %w", err)
 		}
 
+		runQuery := queryFile
+		if modelFile != "" {
+			model, err := codeql.LoadModelFile(modelFile)
+			if err != nil {
+				return err
+			}
+			parser.UseMemoryModel(model)
+
+			var cleanup func()
+			runQuery, cleanup, err = codeql.PrepareModelQuery(queryFile, model)
+			if err != nil {
+				return fmt.Errorf("failed to apply model file: %w", err)
+			}
+			defer cleanup()
+
+			queryLogger.Info("applied memory model",
+				"component", "codeql",
+				"model_file", modelFile,
+				"deallocators", len(model.Deallocators),
+				"allocators", len(model.Allocators),
+				"barriers", len(model.Barriers))
+		}
+
 		queryLogger.Info("running codeql query",
 			"component", "codeql",
 			"operation", "query",
 			"query_file", queryFile,
 			"database", database)
 
-		codeqlResults, err := executor.RunQuery(database, queryFile)
+		codeqlResults, err := executor.RunQuery(database, runQuery)
 		if err != nil {
 			return fmt.Errorf("failed to run CodeQL query: %w", err)
 		}
@@ -80,13 +139,40 @@ TreeSitter parsing infrastructure to provide comprehensive vulnerability reports
 			if err != nil {
 				return fmt.Errorf("failed to parse source code for call graph: %w", err)
 			}
-			callGraph = codeql.BuildCallGraph(analysisResult.Functions)
+			callGraph = codeql.BuildCallGraph(analysisResult.Functions, analysisResult.FunctionPointers...)
+			callGraph.SetPathOptions(codeql.PathOptions{MaxPaths: maxChains, MaxDepth: codeql.DefaultPathOptions.MaxDepth, Budget: chainBudget})
 			queryLogger.Info("call graph built",
 				"component", "codeql",
-				"functions", len(analysisResult.Functions))
+				"functions", len(analysisResult.Functions),
+				"function_pointers", len(analysisResult.FunctionPointers))
+
+			entryConfig := codeql.DefaultEntryConfig()
+			if entryFile != "" {
+				if entryConfig, err = codeql.LoadEntryConfig(entryFile); err != nil {
+					return err
+				}
+			}
+			entries := entryConfig.Resolve(analysisResult)
+			if callGraph.SetEntryPoints(entries) > 0 {
+				kinds := make(map[string]int)
+				for _, entry := range entries {
+					kinds[entry.Kind]++
+				}
+				queryLogger.Info("entry points found",
+					"component", "codeql",
+					"entry_points", len(entries),
+					"kinds", kinds)
+			} else {
+				queryLogger.Warn("no entry points found; findings are not annotated with entry reachability",
+					"component", "codeql",
+					"entry_file", entryFile)
+			}
 		}
 
 		enricher := codeql.NewQueryEnricher(sourceDir)
+		enricher.SetPrefilter(!noPrefilter)
+		enricher.SetTokenBudget(tokenBudget)
+		enricher.SetCountTokens(countTokens)
 		findings, err := enricher.EnrichResults(codeqlResults, callGraph, validateCalls, callDepth, queryConcurrency)
 		if err != nil {
 			return fmt.Errorf("failed to enrich query results: %w", err)
@@ -98,6 +184,8 @@ TreeSitter parsing infrastructure to provide comprehensive vulnerability reports
 				CodeQLResult:   finding.CodeQLResult,
 				SourceCode:     finding.SourceCode,
 				CallValidation: finding.CallValidation,
+				Prefilter:      finding.Prefilter,
+				Annotations:    finding.Annotations,
 			}
 			results = append(results, unifiedResult)
 		}
@@ -131,7 +219,14 @@ func init() {
 	queryCmd.Flags().StringVarP(&sourceDir, "source", "s", "", "Path to source code directory (required)")
 	queryCmd.Flags().StringVarP(&codeqlBin, "codeql-bin", "b", "", "Path to CodeQL CLI binary (default: resolve from PATH)")
 	queryCmd.Flags().BoolVar(&noValidate, "no-validate", false, "Disable call chain validation")
+	queryCmd.Flags().BoolVar(&noPrefilter, "no-prefilter", false, "Disable static likely-false-positive checks (null-after-free, reassignment, ordering)")
 	queryCmd.Flags().IntVarP(&callDepth, "call-depth", "c", -1, "Maximum call chain depth (-1 = no limit)")
+	queryCmd.Flags().IntVar(&maxChains, "max-chains", codeql.DefaultPathOptions.MaxPaths, "Maximum call chains enumerated per finding, shortest first")
+	queryCmd.Flags().DurationVar(&chainBudget, "chain-budget", codeql.DefaultPathOptions.Budget, "Time allowed for enumerating each finding's call chains (0 = no limit)")
+	queryCmd.Flags().StringVarP(&modelFile, "model", "m", "", "YAML file declaring project-specific allocators, deallocators and barriers")
+	queryCmd.Flags().StringVarP(&entryFile, "entry-points", "e", "", "YAML file declaring entry points in addition to main, LLVMFuzzerTestOneInput and SYSCALL_DEFINE*")
+	queryCmd.Flags().IntVar(&tokenBudget, "token-budget", 0, "Pack each finding's function code into this many o200k_base tokens (0 = no limit)")
+	queryCmd.Flags().BoolVar(&countTokens, "count-tokens", false, "Record each finding's function code token count without a --token-budget")
 	queryCmd.Flags().IntVarP(&queryConcurrency, "concurrency", "j", 0, "Number of concurrent workers for result processing (0 = auto-detect based on CPU cores)")
 	
 	queryCmd.MarkFlagRequired("database")
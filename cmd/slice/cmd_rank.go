@@ -9,7 +9,9 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/noperator/raink/pkg/raink"
+	"github.com/noperator/slice/pkg/codeql"
 	"github.com/noperator/slice/pkg/llm"
+	"github.com/noperator/slice/pkg/parser"
 	"github.com/openai/openai-go"
 )
 
@@ -20,6 +22,8 @@ var (
 	rankRuns        int
 	rankBatchSize   int
 	rankRatio       float64
+	rankScore       bool
+	rankDemote      bool
 )
 
 
@@ -30,6 +34,55 @@ func formatResultForRanking(result llm.UnifiedResult) string {
 		parts = append(parts, fmt.Sprintf("File: %s:%d", result.CodeQLResult.FreeFunctionFile, result.CodeQLResult.FreeLine))
 	}
 	
+	for _, alloc := range result.SourceCode.Allocations {
+		parts = append(parts, fmt.Sprintf("alloc: %s", alloc.Call))
+	}
+	
+	if result.Annotations != nil {
+		if site := describeSite(result.Annotations.Free); site != "" {
+			parts = append(parts, "free_site: "+site)
+		}
+		if site := describeSite(result.Annotations.Use); site != "" {
+			parts = append(parts, "use_site: "+site)
+		}
+		if primitive := result.Annotations.UsePrimitive; primitive != nil {
+			parts = append(parts, fmt.Sprintf("use_primitive: %s (%s)", primitive.Class, primitive.Expression))
+		}
+		if refcount := result.Annotations.Refcount; refcount != nil {
+			parts = append(parts, fmt.Sprintf("refcount: %d gets, %d puts", len(refcount.Gets), len(refcount.Puts)))
+			if refcount.ViaPut != "" {
+				parts = append(parts, "free_via_put: "+refcount.ViaPut)
+			}
+		}
+		if entry := result.Annotations.Entry; entry != nil {
+			if entry.Reachable {
+				parts = append(parts, "attack_surface: "+strings.Join(entry.Kinds, ", "))
+			} else {
+				parts = append(parts, "attack_surface: none, no entry point reaches it")
+			}
+		}
+		if metrics := result.Annotations.Metrics; metrics != nil {
+			if free := describeMetrics(metrics.Free); free != "" {
+				parts = append(parts, "free_func_metrics: "+free)
+			}
+			if metrics.Use != metrics.Free {
+				if use := describeMetrics(metrics.Use); use != "" {
+					parts = append(parts, "use_func_metrics: "+use)
+				}
+			}
+		}
+		if concurrency := result.Annotations.Concurrency; concurrency != nil {
+			if len(concurrency.CommonLocks) > 0 {
+				parts = append(parts, "same_lock: "+strings.Join(concurrency.CommonLocks, ", "))
+			}
+			for _, function := range concurrency.Functions {
+				for _, entry := range function.Entries {
+					parts = append(parts, fmt.Sprintf("entry: %s (%s)", entry.Function, entry.Kind))
+				}
+			}
+		}
+	}
+	
 	for key, dynamicResult := range result.DynamicResults {
 		if resultMap, ok := dynamicResult.(map[string]interface{}); ok {
 			if validValue, hasValid := resultMap["valid"]; hasValid {
@@ -53,6 +106,63 @@ func formatResultForRanking(result llm.UnifiedResult) string {
 	return strings.Join(parts, " | ")
 }
 
+// describeSite summarizes a site's static classification for the ranking prompt
+func describeSite(site *parser.SiteContext) string {
+	if site == nil {
+		return ""
+	}
+	var labels []string
+	if site.Destructor {
+		labels = append(labels, "destructor")
+	}
+	if site.CleanupLabel != "" {
+		labels = append(labels, "cleanup label "+site.CleanupLabel)
+	}
+	if site.ErrorPath {
+		labels = append(labels, "error path")
+	}
+	return strings.Join(labels, ", ")
+}
+
+// describeMetrics summarizes a function's call graph metrics for the ranking prompt
+func describeMetrics(metrics *codeql.FunctionMetrics) string {
+	if metrics == nil {
+		return ""
+	}
+	description := fmt.Sprintf("fan_in=%d fan_out=%d betweenness_pct=%.2f complexity=%d lines=%d",
+		metrics.FanIn, metrics.FanOut, metrics.BetweennessPct, metrics.Complexity, metrics.Lines)
+	if metrics.EntryDistance != nil {
+		description += fmt.Sprintf(" entry_dist=%d", *metrics.EntryDistance)
+	}
+	if metrics.Recursive {
+		description += fmt.Sprintf(" recursive (scc_size=%d)", metrics.SCCSize)
+	}
+	return description
+}
+
+// scoreResults ranks findings by their deterministic static score, keeping input order on ties
+func scoreResults(results []llm.UnifiedResult) {
+	for i := range results {
+		score, features := codeql.Score(results[i].Annotations, results[i].Prefilter)
+		results[i].Rank = &llm.RankInfo{Score: score, Features: features}
+	}
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return results[order[i]].Rank.Score > results[order[j]].Rank.Score
+	})
+	for pos, i := range order {
+		results[i].Rank.Pos = pos + 1
+	}
+}
+
+// unreachableFromEntry reports whether entry points were found but none reaches a finding
+func unreachableFromEntry(result llm.UnifiedResult) bool {
+	return result.Annotations != nil && result.Annotations.Entry != nil && !result.Annotations.Entry.Reachable
+}
+
 func getVerdictStatus(isVulnerable bool) string {
 	if isVulnerable {
 		return "vulnerable"
@@ -71,6 +181,15 @@ This command takes vulnerability processing results and ranks them based on fact
 - Impact if successfully exploited  
 - Whether the vulnerability is in a critical code path
 
+With --score, findings are instead ranked without a model, by a reproducible weighted score
+over their static annotations: use primitive, entry point reachability and distance, the free
+function's fan-in, betweenness, complexity, error-path frees and recursion. Findings the
+prefilter flags as likely false positives score a quarter.
+
+With --demote-unreachable, findings that "slice query" found no entry point to reach rank
+below every reachable one. Otherwise reachability only counts through the model's judgement
+or the --score features.
+
 The ranking is performed using the raink library, which uses pairwise comparisons
 to establish relative rankings of findings.
 
@@ -79,7 +198,10 @@ Examples:
   slice filter -i query.json -p spec/uaf/custom.tmpl | slice rank -p spec/uaf/rank.tmpl
 
   # Rank with custom parameters  
-  slice rank -i filtered.json -m gpt-4o -r 20 -s 5 --ratio 0.7`,
+  slice rank -i filtered.json -m gpt-4o -r 20 -s 5 --ratio 0.7
+
+  # Rank deterministically, without a model
+  slice rank -i query.json --score`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var decoder *json.Decoder
 		if rankInputFile == "" {
@@ -101,6 +223,11 @@ Examples:
 			return fmt.Errorf("no results to rank")
 		}
 
+		if rankScore {
+			scoreResults(inputResults.Results)
+			return writeRankedResults(&inputResults, rankDemote)
+		}
+
 		promptBytes, err := os.ReadFile(rankPromptFile)
 		if err != nil {
 			return fmt.Errorf("failed to read prompt file: %w", err)
@@ -199,24 +326,39 @@ Examples:
 			}
 		}
 
-		sort.Slice(inputResults.Results, func(i, j int) bool {
-			if inputResults.Results[i].Rank == nil {
-				return false
-			}
-			if inputResults.Results[j].Rank == nil {
-				return true
-			}
-			return inputResults.Results[i].Rank.Pos < inputResults.Results[j].Rank.Pos
-		})
+		return writeRankedResults(&inputResults, rankDemote)
+	},
+}
 
-		encoder := json.NewEncoder(os.Stdout)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(inputResults); err != nil {
-			return fmt.Errorf("failed to encode output JSON: %w", err)
+// writeRankedResults orders ranked results by position and writes them to stdout. With
+// demoteUnreachable, findings no entry point reaches rank below every reachable one.
+func writeRankedResults(output *llm.UnifiedOutput, demoteUnreachable bool) error {
+	results := output.Results
+	sort.Slice(results, func(i, j int) bool {
+		if ui, uj := unreachableFromEntry(results[i]), unreachableFromEntry(results[j]); demoteUnreachable && ui != uj {
+			return uj
+		}
+		if results[i].Rank == nil {
+			return false
 		}
+		if results[j].Rank == nil {
+			return true
+		}
+		return results[i].Rank.Pos < results[j].Rank.Pos
+	})
+	for i := range results {
+		if results[i].Rank != nil {
+			results[i].Rank.Pos = i + 1
+		}
+	}
 
-		return nil
-	},
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("failed to encode output JSON: %w", err)
+	}
+
+	return nil
 }
 
 func init() {
@@ -226,6 +368,8 @@ func init() {
 	rankCmd.Flags().IntVarP(&rankRuns, "runs", "r", 10, "Number of ranking runs")
 	rankCmd.Flags().IntVarP(&rankBatchSize, "batch-size", "s", 10, "Batch size for ranking")
 	rankCmd.Flags().Float64Var(&rankRatio, "ratio", 0.5, "Refinement ratio")
+	rankCmd.Flags().BoolVar(&rankScore, "score", false, "Rank by a deterministic score over static annotations instead of a model")
+	rankCmd.Flags().BoolVar(&rankDemote, "demote-unreachable", false, "Rank findings no entry point reaches below every reachable one")
 
 	rootCmd.AddCommand(rankCmd)
 }
\ No newline at end of file
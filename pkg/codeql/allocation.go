@@ -0,0 +1,279 @@
+package codeql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// AllocationSite is where a freed object was allocated
+type AllocationSite struct {
+	Allocator  string   `json:"allocator"`
+	Size       string   `json:"size,omitempty"`  // Size expression, e.g. "sizeof(*b)" or "n * sizeof(int)"
+	Cache      string   `json:"cache,omitempty"` // Slab cache for kmem_cache_alloc and friends
+	Function   string   `json:"func"`
+	FunctionID string   `json:"func_id"`
+	Filename   string   `json:"file"`
+	Line       int      `json:"line"`
+	Call       string   `json:"call"`
+	Via        []string `json:"via,omitempty"` // Steps followed from the free back to the allocation
+}
+
+// maxAllocationDepth caps how many functions are crossed looking for an allocation
+const maxAllocationDepth = 4
+
+// maxAllocationSites caps how many allocation sites are attached to a finding
+const maxAllocationSites = 8
+
+// allocationSearch follows a freed object back to its allocation sites
+type allocationSearch struct {
+	sourceDir      string
+	analysisResult *parser.AnalysisResult
+	parsed         *parsedFunctions
+	reaching       map[string][][]int
+	seen           map[string]bool
+	sites          []AllocationSite
+}
+
+// findAllocations follows the freed object back through assignments, constructor return
+// values, callers' arguments and struct member writes to the allocator calls that produced it
+func (e *QueryEnricher) findAllocations(freeFunc *parser.Function, result CodeQLResult, parsed *parsedFunctions) []AllocationSite {
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return nil
+	}
+	search := &allocationSearch{
+		sourceDir:      e.sourceDir,
+		analysisResult: analysisResult,
+		parsed:         parsed,
+		reaching:       make(map[string][][]int),
+		seen:           make(map[string]bool),
+	}
+	search.object(freeFunc, parser.NormalizeTarget(freedExpression(freeFunc, result)), result.FreeLine, nil, 0)
+	return search.sites
+}
+
+// dataflow returns the dataflow of a function, computing its reaching definitions on first use
+func (s *allocationSearch) dataflow(function *parser.Function) *parser.Dataflow {
+	parsed, err := s.parsed.get(function)
+	if err != nil {
+		return nil
+	}
+	dataflow := parsed.Dataflow()
+	if _, ok := s.reaching[function.ID]; !ok {
+		s.reaching[function.ID] = dataflow.ReachingDefinitions()
+	}
+	return dataflow
+}
+
+// object finds the allocations of an object as seen on a line of a function
+func (s *allocationSearch) object(function *parser.Function, object string, line int, via []string, depth int) {
+	key := fmt.Sprintf("%s:%d:%s", function.ID, line, object)
+	if object == "" || depth > maxAllocationDepth || s.seen[key] || len(s.sites) >= maxAllocationSites {
+		return
+	}
+	s.seen[key] = true
+
+	dataflow := s.dataflow(function)
+	if dataflow == nil {
+		return
+	}
+	node := dataflow.CFG.NodeAtLine(line)
+	if node < 0 {
+		return
+	}
+
+	found := false
+	for _, index := range s.reaching[function.ID][node] {
+		def := dataflow.Defs[index]
+		if def.Kind != "free" && def.Target == object {
+			s.value(function, def.Value, def.Line, via, depth)
+			found = true
+		}
+	}
+	if found {
+		return
+	}
+
+	// No local definition: the object came from a struct member, a caller or a global
+	path := parseObjectPath(object)
+	if len(path.Fields) > 0 {
+		s.memberWrites(function, path, via, depth)
+		return
+	}
+	if variable := findVariable(function, path.Base); variable != nil {
+		if variable.Origin == "param" {
+			s.callerArguments(function, path.Base, via, depth)
+		}
+		return
+	}
+	if global := s.analysisResult.LookupGlobal(path.Base, function.Filename); global != nil {
+		for _, access := range global.Accesses {
+			if access.Kind == "write" {
+				s.writesAt(access.FunctionID, access.Line, path.Base, via, depth)
+			}
+		}
+	}
+}
+
+// value finds the allocations behind an assigned or returned expression
+func (s *allocationSearch) value(function *parser.Function, value string, line int, via []string, depth int) {
+	call := strings.TrimSpace(parser.StripCasts(value))
+
+	for _, callee := range function.Callees {
+		if callee.Line != line || !strings.HasPrefix(call, callee.Name+"(") {
+			continue
+		}
+		if parser.IsAllocator(callee.Name) {
+			size, cache := allocationSize(callee.Name, callee.Args)
+			s.sites = append(s.sites, AllocationSite{
+				Allocator:  callee.Name,
+				Size:       size,
+				Cache:      cache,
+				Function:   function.Name,
+				FunctionID: function.ID,
+				Filename:   relativePath(s.sourceDir, function.Filename),
+				Line:       callee.Line,
+				Call:       callee.Name + "(" + strings.Join(callee.Args, ", ") + ")",
+				Via:        via,
+			})
+			return
+		}
+
+		// A constructor or allocator wrapper: follow what it returns
+		if constructor := s.analysisResult.LookupFunction(callee.Name, function.Filename); constructor != nil {
+			step := via
+			if description := fmt.Sprintf("%s L%d: %s", function.Name, callee.Line, callee.Snippet); len(via) == 0 || via[len(via)-1] != description {
+				step = append(append([]string(nil), via...), description)
+			}
+			dataflow := s.dataflow(constructor)
+			if dataflow == nil {
+				return
+			}
+			for _, node := range dataflow.CFG.Nodes {
+				if node.Kind != "return" {
+					continue
+				}
+				returned := strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(node.Text, "return")), ";")
+				if parser.IsNullValue(returned) || returned == "" {
+					continue
+				}
+				s.returned(constructor, returned, node.Line, step, depth+1)
+			}
+		}
+		return
+	}
+
+	if object := parser.NormalizeTarget(call); isObjectExpression(object) {
+		s.object(function, object, line, via, depth)
+	}
+}
+
+// returned finds the allocations behind a function's return value
+func (s *allocationSearch) returned(function *parser.Function, returned string, line int, via []string, depth int) {
+	if object := parser.NormalizeTarget(parser.StripCasts(returned)); isObjectExpression(object) {
+		s.object(function, object, line, via, depth)
+		return
+	}
+	s.value(function, returned, line, via, depth)
+}
+
+// memberWrites follows a struct member path to every assignment of that member
+func (s *allocationSearch) memberWrites(function *parser.Function, path objectPath, via []string, depth int) {
+	rootType := objectRootType(s.analysisResult, function, path.Base)
+	if rootType == "" {
+		return
+	}
+	containers := path.Fields[:len(path.Fields)-1]
+	types := s.analysisResult.ResolveFieldPath(rootType, containers)
+	if len(types) != len(containers)+1 {
+		return
+	}
+	field := path.Fields[len(path.Fields)-1]
+	for _, access := range s.analysisResult.FieldAccessesOf(types[len(types)-1], field) {
+		if access.Kind == "write" {
+			s.writesAt(access.FunctionID, access.Line, access.Expression, via, depth)
+		}
+	}
+}
+
+// writesAt follows the assignments to an object on a given line of a function
+func (s *allocationSearch) writesAt(functionID string, line int, target string, via []string, depth int) {
+	function := s.analysisResult.FunctionByID(functionID)
+	if function == nil {
+		return
+	}
+	dataflow := s.dataflow(function)
+	if dataflow == nil {
+		return
+	}
+	target = parser.NormalizeTarget(target)
+	for _, def := range dataflow.Defs {
+		if def.Line == line && def.Kind != "free" && def.Target == target {
+			step := append(append([]string(nil), via...), fmt.Sprintf("%s L%d: %s = %s", function.Name, def.Line, def.Target, def.Value))
+			s.value(function, def.Value, def.Line, step, depth+1)
+		}
+	}
+}
+
+// maxAllocationCallers caps how many call sites are followed for a parameter
+const maxAllocationCallers = 8
+
+// callerArguments follows a parameter to the arguments callers pass for it
+func (s *allocationSearch) callerArguments(function *parser.Function, param string, via []string, depth int) {
+	index := -1
+	for i, p := range function.Params {
+		if parser.ParameterName(p.Snippet) == param {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return
+	}
+
+	callers := 0
+	for _, caller := range s.analysisResult.CallersOf(function.Name) {
+		for _, callee := range caller.Callees {
+			if callee.Name != function.Name || index >= len(callee.Args) || callers >= maxAllocationCallers {
+				continue
+			}
+			callers++
+			arg := strings.TrimLeft(parser.NormalizeTarget(callee.Args[index]), "&")
+			step := append(append([]string(nil), via...), fmt.Sprintf("%s L%d: %s", caller.Name, callee.Line, callee.Snippet))
+			s.value(caller, arg, callee.Line, step, depth+1)
+		}
+	}
+}
+
+// allocationSize returns the size expression and slab cache of an allocator call
+func allocationSize(allocator string, args []string) (size, cache string) {
+	arg := func(i int) string {
+		if i < len(args) {
+			return strings.TrimSpace(args[i])
+		}
+		return ""
+	}
+	switch allocator {
+	case "calloc", "kcalloc", "kmalloc_array", "kvcalloc", "reallocarray":
+		offset := 0
+		if allocator == "reallocarray" {
+			offset = 1
+		}
+		if arg(offset) != "" && arg(offset+1) != "" {
+			return arg(offset) + " * " + arg(offset+1), ""
+		}
+	case "realloc", "krealloc", "kmemdup", "devm_kzalloc", "devm_kmalloc":
+		return arg(1), ""
+	case "aligned_alloc":
+		return arg(1), ""
+	case "strdup", "kstrdup":
+		return "strlen(" + arg(0) + ") + 1", ""
+	case "strndup":
+		return arg(1) + " + 1", ""
+	case "kmem_cache_alloc", "kmem_cache_zalloc":
+		return "", arg(0)
+	}
+	return arg(0), ""
+}
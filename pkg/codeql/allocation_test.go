@@ -0,0 +1,57 @@
+package codeql
+
+import "testing"
+
+const allocationSource = `struct buf { char *data; };
+void *kmalloc(unsigned long size, int flags);
+void kfree(void *p);
+struct buf *buf_create(int n)
+{
+	struct buf *b = kmalloc(sizeof(*b), 0);
+	b->data = kmalloc(n, 0);
+	return b;
+}
+void release(struct buf *b)
+{
+	kfree(b->data);
+	kfree(b);
+}
+void run(void)
+{
+	struct buf *b = buf_create(8);
+	release(b);
+}
+`
+
+func TestFindAllocations(t *testing.T) {
+	f := loadC(t, allocationSource)
+	parsed := newParsedFunctions()
+	defer parsed.close()
+
+	tests := []struct {
+		name     string
+		object   string
+		freeLine int
+		line     int // Line of the allocation site
+		size     string
+	}{
+		{"through the caller and constructor", "b", 13, 6, "sizeof(*b)"},
+		{"member written in the constructor", "data", 12, 7, "n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := f.result(t, tt.object, "release", tt.freeLine, "release", tt.freeLine)
+			sites := f.enricher.findAllocations(f.functions["release"], result, parsed)
+			if len(sites) != 1 {
+				t.Fatalf("got %d allocation sites %+v, want 1", len(sites), sites)
+			}
+			site := sites[0]
+			if site.Function != "buf_create" || site.Line != tt.line || site.Size != tt.size {
+				t.Errorf("got %s L%d size %q, want buf_create L%d size %q", site.Function, site.Line, site.Size, tt.line, tt.size)
+			}
+			if site.Filename != "test.c" {
+				t.Errorf("filename = %q, want it relative to the source directory", site.Filename)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package codeql
+
+import "sort"
+
+// Orders in which a common caller reaches the free and the use
+const (
+	OrderFreeFirst = "free_first" // The call leading to the free comes first, e.g. "cleanup(x); use(x);"
+	OrderUseFirst  = "use_first"
+	OrderSameLine  = "same_line"
+)
+
+// Bounds on the common-ancestor search, so a hub function with thousands of callers stays cheap
+const (
+	maxAncestorVisits = 20000
+	maxCommonCallers  = 10
+)
+
+// CommonCaller is a function that calls down to both the free and the use function
+type CommonCaller struct {
+	Caller       string    `json:"caller"`
+	CallerID     string    `json:"caller_id"`
+	FreeDepth    int       `json:"free_depth"`             // Calls from the caller down to the free function
+	UseDepth     int       `json:"use_depth"`              // Calls from the caller down to the use function
+	FreeCallLine int       `json:"free_call_ln,omitempty"` // First call in the caller leading to the free
+	UseCallLine  int       `json:"use_call_ln,omitempty"`  // First call in the caller leading to the use
+	Order        string    `json:"order,omitempty"`        // free_first, use_first or same_line; empty when unknown
+	FreePath     CallChain `json:"-"`
+	UsePath      CallChain `json:"-"`
+
+	index int32 // Caller's index in the call graph
+}
+
+// ancestorSearch is a bounded reverse BFS from one function: the distance of each ancestor,
+// and the next function on its shortest route down to the start. It lives in one side of a
+// search scratch.
+type ancestorSearch struct {
+	stamp   uint32
+	mark    []uint32
+	depth   []int32
+	next    []int32
+	visited []int32 // Ancestors in BFS order, excluding the start
+}
+
+// reverseBFS walks callers of a function up to maxDepth calls away, stopping after
+// maxAncestorVisits ancestors
+func (cg *CallGraph) reverseBFS(search ancestorSearch, start int32, maxDepth int) ancestorSearch {
+	search.mark[start], search.depth[start], search.next[start] = search.stamp, 0, -1
+	frontier := []int32{start}
+	for depth := 1; depth <= maxDepth && len(frontier) > 0; depth++ {
+		var nextFrontier []int32
+		for _, id := range frontier {
+			for _, caller := range cg.callers.neighbours(id) {
+				if search.mark[caller] == search.stamp {
+					continue
+				}
+				if len(search.visited) >= maxAncestorVisits {
+					return search
+				}
+				search.mark[caller], search.depth[caller], search.next[caller] = search.stamp, int32(depth), id
+				search.visited = append(search.visited, caller)
+				nextFrontier = append(nextFrontier, caller)
+			}
+		}
+		frontier = nextFrontier
+	}
+	return search
+}
+
+// reached reports whether the search found a function
+func (s ancestorSearch) reached(id int32) bool {
+	return s.mark[id] == s.stamp
+}
+
+// pathFrom follows the BFS tree from an ancestor down to the search's start
+func (s ancestorSearch) pathFrom(id int32) []int32 {
+	path := []int32{id}
+	for next := s.next[id]; next >= 0; next = s.next[next] {
+		path = append(path, next)
+	}
+	return path
+}
+
+// passesThrough reports whether the route from an ancestor down to the search's start goes
+// through any other function in a set
+func (s ancestorSearch) passesThrough(id int32, set map[int32]bool) bool {
+	for next := s.next[id]; next >= 0; next = s.next[next] {
+		if set[next] {
+			return true
+		}
+	}
+	return false
+}
+
+// commonAncestors intersects the callers of the free and the use function found by bounded
+// reverse BFS, keeping only the closest: those whose routes down to the free and the use do
+// not pass through another common caller. Callers that reach the free before the use come
+// first, as they are the "caller frees, then calls user" pattern; ties go to the nearest.
+func (cg *CallGraph) commonAncestors(freeID, useID int32, maxDepth int) []CommonCaller {
+	query := pathQuery{kind: 'a', from: freeID, to: useID, depth: maxDepth}
+	if cached, ok := cg.pathCache.Load(query); ok {
+		return append([]CommonCaller(nil), cached.([]CommonCaller)...)
+	}
+
+	scratch := cg.scratch()
+	defer cg.scratchPool.Put(scratch)
+	free := cg.reverseBFS(ancestorSearch{stamp: scratch.stamp, mark: scratch.forward, depth: scratch.depth, next: scratch.parent}, freeID, maxDepth)
+	use := cg.reverseBFS(ancestorSearch{stamp: scratch.stamp, mark: scratch.backward, depth: scratch.otherDepth, next: scratch.next}, useID, maxDepth)
+
+	var candidates []int32
+	common := make(map[int32]bool)
+	for _, id := range free.visited {
+		if !use.reached(id) || id == freeID || id == useID {
+			continue
+		}
+		if free.next[id] == use.next[id] {
+			continue // Both routes go through the same callee, which is a closer common caller
+		}
+		candidates = append(candidates, id)
+		common[id] = true
+	}
+
+	// Keep only the closest callers: drop any whose route passes through another common caller
+	var ancestors []CommonCaller
+	for _, id := range candidates {
+		if free.passesThrough(id, common) || use.passesThrough(id, common) {
+			continue
+		}
+		ancestor := CommonCaller{
+			Caller:       extractFunctionName(cg.ids[id]),
+			CallerID:     cg.ids[id],
+			FreeDepth:    int(free.depth[id]),
+			UseDepth:     int(use.depth[id]),
+			FreeCallLine: cg.callLine(id, free.next[id]),
+			UseCallLine:  cg.callLine(id, use.next[id]),
+			index:        id,
+		}
+		switch {
+		case ancestor.FreeCallLine == 0 || ancestor.UseCallLine == 0:
+		case ancestor.FreeCallLine < ancestor.UseCallLine:
+			ancestor.Order = OrderFreeFirst
+		case ancestor.FreeCallLine > ancestor.UseCallLine:
+			ancestor.Order = OrderUseFirst
+		default:
+			ancestor.Order = OrderSameLine
+		}
+		ancestors = append(ancestors, ancestor)
+	}
+
+	sort.Slice(ancestors, func(i, j int) bool {
+		a, b := ancestors[i], ancestors[j]
+		if (a.Order == OrderFreeFirst) != (b.Order == OrderFreeFirst) {
+			return a.Order == OrderFreeFirst
+		}
+		if a.FreeDepth+a.UseDepth != b.FreeDepth+b.UseDepth {
+			return a.FreeDepth+a.UseDepth < b.FreeDepth+b.UseDepth
+		}
+		return a.CallerID < b.CallerID
+	})
+	if len(ancestors) > maxCommonCallers {
+		ancestors = ancestors[:maxCommonCallers]
+	}
+	for i := range ancestors {
+		ancestors[i].FreePath = cg.newCallChain(free.pathFrom(ancestors[i].index))
+		ancestors[i].UsePath = cg.newCallChain(use.pathFrom(ancestors[i].index))
+	}
+
+	cg.pathCache.Store(query, ancestors)
+	return append([]CommonCaller(nil), ancestors...)
+}
@@ -0,0 +1,98 @@
+package codeql
+
+import (
+	"reflect"
+	"testing"
+)
+
+const ancestorsSource = `void kfree(void *p);
+void do_free(char *p) { kfree(p); }
+void do_use(char *p) { p[0] = 0; }
+void free_then_use(char *p)
+{
+	do_free(p);
+	do_use(p);
+}
+void use_then_free(char *p)
+{
+	do_use(p);
+	do_free(p);
+}
+void helper(char *p) { do_free(p); }
+void deep(char *p)
+{
+	helper(p);
+	do_use(p);
+}
+void outer(char *p) { free_then_use(p); }
+void same(char *p) { do_free(p), do_use(p); }
+`
+
+func TestCommonAncestors(t *testing.T) {
+	f := loadC(t, ancestorsSource)
+
+	type ancestor struct {
+		Caller    string
+		FreeDepth int
+		UseDepth  int
+		Order     string
+	}
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     []ancestor
+	}{
+		{
+			// outer only reaches both through free_then_use, so it is not the closest; ties after
+			// the free-first callers are broken by caller ID
+			name:     "closest first",
+			maxDepth: 4,
+			want: []ancestor{
+				{"free_then_use", 1, 1, OrderFreeFirst},
+				{"deep", 2, 1, OrderFreeFirst},
+				{"same", 1, 1, OrderSameLine},
+				{"use_then_free", 1, 1, OrderUseFirst},
+			},
+		},
+		{
+			name:     "depth bound",
+			maxDepth: 1,
+			want: []ancestor{
+				{"free_then_use", 1, 1, OrderFreeFirst},
+				{"same", 1, 1, OrderSameLine},
+				{"use_then_free", 1, 1, OrderUseFirst},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []ancestor
+			for _, c := range f.graph.commonAncestors(f.id(t, "do_free"), f.id(t, "do_use"), tt.maxDepth) {
+				got = append(got, ancestor{c.Caller, c.FreeDepth, c.UseDepth, c.Order})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commonAncestors = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommonAncestorPaths(t *testing.T) {
+	f := loadC(t, ancestorsSource)
+	for _, c := range f.graph.commonAncestors(f.id(t, "do_free"), f.id(t, "do_use"), 4) {
+		if c.Caller != "deep" {
+			continue
+		}
+		if want := []string{"deep", "helper", "do_free"}; !reflect.DeepEqual(c.FreePath.Functions, want) {
+			t.Errorf("free path = %v, want %v", c.FreePath.Functions, want)
+		}
+		if want := []string{"deep", "do_use"}; !reflect.DeepEqual(c.UsePath.Functions, want) {
+			t.Errorf("use path = %v, want %v", c.UsePath.Functions, want)
+		}
+		if c.FreeCallLine != 17 || c.UseCallLine != 18 {
+			t.Errorf("call lines = %d, %d, want 17, 18", c.FreeCallLine, c.UseCallLine)
+		}
+		return
+	}
+	t.Fatal("deep is not a common caller")
+}
@@ -0,0 +1,36 @@
+package codeql
+
+import "github.com/noperator/slice/pkg/parser"
+
+// annotate classifies the code around the free and use lines of a finding
+func (e *QueryEnricher) annotate(freeFunc, useFunc *parser.Function, result CodeQLResult, parsed *parsedFunctions) *Annotations {
+	annotations := &Annotations{}
+
+	freeParse, err := parsed.get(freeFunc)
+	if err != nil {
+		e.logger.Debug("could not classify free site",
+			"component", "codeql",
+			"function", freeFunc.ID,
+			"error", err)
+	} else {
+		annotations.Free = freeParse.ClassifySite(result.FreeLine)
+	}
+
+	useParse, err := parsed.get(useFunc)
+	if err != nil {
+		e.logger.Debug("could not classify use site",
+			"component", "codeql",
+			"function", useFunc.ID,
+			"error", err)
+		return annotations
+	}
+	annotations.Use = useParse.ClassifySite(result.UseLine)
+
+	objects := []string{freedExpression(freeFunc, result)}
+	if analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir); err == nil {
+		objects = append(objects, useObjects(analysisResult, useFunc, result, objects[0])...)
+	}
+	annotations.UsePrimitive = useParse.ClassifyUse(result.UseLine, objects)
+
+	return annotations
+}
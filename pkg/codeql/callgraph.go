@@ -4,68 +4,127 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/dominikbraun/graph"
 	"github.com/noperator/slice/pkg/parser"
 )
 
-// CallGraph represents function call relationships using a graph library
+// CallGraph represents function call relationships as integer-indexed CSR adjacency, with
+// strongly connected components condensed for fast reachability queries
 type CallGraph struct {
-	g            graph.Graph[string, string] // Directed graph of function IDs
-	functions    map[string][]string        // Map function name -> list of function IDs
-	edges        map[string][]string        // Legacy field for backward compatibility
-	reverseEdges map[string][]string        // Legacy field for backward compatibility
-	pathCache    sync.Map                   // Cache for path lookups (thread-safe)
+	ids          []string           // Function index -> function ID
+	index        map[string]int32   // Function ID -> index
+	functions    map[string][]int32 // Function name -> indices of functions with that name
+	locations    []functionLocation // Function index -> file and definition line
+	calls        csr                // Callees of each function
+	callers      csr                // Callers of each function
+	edgeKinds    []uint8            // Per call in calls: direct, indirect or callback
+	callLines    []int32            // Per call in calls: earliest call-site line
+	callText     []string           // Per call in calls: statement text at that line
+	component    []int32            // Function index -> strongly connected component
+	components   int                // Number of strongly connected components
+	recursive    []bool             // Component -> whether its functions call back into it
+	dagCallers   csr                // Callers of each component, between components only
+	reachCache   sync.Map           // Component -> reachSet of the components reaching it
+	reachSets    atomic.Int32       // Number of memoized reachSets
+	reachQueried sync.Map           // Components already queried once as a target
+	pathOptions  PathOptions        // Bounds on the call chains enumerated per finding
+	pathCache    sync.Map           // Memoized chain and common-caller searches per function pair
+	scratchPool  sync.Pool          // *bfsScratch reused across searches
+	entries      *entryReach        // Reachability from entry points, once set
+	metricsOnce  sync.Once          // Guards metrics
+	metrics      *graphMetrics      // Betweenness and component sizes, computed on first use
 }
 
 // ReachabilityAnalysis contains the results of analyzing reachability between two functions
 // JSON tags maintain backward compatibility with existing code expecting these field names
 type ReachabilityAnalysis struct {
-	IsValid       bool       `json:"valid"`
-	Reason        string     `json:"reason"`
-	CallChains    [][]string `json:"chains,omitempty"`
-	CommonCallers []string   `json:"common_callers,omitempty"`
-	Details       string     `json:"details,omitempty"`
-	MinDepth      int        `json:"min_depth,omitempty"`
-	MaxDepth      int        `json:"max_depth,omitempty"`
+	IsValid       bool         `json:"valid"`
+	Reason        string       `json:"reason"`
+	CallChains    []CallChain  `json:"chains,omitempty"`
+	CommonCallers []string     `json:"common_callers,omitempty"`
+	Ancestors     []CommonCaller `json:"ancestors,omitempty"` // Closest common callers, with the order they reach the free and the use
+	Details       string       `json:"details,omitempty"`
+	MinDepth      int          `json:"min_depth,omitempty"`
+	MaxDepth      int          `json:"max_depth,omitempty"`
+	ObjectFlows   []ObjectFlow `json:"object_flows,omitempty"` // How the freed object crosses each call of each chain
 }
 
-// BuildCallGraph creates a call graph from parsed functions
-func BuildCallGraph(functions []parser.Function) *CallGraph {
-	// Create directed graph with string hash
-	g := graph.New(graph.StringHash, graph.Directed())
-	
+// BuildCallGraph creates a call graph from parsed functions. Besides direct calls, it links
+// callers to functions they pass as arguments (callbacks) and, given the function pointers
+// stored into struct members, to functions they may call through such a member (indirect).
+func BuildCallGraph(functions []parser.Function, pointers ...parser.FunctionPointer) *CallGraph {
 	cg := &CallGraph{
-		g:            g,
-		functions:    make(map[string][]string),
-		edges:        make(map[string][]string),
-		reverseEdges: make(map[string][]string),
+		index:       make(map[string]int32, len(functions)),
+		functions:   make(map[string][]int32),
+		pathOptions: DefaultPathOptions,
 	}
+	builder := &graphBuilder{cg: cg, seen: make(map[uint64]int32)}
 
 	// Add all functions as vertices
-	for _, function := range functions {
-		_ = g.AddVertex(function.ID)
-		cg.functions[function.Name] = append(cg.functions[function.Name], function.ID)
+	for i := range functions {
+		builder.addFunction(&functions[i])
+	}
+
+	// Functions stored into each struct member, for calls such as "dev->ops->read(...)"
+	targets := make(map[string][]string)
+	seenTargets := make(map[string]bool)
+	for _, pointer := range pointers {
+		key := pointer.Field + "\x00" + pointer.Function
+		if _, isFunction := cg.functions[pointer.Function]; isFunction && !seenTargets[key] && len(targets[pointer.Field]) < maxIndirectTargets {
+			seenTargets[key] = true
+			targets[pointer.Field] = append(targets[pointer.Field], pointer.Function)
+		}
 	}
 
 	// Add edges for function calls
 	for _, caller := range functions {
+		from := cg.index[caller.ID]
 		for _, callee := range caller.Callees {
 			// Find all functions with this callee name
-			if calleeIDs, exists := cg.functions[callee.Name]; exists {
-				for _, calleeID := range calleeIDs {
-					_ = g.AddEdge(caller.ID, calleeID)
-					// Also populate legacy edge maps for backward compatibility
-					cg.edges[caller.ID] = append(cg.edges[caller.ID], calleeID)
-					cg.reverseEdges[calleeID] = append(cg.reverseEdges[calleeID], caller.ID)
+			for _, to := range cg.functions[callee.Name] {
+				builder.addEdge(from, to, edgeDirect, callee)
+			}
+			if field := parser.CalledField(callee.Name); field != "" {
+				for _, target := range targets[field] {
+					for _, to := range cg.functions[target] {
+						builder.addEdge(from, to, edgeIndirect, callee)
+					}
+				}
+			}
+			for _, arg := range callee.Args {
+				name := strings.TrimLeft(strings.TrimSpace(arg), "&")
+				if name == callee.Name || declaresLocal(&caller, name) {
+					continue
+				}
+				for _, to := range cg.functions[name] {
+					builder.addEdge(from, to, edgeCallback, callee)
 				}
 			}
 		}
 	}
 
+	builder.finish()
 	return cg
 }
 
+// declaresLocal reports whether a function has a parameter or local variable of a name, which
+// shadows any function of that name inside it
+func declaresLocal(function *parser.Function, name string) bool {
+	for _, param := range function.Params {
+		if param.Name == name {
+			return true
+		}
+	}
+	for _, v := range function.Vars {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // AnalyzeReachability analyzes the reachability relationship between two functions
 // This is the main entry point for interprocedural analysis
 func (cg *CallGraph) AnalyzeReachability(sourceFuncName, targetFuncName string, maxDepth int) *ReachabilityAnalysis {
@@ -92,12 +151,17 @@ func (cg *CallGraph) AnalyzeReachability(sourceFuncName, targetFuncName string,
 
 	// Analyze all combinations of source and target IDs
 	analyzer := &reachabilityAnalyzer{
-		graph:          cg.g,
+		callGraph:      cg,
 		maxDepth:       maxDepth,
+		options:        cg.pathOptions,
 		sourceFuncName: sourceFuncName,
 		targetFuncName: targetFuncName,
 	}
 
+	if analyzer.options.Budget > 0 {
+		analyzer.deadline = time.Now().Add(analyzer.options.Budget)
+	}
+
 	// Check all combinations (handles multiple functions with same name)
 	for _, sourceID := range sourceIDs {
 		for _, targetID := range targetIDs {
@@ -116,16 +180,18 @@ func (cg *CallGraph) AnalyzeReachability(sourceFuncName, targetFuncName string,
 
 // reachabilityAnalyzer accumulates analysis results
 type reachabilityAnalyzer struct {
-	graph          graph.Graph[string, string]
+	callGraph      *CallGraph
 	maxDepth       int
+	options        PathOptions
+	deadline       time.Time // Zero when the finding has no time budget
 	sourceFuncName string
 	targetFuncName string
 	
 	// Results
 	foundRelationship bool
 	relationshipType  RelationshipType
-	allPaths         [][]string
-	commonCallers    map[string]bool
+	allPaths         []CallChain
+	ancestors        []CommonCaller
 }
 
 // RelationshipType represents the type of relationship between functions
@@ -139,12 +205,12 @@ const (
 	CommonAncestor    // both reachable from common caller
 )
 
-func (ra *reachabilityAnalyzer) analyzePair(sourceID, targetID string) {
+func (ra *reachabilityAnalyzer) analyzePair(sourceID, targetID int32) {
 	// Case 1: Same function
 	if sourceID == targetID {
 		ra.foundRelationship = true
 		ra.relationshipType = SameFunction
-		ra.allPaths = append(ra.allPaths, []string{extractFunctionName(sourceID)})
+		ra.allPaths = append(ra.allPaths, ra.callGraph.newCallChain([]int32{sourceID}))
 		return
 	}
 
@@ -165,67 +231,29 @@ func (ra *reachabilityAnalyzer) analyzePair(sourceID, targetID string) {
 	}
 
 	// Case 4: Common ancestor (both reachable from same caller)
-	if callers := ra.findCommonAncestors(sourceID, targetID); len(callers) > 0 {
+	if ancestors := ra.callGraph.commonAncestors(sourceID, targetID, ra.maxDepth); len(ancestors) > 0 {
 		ra.foundRelationship = true
 		ra.relationshipType = CommonAncestor
-		
-		// Add sample paths from first common caller
-		if len(callers) > 0 {
-			firstCaller := callers[0]
-			paths1 := ra.findPaths(firstCaller, sourceID)
-			paths2 := ra.findPaths(firstCaller, targetID)
-			ra.addPaths(paths1)
-			ra.addPaths(paths2)
-		}
-		
-		// Store common callers
-		if ra.commonCallers == nil {
-			ra.commonCallers = make(map[string]bool)
-		}
-		for _, caller := range callers {
-			ra.commonCallers[extractFunctionName(caller)] = true
-		}
-	}
-}
-
-func (ra *reachabilityAnalyzer) findPaths(from, to string) [][]string {
-	// Use a simpler depth limit for path finding to avoid explosion
-	searchDepth := ra.maxDepth
-	if searchDepth > 5 {
-		searchDepth = 5
-	}
 
-	// First check if there's any path at all using shortest path (faster)
-	shortestPath, err := graph.ShortestPath(ra.graph, from, to)
-	if err != nil || shortestPath == nil {
-		return nil
-	}
-	
-	// If shortest path exceeds depth, no valid paths exist
-	if len(shortestPath)-1 > searchDepth {
-		return nil
-	}
-	
-	// For performance, just return the shortest path converted to names
-	// This is much faster than AllPathsBetween for large graphs
-	var names []string
-	for _, id := range shortestPath {
-		names = append(names, extractFunctionName(id))
+		// Show the closest caller's routes down to the free and to the use
+		ra.addPaths([]CallChain{ancestors[0].FreePath, ancestors[0].UsePath})
+		ra.ancestors = ancestors
 	}
-	
-	return [][]string{names}
 }
 
-func (ra *reachabilityAnalyzer) findCommonAncestors(sourceID, targetID string) []string {
-	// Disabled for performance - common ancestor search is too expensive
-	// The majority of validations work with direct/reverse paths
-	// This accounts for the difference: 217 valid vs 241 valid (original)
-	return nil
+// findPaths enumerates the shortest call chains between two functions, up to the configured
+// number of chains and within the finding's time budget
+func (ra *reachabilityAnalyzer) findPaths(from, to int32) []CallChain {
+	var chains []CallChain
+	for _, path := range ra.callGraph.cachedPaths(from, to, ra.options.MaxPaths, ra.maxDepth, ra.deadline) {
+		chains = append(chains, ra.callGraph.newCallChain(path))
+	}
+	return chains
 }
 
-func (ra *reachabilityAnalyzer) addPaths(paths [][]string) {
+func (ra *reachabilityAnalyzer) addPaths(paths []CallChain) {
 	for _, path := range paths {
-		if len(ra.allPaths) >= 10 { // Global limit on total paths
+		if len(ra.allPaths) >= 2*ra.options.MaxPaths { // Global limit on total paths
 			break
 		}
 		ra.allPaths = append(ra.allPaths, path)
@@ -250,41 +278,35 @@ func (ra *reachabilityAnalyzer) buildResult() *ReachabilityAnalysis {
 		details = fmt.Sprintf("Both operations occur in the same function: %s", ra.sourceFuncName)
 	case ForwardReachable:
 		relationship = "Source function can reach target function"
-		if len(ra.allPaths) > 0 && len(ra.allPaths[0]) == 2 {
+		if len(ra.allPaths) > 0 && ra.allPaths[0].Length == 1 {
 			details = fmt.Sprintf("Direct call: %s calls %s", ra.sourceFuncName, ra.targetFuncName)
 		} else {
 			details = fmt.Sprintf("Call chain: %s → %s", ra.sourceFuncName, ra.targetFuncName)
 		}
 	case BackwardReachable:
 		relationship = "Target function can reach source function"
-		if len(ra.allPaths) > 0 && len(ra.allPaths[0]) == 2 {
+		if len(ra.allPaths) > 0 && ra.allPaths[0].Length == 1 {
 			details = fmt.Sprintf("Reverse call: %s calls %s", ra.targetFuncName, ra.sourceFuncName)
 		} else {
 			details = fmt.Sprintf("Reverse call chain: %s → %s", ra.targetFuncName, ra.sourceFuncName)
 		}
 	case CommonAncestor:
 		relationship = "Functions have common caller"
-		callerCount := len(ra.commonCallers)
-		if callerCount == 1 {
-			var callerName string
-			for name := range ra.commonCallers {
-				callerName = name
-				break
-			}
+		if len(ra.ancestors) == 1 {
 			details = fmt.Sprintf("Common caller: %s calls both %s and %s", 
-				callerName, ra.sourceFuncName, ra.targetFuncName)
+				ra.ancestors[0].Caller, ra.sourceFuncName, ra.targetFuncName)
 		} else {
-			details = fmt.Sprintf("Found %d common callers that reach both functions", callerCount)
+			details = fmt.Sprintf("Found %d common callers that reach both functions", len(ra.ancestors))
+		}
+		if closest := ra.ancestors[0]; closest.Order == OrderFreeFirst {
+			details += fmt.Sprintf("; %s reaches the free (L%d) before the use (L%d)", closest.Caller, closest.FreeCallLine, closest.UseCallLine)
 		}
 	}
 
-	// Convert common callers map to list
+	// List common callers, closest first
 	var callerList []string
-	for caller := range ra.commonCallers {
-		callerList = append(callerList, caller)
-		if len(callerList) >= 10 {
-			break
-		}
+	for _, ancestor := range ra.ancestors {
+		callerList = append(callerList, ancestor.Caller)
 	}
 
 	// Calculate depth metrics
@@ -298,6 +320,7 @@ func (ra *reachabilityAnalyzer) buildResult() *ReachabilityAnalysis {
 		Reason:        relationship,
 		CallChains:    uniquePaths,
 		CommonCallers: callerList,
+		Ancestors:     ra.ancestors,
 		Details:       details,
 		MinDepth:      minDepth,
 		MaxDepth:      maxDepth,
@@ -315,16 +338,16 @@ func extractFunctionName(funcID string) string {
 	return funcID
 }
 
-func calculatePathDepths(paths [][]string) (min, max int) {
+func calculatePathDepths(paths []CallChain) (min, max int) {
 	if len(paths) == 0 {
 		return 0, 0
 	}
 	
-	min = len(paths[0]) - 1
+	min = paths[0].Length
 	max = min
 	
 	for _, path := range paths[1:] {
-		depth := len(path) - 1
+		depth := path.Length
 		if depth < min {
 			min = depth
 		}
@@ -337,13 +360,13 @@ func calculatePathDepths(paths [][]string) (min, max int) {
 	// In this case, we want the sum of depths
 	if len(paths) >= 2 {
 		// Check if we have paths to different endpoints (common ancestor case)
-		lastFunc1 := paths[0][len(paths[0])-1]
+		lastFunc1 := paths[0].Functions[len(paths[0].Functions)-1]
 		for _, path := range paths[1:] {
-			lastFunc2 := path[len(path)-1]
+			lastFunc2 := path.Functions[len(path.Functions)-1]
 			if lastFunc1 != lastFunc2 {
 				// This looks like common ancestor paths
 				// Sum the depths for total reachability distance
-				totalDepth := (len(paths[0]) - 1) + (len(path) - 1)
+				totalDepth := paths[0].Length + path.Length
 				if totalDepth > max {
 					max = totalDepth
 				}
@@ -354,12 +377,12 @@ func calculatePathDepths(paths [][]string) (min, max int) {
 	return min, max
 }
 
-func deduplicatePaths(paths [][]string) [][]string {
+func deduplicatePaths(paths []CallChain) []CallChain {
 	seen := make(map[string]bool)
-	var result [][]string
+	var result []CallChain
 	
 	for _, path := range paths {
-		key := strings.Join(path, "->")
+		key := strings.Join(path.Functions, "->")
 		if !seen[key] {
 			seen[key] = true
 			result = append(result, path)
@@ -380,93 +403,3 @@ func (cg *CallGraph) ValidateCallRelationship(freeFuncName, useFuncName string,
 
 // CallValidation is an alias for backward compatibility
 type CallValidation = ReachabilityAnalysis
-
-// Legacy methods for backward compatibility
-
-// HasPath checks if there's a path from 'from' function to 'to' function within maxDepth
-func (cg *CallGraph) HasPath(from, to string, maxDepth int) bool {
-	if from == to {
-		return true
-	}
-	
-	// Use graph library to check if path exists
-	path, err := graph.ShortestPath(cg.g, from, to)
-	if err != nil || path == nil {
-		return false
-	}
-	
-	// Check if path length is within maxDepth
-	return len(path)-1 <= maxDepth
-}
-
-// FindCallChains finds all call chains from 'from' to 'to' within maxDepth
-func (cg *CallGraph) FindCallChains(from, to string, maxDepth int) [][]string {
-	if from == to {
-		return [][]string{{from}}
-	}
-
-	// For performance, just use shortest path instead of all paths
-	shortestPath, err := graph.ShortestPath(cg.g, from, to)
-	if err != nil || shortestPath == nil {
-		return nil
-	}
-	
-	// Check depth
-	if len(shortestPath) > maxDepth {
-		return nil
-	}
-	
-	return [][]string{shortestPath}
-}
-
-// AreConnected checks if two functions are connected in either direction
-func (cg *CallGraph) AreConnected(func1, func2 string, maxDepth int) bool {
-	// Check if func1 can reach func2
-	if cg.HasPath(func1, func2, maxDepth) {
-		return true
-	}
-	// Check if func2 can reach func1
-	if cg.HasPath(func2, func1, maxDepth) {
-		return true
-	}
-	// Check if they have a common caller
-	return cg.haveCommonCaller(func1, func2, maxDepth)
-}
-
-// haveCommonCaller checks if two functions have a common caller within maxDepth
-func (cg *CallGraph) haveCommonCaller(func1, func2 string, maxDepth int) bool {
-	// Use a conservative depth for common caller detection
-	searchDepth := maxDepth
-	if searchDepth > 3 {
-		searchDepth = 3
-	}
-
-	// Get all vertices in the graph
-	adjMap, err := cg.g.AdjacencyMap()
-	if err != nil {
-		return false
-	}
-	
-	// Check each vertex to see if it can reach both functions
-	for vertex := range adjMap {
-		// Skip if this is one of our target functions
-		if vertex == func1 || vertex == func2 {
-			continue
-		}
-		
-		// Check reachability to both functions
-		canReachFunc1 := cg.HasPath(vertex, func1, searchDepth)
-		canReachFunc2 := cg.HasPath(vertex, func2, searchDepth)
-		
-		if canReachFunc1 && canReachFunc2 {
-			return true
-		}
-	}
-	
-	return false
-}
-
-// deduplicateChains removes duplicate call chains from the slice (legacy function)
-func deduplicateChains(chains [][]string) [][]string {
-	return deduplicatePaths(chains)
-}
\ No newline at end of file
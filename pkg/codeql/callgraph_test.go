@@ -0,0 +1,54 @@
+package codeql
+
+import "testing"
+
+func TestCallbackEdges(t *testing.T) {
+	f := loadC(t, `void handler(int x) { }
+void register_cb(void (*fn)(int));
+void passes(void) { register_cb(handler); }
+void passes_address(void) { register_cb(&handler); }
+void shadow_local(void)
+{
+	void (*handler)(int) = 0;
+	register_cb(handler);
+}
+void shadow_param(void (*handler)(int)) { register_cb(handler); }
+`)
+
+	tests := []struct {
+		caller string
+		want   bool
+	}{
+		{"passes", true},
+		{"passes_address", true},
+		{"shadow_local", false},
+		{"shadow_param", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.caller, func(t *testing.T) {
+			e := f.graph.edge(f.id(t, tt.caller), f.id(t, "handler"))
+			if got := e >= 0 && f.graph.edgeKinds[e] == edgeCallback; got != tt.want {
+				t.Errorf("callback edge %s -> handler = %v, want %v", tt.caller, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyscallAliases(t *testing.T) {
+	f := loadC(t, `void kfree(void *p);
+SYSCALL_DEFINE1(close, int, fd)
+{
+	return 0;
+}
+void legacy(void) { sys_close(0); }
+void wrapper(void) { __se_sys_close(0); }
+`)
+	for _, caller := range []string{"legacy", "wrapper"} {
+		if f.graph.edge(f.id(t, caller), f.id(t, "__do_sys_close")) < 0 {
+			t.Errorf("%s does not call __do_sys_close", caller)
+		}
+	}
+	if ids := f.graph.Lookup("sys_close"); len(ids) != 1 || ids[0] != f.functions["__do_sys_close"].ID {
+		t.Errorf("Lookup(sys_close) = %v", ids)
+	}
+}
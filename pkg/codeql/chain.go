@@ -0,0 +1,217 @@
+package codeql
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// SiteGuards lists the conditions that must hold for a key line of a chain function to run
+type SiteGuards struct {
+	Function string         `json:"func"`
+	Site     string         `json:"site"` // free, use or call
+	Callee   string         `json:"callee,omitempty"`
+	Line     int            `json:"line"`
+	Guards   []parser.Guard `json:"guards"`
+}
+
+// chainSite is a line of a chain function worth explaining: the free, the use, or a call
+// to the function's neighbour in a call chain
+type chainSite struct {
+	Site   string
+	Callee string
+	Line   int
+}
+
+// chainFunction is a function in a finding's call chains, with the lines and objects that matter in it
+type chainFunction struct {
+	function  *parser.Function
+	code      *FunctionCode
+	criterion parser.SliceCriterion
+	sites     []chainSite
+}
+
+// addChainContext slices the free, use and intermediate functions around the freed object,
+// and records the conditions guarding the free, the use and the calls linking the chain
+func (e *QueryEnricher) addChainContext(finding *Finding, intermediates []string, parsed *parsedFunctions) {
+	for _, chainFunc := range e.chainFunctions(finding, intermediates) {
+		function, err := parsed.get(chainFunc.function)
+		if err != nil {
+			e.logger.Debug("could not slice function",
+				"component", "codeql",
+				"function", chainFunc.function.ID,
+				"error", err)
+			continue
+		}
+		chainFunc.code.Slice = function.Slice(chainFunc.criterion)
+
+		lines := make([]int, 0, len(chainFunc.sites))
+		for _, site := range chainFunc.sites {
+			lines = append(lines, site.Line)
+		}
+		guards := function.FindGuards(lines)
+		for _, site := range chainFunc.sites {
+			if len(guards[site.Line]) == 0 {
+				continue
+			}
+			chainFunc.code.Guards = append(chainFunc.code.Guards, SiteGuards{
+				Function: chainFunc.function.Name,
+				Site:     site.Site,
+				Callee:   site.Callee,
+				Line:     site.Line,
+				Guards:   guards[site.Line],
+			})
+		}
+	}
+}
+
+// chainFunctions resolves the free, use and intermediate functions of a finding along with
+// the lines and objects each one should be explained around
+func (e *QueryEnricher) chainFunctions(finding *Finding, intermediates []string) []chainFunction {
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return nil
+	}
+	result := finding.CodeQLResult
+
+	freeID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.FreeFunctionFile), result.FreeFunctionDefLine, result.FreeFunctionName)
+	useID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.UseFunctionFile), result.UseFunctionDefLine, result.UseFunctionName)
+	freeFunc, err := parser.FindFunctionByID(e.sourceDir, freeID)
+	if err != nil {
+		return nil
+	}
+	useFunc, err := parser.FindFunctionByID(e.sourceDir, useID)
+	if err != nil {
+		return nil
+	}
+
+	chains := []CallChain{{Functions: []string{result.FreeFunctionName, result.UseFunctionName}}}
+	if finding.CallValidation != nil && len(finding.CallValidation.CallChains) > 0 {
+		chains = finding.CallValidation.CallChains
+	}
+
+	freed := freedExpression(freeFunc, result)
+	free := chainFunction{
+		function:  freeFunc,
+		code:      &finding.SourceCode.FreeFunction,
+		criterion: parser.SliceCriterion{Lines: []int{result.FreeLine}, Objects: []string{freed}},
+		sites:     []chainSite{{Site: "free", Line: result.FreeLine}},
+	}
+	use := chainFunction{
+		function:  useFunc,
+		code:      &finding.SourceCode.UseFunction,
+		criterion: parser.SliceCriterion{Lines: []int{result.UseLine}, Objects: useObjects(analysisResult, useFunc, result, freed)},
+		sites:     []chainSite{{Site: "use", Line: result.UseLine}},
+	}
+	if freeFunc.ID == useFunc.ID {
+		free.criterion.Lines = append(free.criterion.Lines, use.criterion.Lines...)
+		free.criterion.Objects = append(free.criterion.Objects, use.criterion.Objects...)
+		free.sites = append(free.sites, use.sites...)
+	}
+
+	functions := []chainFunction{free}
+	if freeFunc.ID != useFunc.ID {
+		functions = append(functions, use)
+	}
+	for i, id := range intermediates {
+		if i >= len(finding.SourceCode.IntermediateFunctions) {
+			break
+		}
+		if function := analysisResult.FunctionByID(id); function != nil {
+			functions = append(functions, chainFunction{
+				function: function,
+				code:     &finding.SourceCode.IntermediateFunctions[i],
+			})
+		}
+	}
+
+	for i := range functions {
+		addChainCalls(&functions[i], chains)
+	}
+	return functions
+}
+
+// addChainCalls adds the calls from a function to its neighbours in the call chains, and the
+// objects passed to them, to its slice criterion and sites
+func addChainCalls(chainFunc *chainFunction, chains []CallChain) {
+	neighbours := make(map[string]bool)
+	calls := make(map[int]string) // Call-site line -> next function, from chains with hops
+	for _, chain := range chains {
+		for _, hop := range chain.Hops {
+			if hop.FunctionID == chainFunc.function.ID && hop.CallLine > 0 {
+				calls[hop.CallLine] = hop.Calls
+			}
+		}
+		if len(chain.Hops) > 0 {
+			continue
+		}
+		for i, name := range chain.Functions {
+			if name != chainFunc.function.Name {
+				continue
+			}
+			if i > 0 {
+				neighbours[chain.Functions[i-1]] = true
+			}
+			if i < len(chain.Functions)-1 {
+				neighbours[chain.Functions[i+1]] = true
+			}
+		}
+	}
+
+	seenLines := make(map[int]bool)
+	for _, callee := range chainFunc.function.Callees {
+		next, onChain := calls[callee.Line]
+		switch {
+		case onChain && seenLines[callee.Line]:
+			continue // Several calls on the chain's line, e.g. "put(get(obj))"
+		case onChain:
+			seenLines[callee.Line] = true
+		case neighbours[callee.Name]:
+			next = callee.Name
+		default:
+			continue
+		}
+		chainFunc.criterion.Lines = append(chainFunc.criterion.Lines, callee.Line)
+		chainFunc.sites = append(chainFunc.sites, chainSite{Site: "call", Callee: next, Line: callee.Line})
+		for _, arg := range callee.Args {
+			if object := strings.TrimLeft(parser.NormalizeTarget(arg), "&"); isObjectExpression(object) {
+				chainFunc.criterion.Objects = append(chainFunc.criterion.Objects, object)
+			}
+		}
+	}
+}
+
+// isObjectExpression reports whether an argument is a plain object expression such as
+// "ctx", "*pp" or "ctx->bufs[i].data" rather than a computed value
+func isObjectExpression(expr string) bool {
+	expr = strings.ReplaceAll(strings.TrimLeft(expr, "*"), "->", ".")
+	if expr == "" || (expr[0] >= '0' && expr[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		if !isAlnum(c) && c != '_' && c != '.' && c != '[' && c != ']' {
+			return false
+		}
+	}
+	return true
+}
+
+// useObjects returns the expressions on the use line that refer to the freed member, falling
+// back to the object name CodeQL reported
+func useObjects(analysisResult *parser.AnalysisResult, useFunc *parser.Function, result CodeQLResult, freed string) []string {
+	objects := []string{result.ObjName}
+	path := parseObjectPath(freed)
+	if len(path.Fields) == 0 {
+		return objects
+	}
+	field := path.Fields[len(path.Fields)-1]
+	for _, access := range analysisResult.FieldAccessesIn(useFunc.ID) {
+		if access.Line == result.UseLine && access.Field == field {
+			objects = append(objects, access.Expression)
+		}
+	}
+	return objects
+}
@@ -0,0 +1,303 @@
+package codeql
+
+import (
+	"sort"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// LockingFunction is a chain function that takes locks or runs as a thread or callback entry point
+type LockingFunction struct {
+	Function string              `json:"func"`
+	Locks    []parser.LockOp     `json:"locks,omitempty"`
+	Entries  []parser.EntryPoint `json:"entries,omitempty"`
+}
+
+// ConcurrencyContext records the locks held around the free and the use and the concurrent
+// entry points along a finding's chains
+type ConcurrencyContext struct {
+	FreeLocks   []parser.HeldLock `json:"free_locks,omitempty"`
+	UseLocks    []parser.HeldLock `json:"use_locks,omitempty"`
+	CommonLocks []string          `json:"common_locks,omitempty"` // Locks held around both the free and the use
+	Functions   []LockingFunction `json:"functions,omitempty"`
+}
+
+// entryPoints returns the thread and callback entry points of the source tree, found once
+func (e *QueryEnricher) entryPoints() map[string][]parser.EntryPoint {
+	e.entryOnce.Do(func() {
+		analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+		if err != nil {
+			return
+		}
+		e.entries = make(map[string][]parser.EntryPoint)
+		for _, entry := range parser.FindEntryPoints(analysisResult) {
+			e.entries[entry.Function] = append(e.entries[entry.Function], entry)
+		}
+	})
+	return e.entries
+}
+
+// addConcurrencyContext records which locks are held around the free and the use, including
+// locks taken by callers along the chains, and which chain functions lock or start concurrently
+func (e *QueryEnricher) addConcurrencyContext(finding *Finding, intermediates []string, parsed *parsedFunctions) {
+	functions := e.chainFunctions(finding, intermediates)
+	if len(functions) == 0 {
+		return
+	}
+	result := finding.CodeQLResult
+	entries := e.entryPoints()
+
+	byID := make(map[string]*chainFunction, len(functions))
+	context := &ConcurrencyContext{}
+	for i := range functions {
+		chainFunc := &functions[i]
+		byID[chainFunc.function.ID] = chainFunc
+
+		locking := LockingFunction{Function: chainFunc.function.Name, Entries: entries[chainFunc.function.Name]}
+		for _, op := range parser.FindLockOps(chainFunc.function) {
+			if op.Acquire {
+				locking.Locks = append(locking.Locks, op)
+			}
+		}
+		if len(locking.Locks) > 0 || len(locking.Entries) > 0 {
+			context.Functions = append(context.Functions, locking)
+		}
+	}
+
+	useFunc := functions[0].function
+	if len(functions) > 1 && functions[1].function.Name == result.UseFunctionName {
+		useFunc = functions[1].function
+	}
+	chains := [][]string{{functions[0].function.ID, useFunc.ID}}
+	if finding.CallValidation != nil && len(finding.CallValidation.CallChains) > 0 {
+		chains = chainIDs(finding.CallValidation.CallChains, functions)
+	}
+	context.FreeLocks = e.heldAlongChains(parsed, byID, chains, functions[0].function, result.FreeLine)
+	context.UseLocks = e.heldAlongChains(parsed, byID, chains, useFunc, result.UseLine)
+
+	seen := make(map[string]bool)
+	for _, free := range context.FreeLocks {
+		for _, use := range context.UseLocks {
+			if !free.MayHold && !use.MayHold && free.Key == use.Key && !seen[free.Lock] {
+				seen[free.Lock] = true
+				context.CommonLocks = append(context.CommonLocks, free.Lock)
+			}
+		}
+	}
+
+	if len(context.Functions) == 0 && len(context.FreeLocks) == 0 && len(context.UseLocks) == 0 {
+		return
+	}
+	if finding.Annotations == nil {
+		finding.Annotations = &Annotations{}
+	}
+	finding.Annotations.Concurrency = context
+}
+
+// chainIDs returns the function IDs of each call chain. Chains without hops only name their
+// functions, so those are matched to the chain functions by name.
+func chainIDs(chains []CallChain, functions []chainFunction) [][]string {
+	ids := make([][]string, 0, len(chains))
+	for _, chain := range chains {
+		var chainIDs []string
+		if len(chain.Hops) > 0 {
+			for _, hop := range chain.Hops {
+				chainIDs = append(chainIDs, hop.FunctionID)
+			}
+		} else {
+			for _, name := range chain.Functions {
+				id := name
+				for _, chainFunc := range functions {
+					if chainFunc.function.Name == name {
+						id = chainFunc.function.ID
+						break
+					}
+				}
+				chainIDs = append(chainIDs, id)
+			}
+		}
+		ids = append(ids, chainIDs)
+	}
+	return ids
+}
+
+// maxLockCallers bounds how many direct callers outside the chains are checked for held locks;
+// with more, locks taken by callers can only be reported as may-hold
+const maxLockCallers = 8
+
+// heldAlongChains returns the locks held at a line of a function, plus those its callers hold
+// while calling down towards it. Each chain reaching the function, and each call site in a
+// direct caller outside the chains, is a path into it: a caller's lock is only reported as held
+// when every path holds it, and as may-hold otherwise.
+func (e *QueryEnricher) heldAlongChains(parsed *parsedFunctions, byID map[string]*chainFunction, chains [][]string, function *parser.Function, line int) []parser.HeldLock {
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return nil
+	}
+
+	held, err := parsed.heldLocks(function, []int{line})
+	if err != nil {
+		e.logger.Debug("could not compute held locks",
+			"component", "codeql",
+			"function", function.ID,
+			"error", err)
+		return nil
+	}
+	var locks []parser.HeldLock
+	for _, h := range held[line] {
+		h.Key = lockKey(analysisResult, function, h.Lock)
+		locks = append(locks, h)
+	}
+
+	// The locks callers hold on each path, keyed by lock identity
+	var paths []map[string]parser.HeldLock
+	addPath := func(path map[string]parser.HeldLock, caller *parser.Function, held []parser.HeldLock) {
+		for _, h := range held {
+			h.Function = caller.Name
+			h.Key = lockKey(analysisResult, caller, h.Lock)
+			if _, ok := path[h.Key]; !ok {
+				path[h.Key] = h
+			}
+		}
+	}
+
+	for _, chain := range chains {
+		position := -1
+		for i, id := range chain {
+			if id == function.ID {
+				position = i
+				break
+			}
+		}
+		if position <= 0 {
+			continue
+		}
+		path := make(map[string]parser.HeldLock)
+		for i := position - 1; i >= 0; i-- {
+			caller, ok := byID[chain[i]]
+			if !ok {
+				continue
+			}
+			var lines []int
+			for _, site := range caller.sites {
+				if site.Site == "call" && site.Callee == extractFunctionName(chain[i+1]) {
+					lines = append(lines, site.Line)
+				}
+			}
+			held, err := parsed.heldLocks(caller.function, lines)
+			if err != nil {
+				continue
+			}
+			// The chain may call down from any of these lines; keep what every one holds
+			addPath(path, caller.function, intersectHeld(held, lines))
+		}
+		paths = append(paths, path)
+	}
+
+	complete := true
+	callers := 0
+	for _, caller := range analysisResult.CallersOfFunction(function) {
+		if _, inChain := byID[caller.ID]; inChain {
+			continue
+		}
+		if callers >= maxLockCallers {
+			complete = false
+			break
+		}
+		callers++
+		var lines []int
+		for _, callee := range caller.Callees {
+			if callee.Name == function.Name {
+				lines = append(lines, callee.Line)
+			}
+		}
+		held, err := parsed.heldLocks(caller, lines)
+		if err != nil {
+			complete = false
+			continue
+		}
+		for _, callLine := range lines {
+			path := make(map[string]parser.HeldLock)
+			addPath(path, caller, held[callLine])
+			paths = append(paths, path)
+		}
+	}
+
+	// Report each caller lock once, as held when every path holds it
+	seen := make(map[string]bool)
+	for _, h := range locks {
+		seen[h.Key] = true
+	}
+	for _, path := range paths {
+		for key, h := range path {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			for _, other := range paths {
+				if _, ok := other[key]; !ok {
+					h.MayHold = true
+					break
+				}
+			}
+			h.MayHold = h.MayHold || !complete
+			locks = append(locks, h)
+		}
+	}
+	sort.SliceStable(locks[len(held[line]):], func(i, j int) bool {
+		a, b := locks[len(held[line])+i], locks[len(held[line])+j]
+		if a.MayHold != b.MayHold {
+			return !a.MayHold
+		}
+		if a.Function != b.Function {
+			return a.Function < b.Function
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Lock < b.Lock
+	})
+	return locks
+}
+
+// intersectHeld returns the locks held at every one of the lines
+func intersectHeld(held map[int][]parser.HeldLock, lines []int) []parser.HeldLock {
+	if len(lines) == 0 {
+		return nil
+	}
+	var common []parser.HeldLock
+	for _, h := range held[lines[0]] {
+		everywhere := true
+		for _, line := range lines[1:] {
+			found := false
+			for _, other := range held[line] {
+				found = found || other.Lock == h.Lock
+			}
+			everywhere = everywhere && found
+		}
+		if everywhere {
+			common = append(common, h)
+		}
+	}
+	return common
+}
+
+// lockKey identifies a lock across functions: a struct member lock by the canonical struct type
+// and member, so "dev->lock" and "d->lock" compare equal only when both point into the same
+// type; a global by its name; anything unresolved, and RCU, by its expression
+func lockKey(analysisResult *parser.AnalysisResult, function *parser.Function, lock string) string {
+	path := parseObjectPath(lock)
+	if len(path.Fields) == 0 {
+		return parser.NormalizeTarget(lock)
+	}
+	rootType := objectRootType(analysisResult, function, path.Base)
+	if rootType == "" {
+		return parser.NormalizeTarget(lock)
+	}
+	containers := path.Fields[:len(path.Fields)-1]
+	types := analysisResult.ResolveFieldPath(rootType, containers)
+	if len(types) != len(containers)+1 {
+		return parser.NormalizeTarget(lock)
+	}
+	return analysisResult.CanonicalTypeName(types[len(types)-1]) + "::" + path.Fields[len(path.Fields)-1]
+}
@@ -0,0 +1,141 @@
+package codeql
+
+import "testing"
+
+const concurrencySource = `struct mutex { int owner; };
+void mutex_lock(struct mutex *m);
+void mutex_unlock(struct mutex *m);
+void kfree(void *p);
+struct a { struct mutex lock; char *buf; };
+struct b { struct mutex lock; struct a *a; };
+void free_some(struct a *x)
+{
+	kfree(x->buf);
+}
+void locked_caller(struct a *x)
+{
+	mutex_lock(&x->lock);
+	free_some(x);
+	mutex_unlock(&x->lock);
+}
+void unlocked_caller(struct a *x)
+{
+	free_some(x);
+}
+void free_all(struct a *x)
+{
+	kfree(x->buf);
+}
+void first_caller(struct a *x)
+{
+	mutex_lock(&x->lock);
+	free_all(x);
+	mutex_unlock(&x->lock);
+}
+void second_caller(struct a *p)
+{
+	mutex_lock(&p->lock);
+	free_all(p);
+	mutex_unlock(&p->lock);
+}
+void use_b(struct b *y)
+{
+	mutex_lock(&y->lock);
+	y->a->buf[0] = 0;
+	mutex_unlock(&y->lock);
+}
+void use_a(struct a *z)
+{
+	mutex_lock(&z->lock);
+	z->buf[0] = 0;
+	mutex_unlock(&z->lock);
+}
+`
+
+func TestAddConcurrencyContext(t *testing.T) {
+	f := loadC(t, concurrencySource)
+	parsed := newParsedFunctions()
+	defer parsed.close()
+
+	tests := []struct {
+		name     string
+		free     string
+		freeLine int
+		use      string
+		useLine  int
+		mayHold  bool     // Whether the callers' lock around the free is only held on some paths
+		common   []string // Locks held around both the free and the use
+	}{
+		// Only one of two callers locks, and y->lock is another struct's member of the same name
+		{"some callers, other type", "free_some", 9, "use_b", 41, true, nil},
+		{"some callers, same type", "free_some", 9, "use_a", 47, true, nil},
+		{"every caller, other type", "free_all", 23, "use_b", 41, false, nil},
+		{"every caller, same type", "free_all", 23, "use_a", 47, false, []string{"x->lock"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finding := Finding{CodeQLResult: f.result(t, "buf", tt.free, tt.freeLine, tt.use, tt.useLine)}
+			f.enricher.addConcurrencyContext(&finding, nil, parsed)
+			if finding.Annotations == nil || finding.Annotations.Concurrency == nil {
+				t.Fatal("no concurrency context")
+			}
+			context := finding.Annotations.Concurrency
+			if len(context.FreeLocks) != 1 {
+				t.Fatalf("free locks = %+v, want one lock held by callers", context.FreeLocks)
+			}
+			if lock := context.FreeLocks[0]; lock.Key != "struct a::lock" || lock.MayHold != tt.mayHold {
+				t.Errorf("free lock = %+v, want key struct a::lock and may_hold %v", lock, tt.mayHold)
+			}
+			if len(context.CommonLocks) != len(tt.common) || len(tt.common) > 0 && context.CommonLocks[0] != tt.common[0] {
+				t.Errorf("common locks = %v, want %v", context.CommonLocks, tt.common)
+			}
+		})
+	}
+}
+
+func TestConcurrencyContextOtherFileCallers(t *testing.T) {
+	// b.c calls its own static free_some without the lock, which says nothing about a.c's
+	f := loadFiles(t, map[string]string{
+		"a.c": `struct mutex { int owner; };
+void mutex_lock(struct mutex *m);
+void mutex_unlock(struct mutex *m);
+void kfree(void *p);
+struct a { struct mutex lock; char *buf; };
+void free_some(struct a *x)
+{
+	kfree(x->buf);
+}
+void locked_caller(struct a *x)
+{
+	mutex_lock(&x->lock);
+	free_some(x);
+	mutex_unlock(&x->lock);
+}
+`,
+		"b.c": `struct a;
+static void free_some(struct a *x)
+{
+}
+void unlocked_caller(struct a *x)
+{
+	free_some(x);
+}
+`,
+	})
+	parsed := newParsedFunctions()
+	defer parsed.close()
+
+	finding := Finding{CodeQLResult: CodeQLResult{
+		ObjName:          "buf",
+		FreeFunctionName: "free_some", FreeFunctionFile: "a.c", FreeFunctionDefLine: 6, FreeLine: 8,
+		UseFunctionName: "free_some", UseFunctionFile: "a.c", UseFunctionDefLine: 6, UseLine: 8,
+	}}
+	f.enricher.addConcurrencyContext(&finding, nil, parsed)
+	if finding.Annotations == nil || finding.Annotations.Concurrency == nil {
+		t.Fatal("no concurrency context")
+	}
+	locks := finding.Annotations.Concurrency.FreeLocks
+	if len(locks) != 1 || locks[0].Function != "locked_caller" || locks[0].MayHold {
+		t.Errorf("free locks = %+v, want x->lock held by locked_caller", locks)
+	}
+}
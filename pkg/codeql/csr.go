@@ -0,0 +1,329 @@
+package codeql
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// Edge kinds as stored per call, indexing edgeKindNames
+const (
+	edgeDirect uint8 = iota
+	edgeIndirect
+	edgeCallback
+)
+
+var edgeKindNames = [...]string{edgeDirect: EdgeDirect, edgeIndirect: EdgeIndirect, edgeCallback: EdgeCallback}
+
+// maxReachSets bounds how many per-component reachability sets are kept, about 25 KB each for
+// 200k components
+const maxReachSets = 1024
+
+// csr is a compressed sparse row adjacency: the neighbours of node v are
+// targets[offsets[v]:offsets[v+1]]
+type csr struct {
+	offsets []int32
+	targets []int32
+}
+
+// neighbours returns the adjacent nodes of a node
+func (c *csr) neighbours(v int32) []int32 {
+	return c.targets[c.offsets[v]:c.offsets[v+1]]
+}
+
+// rawEdge is a call collected while building the graph, before it is packed into CSR form
+type rawEdge struct {
+	from, to int32
+	kind     uint8
+	line     int32
+	text     string
+}
+
+// newCSR packs edges into CSR form over n nodes, ordering each node's neighbours by target.
+// The returned permutation maps each packed position to its index in edges.
+func newCSR(n int, edges []rawEdge, reverse bool) (csr, []int32) {
+	endpoint := func(e *rawEdge) (int32, int32) {
+		if reverse {
+			return e.to, e.from
+		}
+		return e.from, e.to
+	}
+
+	// Counting sort by source, then order each row by target
+	c := csr{offsets: make([]int32, n+1), targets: make([]int32, len(edges))}
+	for i := range edges {
+		from, _ := endpoint(&edges[i])
+		c.offsets[from+1]++
+	}
+	for v := 0; v < n; v++ {
+		c.offsets[v+1] += c.offsets[v]
+	}
+	order := make([]int32, len(edges))
+	fill := append([]int32(nil), c.offsets[:n]...)
+	for i := range edges {
+		from, _ := endpoint(&edges[i])
+		order[fill[from]] = int32(i)
+		fill[from]++
+	}
+	for v := 0; v < n; v++ {
+		row := order[c.offsets[v]:c.offsets[v+1]]
+		if len(row) > 1 {
+			sort.Slice(row, func(i, j int) bool {
+				_, ti := endpoint(&edges[row[i]])
+				_, tj := endpoint(&edges[row[j]])
+				return ti < tj
+			})
+		}
+	}
+	for i, e := range order {
+		_, c.targets[i] = endpoint(&edges[e])
+	}
+	return c, order
+}
+
+// graphBuilder collects the functions and calls of a call graph, merging repeated calls
+type graphBuilder struct {
+	cg    *CallGraph
+	edges []rawEdge
+	seen  map[uint64]int32 // from<<32 | to -> index in edges
+}
+
+// addFunction registers a function, once per ID, under its name and aliases
+func (b *graphBuilder) addFunction(function *parser.Function) {
+	if _, ok := b.cg.index[function.ID]; ok {
+		return
+	}
+	v := int32(len(b.cg.ids))
+	b.cg.ids = append(b.cg.ids, function.ID)
+	b.cg.index[function.ID] = v
+	b.cg.locations = append(b.cg.locations, functionLocation{file: function.Filename, line: function.StartLine})
+	b.cg.functions[function.Name] = append(b.cg.functions[function.Name], v)
+	for _, alias := range function.Aliases {
+		b.cg.functions[alias] = append(b.cg.functions[alias], v)
+	}
+}
+
+// addEdge records a call from one function to another. Repeated calls keep the earliest call
+// site, and a direct call wins over an indirect or callback edge between the same pair.
+func (b *graphBuilder) addEdge(from, to int32, kind uint8, call parser.Callee) {
+	key := uint64(from)<<32 | uint64(uint32(to))
+	line := int32(call.Line)
+	if i, ok := b.seen[key]; ok {
+		e := &b.edges[i]
+		if kind == edgeDirect {
+			e.kind = edgeDirect
+		}
+		if line > 0 && (e.line == 0 || line < e.line) {
+			e.line, e.text = line, call.Snippet
+		}
+		return
+	}
+	b.seen[key] = int32(len(b.edges))
+	b.edges = append(b.edges, rawEdge{from: from, to: to, kind: kind, line: line, text: call.Snippet})
+}
+
+// finish packs the calls into forward and reverse CSR adjacency and condenses the graph's
+// strongly connected components
+func (b *graphBuilder) finish() {
+	cg := b.cg
+	n := len(cg.ids)
+
+	var order []int32
+	cg.calls, order = newCSR(n, b.edges, false)
+	cg.callers, _ = newCSR(n, b.edges, true)
+	cg.edgeKinds = make([]uint8, len(order))
+	cg.callLines = make([]int32, len(order))
+	cg.callText = make([]string, len(order))
+	for i, e := range order {
+		edge := &b.edges[e]
+		cg.edgeKinds[i], cg.callLines[i], cg.callText[i] = edge.kind, edge.line, strings.TrimSpace(edge.text)
+	}
+	b.edges, b.seen = nil, nil
+
+	cg.condense()
+}
+
+// condense labels each function with its strongly connected component using an iterative
+// Tarjan's algorithm, then builds the calls between components. Tarjan completes a component
+// only after every component it reaches, so a component can only reach ones numbered lower.
+func (cg *CallGraph) condense() {
+	n := int32(len(cg.ids))
+	index := make([]int32, n)
+	low := make([]int32, n)
+	onStack := make([]bool, n)
+	cg.component = make([]int32, n)
+	for v := range index {
+		index[v] = -1
+	}
+
+	type frame struct{ v, next int32 }
+	var stack []int32
+	var counter, components int32
+	for root := int32(0); root < n; root++ {
+		if index[root] >= 0 {
+			continue
+		}
+		index[root], low[root] = counter, counter
+		counter++
+		stack = append(stack, root)
+		onStack[root] = true
+		calls := []frame{{root, cg.calls.offsets[root]}}
+
+		for len(calls) > 0 {
+			f := &calls[len(calls)-1]
+			v := f.v
+			if f.next < cg.calls.offsets[v+1] {
+				w := cg.calls.targets[f.next]
+				f.next++
+				if index[w] < 0 {
+					index[w], low[w] = counter, counter
+					counter++
+					stack = append(stack, w)
+					onStack[w] = true
+					calls = append(calls, frame{w, cg.calls.offsets[w]})
+				} else if onStack[w] && index[w] < low[v] {
+					low[v] = index[w]
+				}
+				continue
+			}
+
+			if low[v] == index[v] {
+				for {
+					w := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					onStack[w] = false
+					cg.component[w] = components
+					if w == v {
+						break
+					}
+				}
+				components++
+			}
+			calls = calls[:len(calls)-1]
+			if len(calls) > 0 {
+				if u := calls[len(calls)-1].v; low[v] < low[u] {
+					low[u] = low[v]
+				}
+			}
+		}
+	}
+	cg.components = int(components)
+
+	// Calls between components, and which components are recursive
+	cg.recursive = make([]bool, components)
+	size := make([]int32, components)
+	for v := int32(0); v < n; v++ {
+		size[cg.component[v]]++
+	}
+	seen := make(map[uint64]bool)
+	var edges []rawEdge
+	for v := int32(0); v < n; v++ {
+		cv := cg.component[v]
+		for _, w := range cg.calls.neighbours(v) {
+			cw := cg.component[w]
+			if cv == cw {
+				cg.recursive[cv] = true
+				continue
+			}
+			key := uint64(cv)<<32 | uint64(uint32(cw))
+			if !seen[key] {
+				seen[key] = true
+				edges = append(edges, rawEdge{from: cv, to: cw})
+			}
+		}
+	}
+	for c, s := range size {
+		if s > 1 {
+			cg.recursive[c] = true
+		}
+	}
+	cg.dagCallers, _ = newCSR(int(components), edges, true)
+}
+
+// reachSet is a bitset over components
+type reachSet []uint64
+
+func (s reachSet) has(c int32) bool { return s[c>>6]&(1<<(uint(c)&63)) != 0 }
+func (s reachSet) add(c int32)      { s[c>>6] |= 1 << (uint(c) & 63) }
+
+// reaching returns the components that can reach a component, itself included. The first
+// maxReachSets are memoized, so repeated queries for the same use or free function are a
+// bitset lookup.
+func (cg *CallGraph) reaching(c int32) reachSet {
+	if cached, ok := cg.reachCache.Load(c); ok {
+		return cached.(reachSet)
+	}
+
+	set := make(reachSet, (cg.components+63)/64)
+	set.add(c)
+	frontier := []int32{c}
+	for len(frontier) > 0 {
+		next := frontier[len(frontier)-1]
+		frontier = frontier[:len(frontier)-1]
+		for _, caller := range cg.dagCallers.neighbours(next) {
+			if !set.has(caller) {
+				set.add(caller)
+				frontier = append(frontier, caller)
+			}
+		}
+	}
+
+	if cg.reachSets.Load() < maxReachSets {
+		if _, loaded := cg.reachCache.LoadOrStore(c, set); !loaded {
+			cg.reachSets.Add(1)
+		}
+	}
+	return set
+}
+
+// canReach reports whether one function can reach another through any number of calls
+func (cg *CallGraph) canReach(from, to int32) bool {
+	cf, ct := cg.component[from], cg.component[to]
+	if cf == ct {
+		return from == to || cg.recursive[cf]
+	}
+	if cf < ct {
+		return false
+	}
+	return cg.reaching(ct).has(cf)
+}
+
+// mayReach is canReach for per-finding queries: a target's reachability set costs a walk over
+// all its ancestors, so it is only built once the target comes up a second time. Until then
+// the answer is a conservative true unless the component order rules the call out.
+func (cg *CallGraph) mayReach(from, to int32) bool {
+	cf, ct := cg.component[from], cg.component[to]
+	if cf == ct {
+		return from == to || cg.recursive[cf]
+	}
+	if cf < ct {
+		return false
+	}
+	if cached, ok := cg.reachCache.Load(ct); ok {
+		return cached.(reachSet).has(cf)
+	}
+	if _, seen := cg.reachQueried.LoadOrStore(ct, true); !seen {
+		return true
+	}
+	return cg.reaching(ct).has(cf)
+}
+
+// edge returns the position of the call from one function to another in the CSR arrays, or
+// -1 when there is none
+func (cg *CallGraph) edge(from, to int32) int32 {
+	callees := cg.calls.neighbours(from)
+	i := sort.Search(len(callees), func(i int) bool { return callees[i] >= to })
+	if i < len(callees) && callees[i] == to {
+		return cg.calls.offsets[from] + int32(i)
+	}
+	return -1
+}
+
+// callLine returns the earliest line where one function calls another, or 0
+func (cg *CallGraph) callLine(from, to int32) int {
+	if e := cg.edge(from, to); e >= 0 {
+		return int(cg.callLines[e])
+	}
+	return 0
+}
@@ -0,0 +1,239 @@
+package codeql
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+func TestCondense(t *testing.T) {
+	// a and b call each other, d calls itself, e is isolated
+	cg := graphOf("a->b", "b->a", "b->c", "c->d", "d->d", "e")
+
+	if cg.component[node(cg, "a")] != cg.component[node(cg, "b")] {
+		t.Error("a and b are in different components")
+	}
+	if cg.components != 4 {
+		t.Errorf("components = %d, want 4", cg.components)
+	}
+	for _, tt := range []struct {
+		name      string
+		recursive bool
+	}{{"a", true}, {"b", true}, {"c", false}, {"d", true}, {"e", false}} {
+		if got := cg.recursive[cg.component[node(cg, tt.name)]]; got != tt.recursive {
+			t.Errorf("%s recursive = %v, want %v", tt.name, got, tt.recursive)
+		}
+	}
+	// Callers' components are numbered after their callees'
+	for v := int32(0); v < int32(len(cg.ids)); v++ {
+		for _, w := range cg.calls.neighbours(v) {
+			if cg.component[v] < cg.component[w] {
+				t.Errorf("%s calls %s in a later component", cg.ids[v], cg.ids[w])
+			}
+		}
+	}
+}
+
+func TestCanReach(t *testing.T) {
+	cg := graphOf("a->b", "b->a", "b->c", "c->d", "d->d", "e")
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"a", "d", true},
+		{"b", "a", true},
+		{"c", "c", true}, // Every function reaches itself
+		{"d", "c", false},
+		{"d", "d", true},
+		{"d", "a", false},
+		{"e", "a", false},
+		{"a", "e", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			from, to := node(cg, tt.from), node(cg, tt.to)
+			if got := cg.canReach(from, to); got != tt.want {
+				t.Errorf("canReach = %v, want %v", got, tt.want)
+			}
+			// mayReach may only be conservative the first time a target is queried
+			for i := 0; i < 2; i++ {
+				if got := cg.mayReach(from, to); !got && tt.want {
+					t.Errorf("mayReach = false, want true")
+				}
+			}
+			if got := cg.mayReach(from, to); got != tt.want {
+				t.Errorf("memoized mayReach = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallEdges(t *testing.T) {
+	cg := BuildCallGraph([]parser.Function{
+		{ID: "g.c:1:a", Name: "a", Callees: []parser.Callee{
+			{Name: "b", Line: 5, Snippet: "b();"},
+			{Name: "c", Line: 3, Snippet: "c(b);", Args: []string{"b"}},
+			{Name: "c", Line: 2, Snippet: "c(0);", Args: []string{"0"}},
+		}},
+		{ID: "g.c:2:b", Name: "b"},
+		{ID: "g.c:3:c", Name: "c"},
+	})
+	a, b, c := node(cg, "a"), node(cg, "b"), node(cg, "c")
+	tests := []struct {
+		to   int32
+		kind uint8
+		line int
+	}{
+		{b, edgeDirect, 3}, // The direct call wins over the earlier callback, which keeps its line
+		{c, edgeDirect, 2},
+	}
+	for _, tt := range tests {
+		e := cg.edge(a, tt.to)
+		if e < 0 {
+			t.Fatalf("no edge to %s", cg.ids[tt.to])
+		}
+		if cg.edgeKinds[e] != tt.kind || cg.callLine(a, tt.to) != tt.line {
+			t.Errorf("edge to %s = %s at %d, want %s at %d", cg.ids[tt.to], edgeKindNames[cg.edgeKinds[e]], cg.callLine(a, tt.to), edgeKindNames[tt.kind], tt.line)
+		}
+	}
+	if got := names(cg, cg.callers.neighbours(b)); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("callers of b = %v, want [a]", got)
+	}
+	if cg.edge(b, a) >= 0 {
+		t.Error("edge from b to a")
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	// Two routes from a to e: a-b-e and a-c-d-e
+	cg := graphOf("a->b", "b->e", "a->c", "c->d", "d->e", "e->a")
+	tests := []struct {
+		name         string
+		from, to     string
+		maxDepth     int
+		blockedNodes []string
+		blockedEdge  [2]string
+		want         []string
+	}{
+		{name: "shortest", from: "a", to: "e", maxDepth: -1, want: []string{"a", "b", "e"}},
+		{name: "same function", from: "a", to: "a", maxDepth: -1, want: []string{"a"}},
+		{name: "blocked node", from: "a", to: "e", maxDepth: -1, blockedNodes: []string{"b"}, want: []string{"a", "c", "d", "e"}},
+		{name: "blocked edge", from: "a", to: "e", maxDepth: -1, blockedEdge: [2]string{"b", "e"}, want: []string{"a", "c", "d", "e"}},
+		{name: "depth bound", from: "a", to: "e", maxDepth: 2, blockedNodes: []string{"b"}},
+		{name: "depth fits", from: "a", to: "e", maxDepth: 3, blockedNodes: []string{"b"}, want: []string{"a", "c", "d", "e"}},
+		{name: "zero depth", from: "a", to: "e", maxDepth: 0},
+		{name: "through the cycle", from: "d", to: "b", maxDepth: -1, want: []string{"d", "e", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blockedNodes := make(map[int32]bool)
+			for _, name := range tt.blockedNodes {
+				blockedNodes[node(cg, name)] = true
+			}
+			blockedEdges := make(map[[2]int32]bool)
+			if tt.blockedEdge[0] != "" {
+				blockedEdges[[2]int32{node(cg, tt.blockedEdge[0]), node(cg, tt.blockedEdge[1])}] = true
+			}
+			path := cg.shortestPath(node(cg, tt.from), node(cg, tt.to), tt.maxDepth, blockedNodes, blockedEdges)
+			if got := names(cg, path); len(got) != len(tt.want) || len(got) > 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shortestPath = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKShortestPaths(t *testing.T) {
+	cg := graphOf("s->a", "a->t", "s->b", "b->t", "s->c", "c->d", "d->t", "a->b", "t->s")
+	tests := []struct {
+		name     string
+		k        int
+		maxDepth int
+		want     [][]string
+	}{
+		{"all", 10, -1, [][]string{{"s", "a", "t"}, {"s", "b", "t"}, {"s", "a", "b", "t"}, {"s", "c", "d", "t"}}},
+		{"k bound", 2, -1, [][]string{{"s", "a", "t"}, {"s", "b", "t"}}},
+		{"depth bound", 10, 2, [][]string{{"s", "a", "t"}, {"s", "b", "t"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, complete := cg.kShortestPaths(node(cg, "s"), node(cg, "t"), tt.k, tt.maxDepth, time.Time{})
+			if !complete {
+				t.Error("search reported incomplete without a deadline")
+			}
+			var got [][]string
+			for _, path := range paths {
+				got = append(got, names(cg, path))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("paths = %v, want %v", got, tt.want)
+			}
+			// Paths of equal length may come in either order
+			for i := range got {
+				if len(got[i]) != len(tt.want[i]) {
+					t.Errorf("path %d = %v, want length %d", i, got[i], len(tt.want[i]))
+				}
+			}
+			seen := make(map[string]bool)
+			for _, path := range got {
+				seen[fmt.Sprint(path)] = true
+			}
+			for _, path := range tt.want {
+				if !seen[fmt.Sprint(path)] {
+					t.Errorf("paths = %v, missing %v", got, path)
+				}
+			}
+		})
+	}
+}
+
+func TestReverseBFSVisitBound(t *testing.T) {
+	// Every caller is on the first level, so only a per-node check can stop the search
+	calls := make([]string, 0, maxAncestorVisits+10)
+	for i := 0; i < maxAncestorVisits+10; i++ {
+		calls = append(calls, fmt.Sprintf("f%d->hub", i))
+	}
+	cg := graphOf(calls...)
+	s := cg.scratch()
+	defer cg.scratchPool.Put(s)
+	search := cg.reverseBFS(ancestorSearch{stamp: s.stamp, mark: s.forward, depth: s.depth, next: s.parent}, node(cg, "hub"), 4)
+	if len(search.visited) != maxAncestorVisits {
+		t.Errorf("visited %d ancestors, want %d", len(search.visited), maxAncestorVisits)
+	}
+}
+
+// Findings sharing a common caller share its memoized chains; making them relative for one
+// finding must not rewrite them for the others. Run with -race.
+func TestRelativeChainsShareCache(t *testing.T) {
+	f := loadC(t, ancestorsSource)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 8; j++ {
+				validation := f.graph.ValidateCallRelationship("do_free", "do_use", f.graph.pathOptions.MaxDepth)
+				validation.relativeTo(f.dir)
+				for _, chain := range validation.CallChains {
+					for _, hop := range chain.Hops {
+						if hop.File != "test.c" {
+							t.Errorf("hop file = %q, want test.c", hop.File)
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, ancestor := range f.graph.commonAncestors(f.id(t, "do_free"), f.id(t, "do_use"), f.graph.pathOptions.MaxDepth) {
+		for _, hop := range append(ancestor.FreePath.Hops, ancestor.UsePath.Hops...) {
+			if hop.File != f.functions[hop.Function].Filename {
+				t.Errorf("cached hop file = %q, want %q", hop.File, f.functions[hop.Function].Filename)
+			}
+		}
+	}
+}
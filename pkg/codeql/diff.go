@@ -0,0 +1,431 @@
+package codeql
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// GraphDiff is how the call graph changed between two revisions of a source tree. Functions are
+// matched by file and name, since their definition lines shift between revisions.
+type GraphDiff struct {
+	AddedFunctions   []DiffFunction    `json:"added_funcs"`
+	RemovedFunctions []DiffFunction    `json:"removed_funcs"`
+	AddedEdges       []DiffEdge        `json:"added_edges"`
+	RemovedEdges     []DiffEdge        `json:"removed_edges"`
+	NewPaths         []NewPath         `json:"new_paths"`            // Shortest first
+	MorePaths        int               `json:"more_paths,omitempty"` // New paths left out by the limit
+	Findings         []AffectedFinding `json:"findings,omitempty"`
+
+	removedFunctions map[string]bool
+	removedEdges     map[[2]string]bool
+	changed          map[string]bool     // Functions whose calls were added or removed
+	routes           map[string][]string // Function -> entry points or roots newly reaching it
+}
+
+// DiffFunction is a function only one revision defines
+type DiffFunction struct {
+	Function string `json:"func"`
+	File     string `json:"file"`
+	DefLine  int    `json:"def_ln"`
+}
+
+// DiffEdge is a call only one revision makes
+type DiffEdge struct {
+	From     string `json:"from"`
+	FromFile string `json:"from_file"`
+	To       string `json:"to"`
+	ToFile   string `json:"to_file"`
+	Edge     string `json:"edge"`
+	CallLine int    `json:"call_ln,omitempty"`
+	NewRoute bool   `json:"new_route,omitempty"` // An added call whose caller did not reach the callee before
+}
+
+// NewPath is a call chain from an entry point, or a function nothing calls, to a target function
+// it did not reach in the old revision
+type NewPath struct {
+	From  string    `json:"from"`
+	Kind  string    `json:"kind,omitempty"` // Entry point kind; empty for a root function
+	To    string    `json:"to"`
+	Chain CallChain `json:"chain"`
+}
+
+// AffectedFinding is a finding whose call chains the changes touch
+type AffectedFinding struct {
+	Index        int      `json:"index"` // Position in the results, counting from 1
+	FreeFunction string   `json:"free_func"`
+	UseFunction  string   `json:"use_func"`
+	Reasons      []string `json:"reasons"`
+}
+
+// DiffOptions configures the search for new paths
+type DiffOptions struct {
+	Targets  []string // Function keys new paths are searched to, e.g. from FreeSites
+	MaxPaths int      // New paths reported, shortest first (0 = no limit)
+}
+
+// FunctionKey identifies a function across revisions by its file, relative to the source
+// directory, and its name
+func FunctionKey(file, function string) string {
+	return filepath.ToSlash(file) + ":" + function
+}
+
+// FreeSites returns the keys of the functions calling a deallocator
+func FreeSites(result *parser.AnalysisResult, sourceDir string) []string {
+	var keys []string
+	for _, function := range result.Functions {
+		for _, callee := range function.Callees {
+			if parser.IsDeallocator(callee.Name) {
+				keys = append(keys, FunctionKey(diffRelative(sourceDir, function.Filename), function.Name))
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// diffSide is one revision's call graph with its functions keyed by file and name
+type diffSide struct {
+	cg   *CallGraph
+	dir  string
+	keys []string         // Function index -> key
+	by   map[string]int32 // Key -> first function index with it
+}
+
+func newDiffSide(cg *CallGraph, dir string) *diffSide {
+	side := &diffSide{cg: cg, dir: dir, keys: make([]string, len(cg.ids)), by: make(map[string]int32, len(cg.ids))}
+	for v := range cg.ids {
+		key := FunctionKey(diffRelative(dir, cg.locations[v].file), extractFunctionName(cg.ids[v]))
+		side.keys[v] = key
+		if _, ok := side.by[key]; !ok {
+			side.by[key] = int32(v)
+		}
+	}
+	return side
+}
+
+// edgeSet returns each call between functions by caller and callee key
+func (s *diffSide) edgeSet() map[[2]string]int32 {
+	edges := make(map[[2]string]int32)
+	for v := range s.cg.ids {
+		from := int32(v)
+		for i, to := range s.cg.calls.neighbours(from) {
+			pair := [2]string{s.keys[from], s.keys[to]}
+			if _, ok := edges[pair]; !ok {
+				edges[pair] = s.cg.calls.offsets[from] + int32(i)
+			}
+		}
+	}
+	return edges
+}
+
+// diffEdge describes the call at a CSR position of a revision
+func (s *diffSide) diffEdge(e int32, pair [2]string) DiffEdge {
+	from := s.by[pair[0]]
+	to := s.by[pair[1]]
+	return DiffEdge{
+		From:     extractFunctionName(s.cg.ids[from]),
+		FromFile: diffRelative(s.dir, s.cg.locations[from].file),
+		To:       extractFunctionName(s.cg.ids[to]),
+		ToFile:   diffRelative(s.dir, s.cg.locations[to].file),
+		Edge:     edgeKindNames[s.cg.edgeKinds[e]],
+		CallLine: int(s.cg.callLines[e]),
+	}
+}
+
+// DiffCallGraphs compares the call graphs of an old and a new revision. New paths start at the
+// new graph's entry points, or at functions nothing calls when it has none, and are searched only
+// from those reaching an added call or function.
+func DiffCallGraphs(oldGraph, newGraph *CallGraph, oldDir, newDir string, options DiffOptions) *GraphDiff {
+	before, after := newDiffSide(oldGraph, oldDir), newDiffSide(newGraph, newDir)
+	diff := &GraphDiff{
+		removedFunctions: make(map[string]bool),
+		removedEdges:     make(map[[2]string]bool),
+		changed:          make(map[string]bool),
+		routes:           make(map[string][]string),
+	}
+
+	var seeds []int32
+	for key, v := range after.by {
+		if _, ok := before.by[key]; !ok {
+			diff.AddedFunctions = append(diff.AddedFunctions, after.diffFunction(v))
+			seeds = append(seeds, v)
+		}
+	}
+	for key, v := range before.by {
+		if _, ok := after.by[key]; !ok {
+			diff.RemovedFunctions = append(diff.RemovedFunctions, before.diffFunction(v))
+			diff.removedFunctions[key] = true
+		}
+	}
+
+	oldEdges, newEdges := before.edgeSet(), after.edgeSet()
+	for pair, e := range newEdges {
+		if _, ok := oldEdges[pair]; ok {
+			continue
+		}
+		edge := after.diffEdge(e, pair)
+		from, fromOK := before.by[pair[0]]
+		to, toOK := before.by[pair[1]]
+		edge.NewRoute = !fromOK || !toOK || !oldGraph.canReach(from, to)
+		diff.AddedEdges = append(diff.AddedEdges, edge)
+		diff.changed[pair[0]] = true
+		seeds = append(seeds, after.by[pair[0]])
+	}
+	for pair, e := range oldEdges {
+		if _, ok := newEdges[pair]; ok {
+			continue
+		}
+		diff.RemovedEdges = append(diff.RemovedEdges, before.diffEdge(e, pair))
+		diff.removedEdges[pair] = true
+		diff.changed[pair[0]] = true
+	}
+
+	sortDiffFunctions(diff.AddedFunctions)
+	sortDiffFunctions(diff.RemovedFunctions)
+	sortDiffEdges(diff.AddedEdges)
+	sortDiffEdges(diff.RemovedEdges)
+
+	diff.findNewPaths(before, after, seeds, options)
+	return diff
+}
+
+// targetSearch is a reverse BFS from a target towards the sources that newly reach it
+type targetSearch struct {
+	pending  map[int32]bool  // Sources not reached yet
+	next     map[int32]int32 // Function -> next function towards the target
+	frontier []int32
+}
+
+// findNewPaths records the shortest path from each source to each target it reaches in the new
+// revision but did not in the old one. Which pairs are new comes from the condensations' reach
+// sets; their paths are then found by reverse BFS from every such target in step, one call
+// further each round, so they come shortest first and the search stops at MaxPaths.
+func (d *GraphDiff) findNewPaths(before, after *diffSide, seeds []int32, options DiffOptions) {
+	if len(seeds) == 0 || len(options.Targets) == 0 {
+		return
+	}
+	var targets []int32
+	seen := make(map[int32]bool)
+	for _, key := range options.Targets {
+		if v, ok := after.by[key]; ok && !seen[v] {
+			seen[v] = true
+			targets = append(targets, v)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	// Only sources calling, possibly indirectly, into the change can gain a path
+	var sources []int32
+	for _, v := range reachable(&after.cg.callers, len(after.cg.ids), seeds...) {
+		if after.isSource(v) {
+			sources = append(sources, v)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+
+	var searches []*targetSearch
+	total := 0
+	for _, target := range targets {
+		key := after.keys[target]
+		reaching := after.cg.reaching(after.cg.component[target])
+		var reachedBefore reachSet
+		if old, ok := before.by[key]; ok {
+			reachedBefore = before.cg.reaching(before.cg.component[old])
+		}
+
+		search := &targetSearch{pending: make(map[int32]bool), next: map[int32]int32{target: -1}, frontier: []int32{target}}
+		for _, source := range sources {
+			if source == target || !reaching.has(after.cg.component[source]) {
+				continue
+			}
+			if old, ok := before.by[after.keys[source]]; ok && reachedBefore != nil && reachedBefore.has(before.cg.component[old]) {
+				continue
+			}
+			search.pending[source] = true
+			d.routes[key] = append(d.routes[key], extractFunctionName(after.cg.ids[source]))
+		}
+		if len(search.pending) > 0 {
+			total += len(search.pending)
+			searches = append(searches, search)
+		}
+	}
+
+	var paths []NewPath
+	full := func() bool { return options.MaxPaths > 0 && len(paths) >= options.MaxPaths }
+	for len(searches) > 0 && !full() {
+		active := searches[:0]
+		for _, search := range searches {
+			var frontier []int32
+			for _, v := range search.frontier {
+				for _, caller := range after.cg.callers.neighbours(v) {
+					if _, ok := search.next[caller]; ok {
+						continue
+					}
+					search.next[caller] = v
+					frontier = append(frontier, caller)
+					if search.pending[caller] {
+						delete(search.pending, caller)
+						if !full() {
+							paths = append(paths, after.newPath(caller, search.next))
+						}
+					}
+				}
+			}
+			search.frontier = frontier
+			if len(search.pending) > 0 && len(frontier) > 0 {
+				active = append(active, search)
+			}
+		}
+		searches = active
+	}
+	d.MorePaths = total - len(paths)
+	d.NewPaths = paths
+}
+
+// newPath follows a target search from a source down to its target
+func (s *diffSide) newPath(source int32, next map[int32]int32) NewPath {
+	path := []int32{source}
+	for v := next[source]; v >= 0; v = next[v] {
+		path = append(path, v)
+	}
+	newPath := NewPath{
+		From:  extractFunctionName(s.cg.ids[source]),
+		To:    extractFunctionName(s.cg.ids[path[len(path)-1]]),
+		Chain: s.cg.newCallChain(path).relativeTo(s.dir),
+	}
+	if s.cg.entries != nil {
+		newPath.Kind = s.cg.entries.kindNames(s.cg.entries.own[source])[0]
+	}
+	return newPath
+}
+
+// isSource reports whether new paths start at a function: an entry point, or with none set, a
+// function nothing calls
+func (s *diffSide) isSource(v int32) bool {
+	if s.cg.entries != nil {
+		return s.cg.entries.own[v] != 0
+	}
+	return len(s.cg.callers.neighbours(v)) == 0
+}
+
+func (s *diffSide) diffFunction(v int32) DiffFunction {
+	return DiffFunction{
+		Function: extractFunctionName(s.cg.ids[v]),
+		File:     diffRelative(s.dir, s.cg.locations[v].file),
+		DefLine:  s.cg.locations[v].line,
+	}
+}
+
+// Affects explains how the changes touch a finding's call chains, or returns nil when they do
+// not. Chain files are relative to the source directory, as "slice query" writes them.
+func (d *GraphDiff) Affects(result CodeQLResult, validation *CallValidation) []string {
+	chains := [][]string{{
+		FunctionKey(result.FreeFunctionFile, result.FreeFunctionName),
+		FunctionKey(result.UseFunctionFile, result.UseFunctionName),
+	}}
+	if validation != nil {
+		for _, chain := range validation.CallChains {
+			var keys []string
+			for _, hop := range chain.Hops {
+				keys = append(keys, FunctionKey(hop.File, hop.Function))
+			}
+			if len(keys) > 0 {
+				chains = append(chains, keys)
+			}
+		}
+	}
+
+	var reasons []string
+	seen := make(map[string]bool)
+	add := func(reason string) {
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	for i, chain := range chains {
+		for j, key := range chain {
+			name := key[strings.LastIndex(key, ":")+1:]
+			switch {
+			case d.removedFunctions[key]:
+				add(fmt.Sprintf("chain function removed: %s", name))
+			case d.changed[key]:
+				add(fmt.Sprintf("calls of chain function changed: %s", name))
+			}
+			if i > 0 && j+1 < len(chain) && d.removedEdges[[2]string{key, chain[j+1]}] {
+				next := chain[j+1]
+				add(fmt.Sprintf("chain call removed: %s -> %s", name, next[strings.LastIndex(next, ":")+1:]))
+			}
+		}
+	}
+	for _, role := range []struct{ role, function, key string }{
+		{"free", result.FreeFunctionName, chains[0][0]},
+		{"use", result.UseFunctionName, chains[0][1]},
+	} {
+		if from := d.routes[role.key]; len(from) > 0 {
+			add(fmt.Sprintf("new path from %s to %s function %s", strings.Join(from, ", "), role.role, role.function))
+		}
+	}
+	return reasons
+}
+
+// reachable returns the functions reachable from the starts over an adjacency, in BFS order
+func reachable(adjacency *csr, n int, starts ...int32) []int32 {
+	seen := make([]bool, n)
+	var order []int32
+	for _, v := range starts {
+		if !seen[v] {
+			seen[v] = true
+			order = append(order, v)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		for _, w := range adjacency.neighbours(order[i]) {
+			if !seen[w] {
+				seen[w] = true
+				order = append(order, w)
+			}
+		}
+	}
+	return order
+}
+
+func sortDiffFunctions(functions []DiffFunction) {
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].File != functions[j].File {
+			return functions[i].File < functions[j].File
+		}
+		if functions[i].DefLine != functions[j].DefLine {
+			return functions[i].DefLine < functions[j].DefLine
+		}
+		return functions[i].Function < functions[j].Function
+	})
+}
+
+func sortDiffEdges(edges []DiffEdge) {
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.FromFile != b.FromFile {
+			return a.FromFile < b.FromFile
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.ToFile < b.ToFile
+	})
+}
+
+// diffRelative returns a file relative to a source directory when it lies inside it
+func diffRelative(dir, file string) string {
+	if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(file)
+}
@@ -0,0 +1,124 @@
+package codeql
+
+import (
+	"reflect"
+	"testing"
+)
+
+// diffGraphs are an old and a new revision: the new one adds free_n, called from a, has y
+// call free_s and x call b, calls b straight from main, and drops z
+var diffGraphs = [2][]string{
+	{"main->a", "a->b", "x->y", "x->z", "free_s"},
+	{"main->a", "a->b", "a->free_n", "main->b", "x->y", "y->free_s", "x->b"},
+}
+
+func TestDiffCallGraphs(t *testing.T) {
+	oldGraph, newGraph := graphOf(diffGraphs[0]...), graphOf(diffGraphs[1]...)
+	diff := DiffCallGraphs(oldGraph, newGraph, ".", ".", DiffOptions{})
+
+	var added, removed []string
+	for _, function := range diff.AddedFunctions {
+		added = append(added, function.Function)
+	}
+	for _, function := range diff.RemovedFunctions {
+		removed = append(removed, function.Function)
+	}
+	if !reflect.DeepEqual(added, []string{"free_n"}) || !reflect.DeepEqual(removed, []string{"z"}) {
+		t.Errorf("added %v and removed %v, want [free_n] and [z]", added, removed)
+	}
+
+	type edge struct {
+		From, To string
+		NewRoute bool
+	}
+	var addedEdges, removedEdges []edge
+	for _, e := range diff.AddedEdges {
+		addedEdges = append(addedEdges, edge{e.From, e.To, e.NewRoute})
+	}
+	for _, e := range diff.RemovedEdges {
+		removedEdges = append(removedEdges, edge{e.From, e.To, e.NewRoute})
+	}
+	// main already reached b through a
+	wantAdded := []edge{{"a", "free_n", true}, {"main", "b", false}, {"x", "b", true}, {"y", "free_s", true}}
+	if !reflect.DeepEqual(addedEdges, wantAdded) {
+		t.Errorf("added edges = %v, want %v", addedEdges, wantAdded)
+	}
+	if want := []edge{{"x", "z", false}}; !reflect.DeepEqual(removedEdges, want) {
+		t.Errorf("removed edges = %v, want %v", removedEdges, want)
+	}
+	if diff.NewPaths != nil {
+		t.Errorf("new paths without targets = %v", diff.NewPaths)
+	}
+}
+
+func TestDiffNewPaths(t *testing.T) {
+	oldGraph, newGraph := graphOf(diffGraphs[0]...), graphOf(diffGraphs[1]...)
+	targets := []string{"g.c:free_s", "g.c:free_n", "g.c:b", "g.c:missing"}
+
+	// main reached b before; x is a new caller of both b and free_s
+	all := [][]string{{"x", "b"}, {"main", "a", "free_n"}, {"x", "y", "free_s"}}
+	tests := []struct {
+		name     string
+		maxPaths int
+		want     [][]string
+		more     int
+	}{
+		{"no limit", 0, all, 0},
+		{"limit", 2, all[:2], 1},
+		{"shortest only", 1, all[:1], 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffCallGraphs(oldGraph, newGraph, ".", ".", DiffOptions{Targets: targets, MaxPaths: tt.maxPaths})
+			var got [][]string
+			for _, path := range diff.NewPaths {
+				got = append(got, path.Chain.Functions)
+				if path.From != path.Chain.Functions[0] || path.To != path.Chain.Functions[len(path.Chain.Functions)-1] {
+					t.Errorf("path %s -> %s has chain %v", path.From, path.To, path.Chain.Functions)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) || diff.MorePaths != tt.more {
+				t.Errorf("new paths = %v and %d more, want %v and %d more", got, diff.MorePaths, tt.want, tt.more)
+			}
+
+			// Routes cover every new path, not only those listed
+			result := CodeQLResult{FreeFunctionName: "free_s", FreeFunctionFile: "g.c", UseFunctionName: "free_n", UseFunctionFile: "g.c"}
+			reasons := diff.Affects(result, nil)
+			want := []string{"new path from x to free function free_s", "new path from main to use function free_n"}
+			if !reflect.DeepEqual(reasons, want) {
+				t.Errorf("Affects = %v, want %v", reasons, want)
+			}
+		})
+	}
+}
+
+func TestDiffAffects(t *testing.T) {
+	oldGraph, newGraph := graphOf(diffGraphs[0]...), graphOf(diffGraphs[1]...)
+	diff := DiffCallGraphs(oldGraph, newGraph, ".", ".", DiffOptions{})
+
+	chain := oldGraph.newCallChain([]int32{node(oldGraph, "x"), node(oldGraph, "z")})
+	tests := []struct {
+		name       string
+		result     CodeQLResult
+		validation *CallValidation
+		want       []string
+	}{
+		{
+			name:   "untouched",
+			result: CodeQLResult{FreeFunctionName: "b", FreeFunctionFile: "g.c", UseFunctionName: "b", UseFunctionFile: "g.c"},
+		},
+		{
+			name:       "removed chain",
+			result:     CodeQLResult{FreeFunctionName: "x", FreeFunctionFile: "g.c", UseFunctionName: "z", UseFunctionFile: "g.c"},
+			validation: &CallValidation{CallChains: []CallChain{chain}},
+			want:       []string{"calls of chain function changed: x", "chain function removed: z", "chain call removed: x -> z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff.Affects(tt.result, tt.validation); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Affects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,6 +19,14 @@ import (
 type QueryEnricher struct {
 	sourceDir string
 	logger    *slog.Logger
+	prefilter bool
+	budget    int
+	count     bool
+
+	refcountOnce sync.Once
+	refcount     *parser.RefcountModel
+	entryOnce    sync.Once
+	entries      map[string][]parser.EntryPoint
 }
 
 // NewQueryEnricher creates a new query enricher
@@ -29,6 +37,27 @@ func NewQueryEnricher(sourceDir string) *QueryEnricher {
 	}
 }
 
+// SetPrefilter enables the static likely-false-positive checks on each finding
+func (e *QueryEnricher) SetPrefilter(enabled bool) {
+	e.prefilter = enabled
+}
+
+// SetTokenBudget packs each finding's function code into a number of o200k_base tokens
+// (0 = no limit); see PackContext
+func (e *QueryEnricher) SetTokenBudget(budget int) {
+	e.budget = budget
+}
+
+// SetCountTokens records the token count of each finding's function code even without a budget
+func (e *QueryEnricher) SetCountTokens(enabled bool) {
+	e.count = enabled
+}
+
+// countsTokens reports whether findings get their function code counted, and packed
+func (e *QueryEnricher) countsTokens() bool {
+	return e.budget > 0 || e.count
+}
+
 // EnrichResults enriches CodeQL results with source code and validation using parallel processing
 func (e *QueryEnricher) EnrichResults(results []CodeQLResult, callGraph *CallGraph, validateCalls bool, callDepth int, concurrency int) ([]Finding, error) {
 	// Use atomic counters for thread-safe statistics
@@ -77,8 +106,9 @@ func (e *QueryEnricher) EnrichResults(results []CodeQLResult, callGraph *CallGra
 			defer wg.Done()
 			
 			for item := range workChan {
-				// Process each result
-				finding, err := e.enrichWithSourceCode(item.result)
+				// Process each result, parsing each function it looks at once
+				parsed := newParsedFunctions()
+				finding, err := e.enrichWithSourceCode(item.result, callGraph, parsed)
 				if err != nil {
 					e.logger.Warn("failed to enrich result with source code",
 						"component", "codeql",
@@ -94,11 +124,12 @@ func (e *QueryEnricher) EnrichResults(results []CodeQLResult, callGraph *CallGra
 
 				// Perform call chain validation if enabled
 				includeResult := true
+				var intermediateFuncs []string
 				if validateCalls && callGraph != nil {
-					// Use callDepth for search, but default to 10 if -1 (no limit for filtering)
+					// Use callDepth for search, but default to the call graph's chain depth if -1 (no limit for filtering)
 					searchDepth := callDepth
 					if searchDepth < 0 {
-						searchDepth = 10
+						searchDepth = callGraph.pathOptions.MaxDepth
 					}
 					validation := callGraph.ValidateCallRelationship(item.result.FreeFunctionName, item.result.UseFunctionName, searchDepth)
 					finding.CallValidation = validation
@@ -113,20 +144,21 @@ func (e *QueryEnricher) EnrichResults(results []CodeQLResult, callGraph *CallGra
 							validationStats.valid.Add(1)
 							
 							// Populate intermediate functions from call chains
-							intermediateFuncs := e.extractIntermediateFunctions(validation.CallChains, item.result.FreeFunctionName, item.result.UseFunctionName)
-							for _, funcName := range intermediateFuncs {
-								// Try to find the function definition
-								funcCode, err := e.findFunctionByName(funcName)
+							validation.relativeTo(e.sourceDir)
+							intermediateFuncs = e.extractIntermediateFunctions(validation, item.result)
+							for _, funcID := range intermediateFuncs {
+								// Find the exact definition the chain passes through
+								funcCode, err := e.findFunctionCode(funcID)
 								if err != nil {
 									e.logger.Debug("could not find intermediate function",
 										"component", "codeql",
 										"worker", workerID,
-										"function", funcName,
+										"function", funcID,
 										"error", err)
 									// Add empty function code as placeholder
 									finding.SourceCode.IntermediateFunctions = append(finding.SourceCode.IntermediateFunctions, 
 										FunctionCode{
-											DefinitionWithLineNumbers: fmt.Sprintf("// Function %s not found", funcName),
+											DefinitionWithLineNumbers: fmt.Sprintf("// Function %s not found", extractFunctionName(funcID)),
 											Snippet: "",
 										})
 								} else {
@@ -140,6 +172,22 @@ func (e *QueryEnricher) EnrichResults(results []CodeQLResult, callGraph *CallGra
 					}
 				}
 				
+				// Slice each function in the chain and find the conditions guarding its key lines
+				if includeResult && err == nil {
+					e.addChainContext(&finding, intermediateFuncs, parsed)
+					e.addObjectFlows(&finding)
+					e.addRefcountContext(&finding, intermediateFuncs)
+					e.addConcurrencyContext(&finding, intermediateFuncs, parsed)
+					if callGraph != nil {
+						e.addEntryReachability(&finding, callGraph)
+						e.addMetrics(&finding, callGraph, parsed)
+					}
+				}
+				if includeResult && err == nil && e.countsTokens() {
+					finding.SourceCode = PackContext(finding.SourceCode, finding.CodeQLResult, finding.CallValidation, PackOptions{Budget: e.budget})
+				}
+				parsed.close()
+				
 				// Send result
 				if includeResult {
 					resultChan <- workResult{
@@ -195,6 +243,10 @@ func (e *QueryEnricher) EnrichResults(results []CodeQLResult, callGraph *CallGra
 		}
 	}
 
+	if e.countsTokens() {
+		e.logContextTokens(enrichedResults)
+	}
+
 	// Print validation statistics
 	if validateCalls {
 		total := validationStats.total.Load()
@@ -216,8 +268,40 @@ func (e *QueryEnricher) EnrichResults(results []CodeQLResult, callGraph *CallGra
 	return enrichedResults, nil
 }
 
+// logContextTokens summarizes the size of the function code attached to the findings
+func (e *QueryEnricher) logContextTokens(findings []Finding) {
+	if len(findings) == 0 {
+		return
+	}
+	var total, largest, packed, over int
+	estimated := false
+	for _, finding := range findings {
+		tokens := finding.SourceCode.Tokens
+		if tokens == nil {
+			continue
+		}
+		total += tokens.Tokens
+		largest = max(largest, tokens.Tokens)
+		estimated = estimated || tokens.Estimated
+		if len(tokens.Packed) > 0 {
+			packed++
+		}
+		if tokens.Budget > 0 && tokens.Tokens > tokens.Budget {
+			over++
+		}
+	}
+	e.logger.Info("context token counts",
+		"component", "codeql",
+		"token_budget", e.budget,
+		"mean_tokens", total/len(findings),
+		"max_tokens", largest,
+		"findings_packed", packed,
+		"findings_over_budget", over,
+		"estimated", estimated)
+}
+
 // enrichWithSourceCode enriches a CodeQL result with source code context
-func (e *QueryEnricher) enrichWithSourceCode(result CodeQLResult) (Finding, error) {
+func (e *QueryEnricher) enrichWithSourceCode(result CodeQLResult, callGraph *CallGraph, parsed *parsedFunctions) (Finding, error) {
 	// Create function IDs for free and use functions with full paths
 	freeID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.FreeFunctionFile), result.FreeFunctionDefLine, result.FreeFunctionName)
 	useID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.UseFunctionFile), result.UseFunctionDefLine, result.UseFunctionName)
@@ -251,18 +335,184 @@ func (e *QueryEnricher) enrichWithSourceCode(result CodeQLResult) (Finding, erro
 			FreeFunction: FunctionCode{
 				DefinitionWithLineNumbers: freeFunc.DefinitionWithLineNumbers,
 				Snippet:                  freeSnippet,
+				Vars:                     freeFunc.Vars,
 			},
 			UseFunction: FunctionCode{
 				DefinitionWithLineNumbers: useFunc.DefinitionWithLineNumbers,
 				Snippet:                  useSnippet,
+				Vars:                     useFunc.Vars,
 			},
 			IntermediateFunctions: []FunctionCode{}, // Will be populated after validation
 		},
 	}
 	
+	// Point at other functions touching the freed object if it is a global
+	finding.SourceCode.Global = e.findGlobalContext(freeFunc, useFunc, result)
+	
+	// Include the definitions of the freed object's type and its containers
+	e.addTypeContext(&finding.SourceCode, freeFunc, result)
+	
+	// List other places touching the same member of the same struct type
+	e.addFieldAccessContext(&finding.SourceCode, freeFunc, result)
+	
+	// Follow the freed object back to where it was allocated
+	finding.SourceCode.Allocations = e.findAllocations(freeFunc, result, parsed)
+	
+	// Classify the free and use sites (cleanup blocks, error paths, destructors)
+	finding.Annotations = e.annotate(freeFunc, useFunc, result, parsed)
+	
+	// Flag findings the free function's own dataflow already rules out
+	if e.prefilter {
+		finding.Prefilter = e.prefilterFinding(freeFunc, useFunc, result, callGraph, parsed)
+	}
+	
 	return finding, nil
 }
 
+// findGlobalContext returns the global the freed object is rooted in, with its accesses
+// outside the free and use functions, or nil if the object is not a global
+func (e *QueryEnricher) findGlobalContext(freeFunc, useFunc *parser.Function, result CodeQLResult) *parser.Global {
+	path := parseObjectPath(freedExpression(freeFunc, result))
+	if path.Base == "" || findVariable(freeFunc, path.Base) != nil {
+		return nil
+	}
+	
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return nil
+	}
+	
+	global := analysisResult.LookupGlobal(path.Base, freeFunc.Filename)
+	if global == nil {
+		return nil
+	}
+	
+	context := *global
+	context.Accesses = nil
+	for _, access := range global.Accesses {
+		if access.FunctionID != freeFunc.ID && access.FunctionID != useFunc.ID {
+			context.Accesses = append(context.Accesses, access)
+		}
+	}
+	
+	return &context
+}
+
+// addTypeContext resolves the freed object's type by walking its field path from the
+// free-site variable, and attaches the type definitions encountered along the way
+func (e *QueryEnricher) addTypeContext(sourceCode *SourceCode, freeFunc *parser.Function, result CodeQLResult) {
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return
+	}
+	
+	path := parseObjectPath(freedExpression(freeFunc, result))
+	rootType := objectRootType(analysisResult, freeFunc, path.Base)
+	if rootType == "" {
+		return
+	}
+	
+	seen := make(map[string]bool)
+	addDefinitions := func(chain []parser.TypeDef) {
+		for _, typeDef := range chain {
+			key := fmt.Sprintf("%s:%d:%s", typeDef.Filename, typeDef.StartLine, typeDef.Name)
+			if !seen[key] {
+				seen[key] = true
+				sourceCode.TypeDefinitions = append(sourceCode.TypeDefinitions, typeDef)
+			}
+		}
+	}
+	
+	types := analysisResult.ResolveFieldPath(rootType, path.Fields)
+	for _, typeName := range types {
+		addDefinitions(analysisResult.ResolveType(typeName))
+	}
+	if len(types) != len(path.Fields)+1 {
+		e.logger.Debug("could not resolve freed object field",
+			"component", "codeql",
+			"object", path.String(),
+			"root_type", rootType)
+		return
+	}
+	typeName := types[len(types)-1]
+	sourceCode.FreedType = typeName
+	
+	// Find other members that point at the freed type, under any of its typedef names
+	freedNames := make(map[string]bool)
+	freedNames[parser.NormalizeTypeName(typeName)] = true
+	for _, typeDef := range analysisResult.ResolveType(typeName) {
+		freedNames[typeDef.Name] = true
+	}
+	for _, typeDef := range analysisResult.Types {
+		if typeDef.Kind == "typedef" && freedNames[parser.NormalizeTypeName(typeDef.Underlying)] {
+			freedNames[typeDef.Name] = true
+		}
+	}
+referrers:
+	for _, typeDef := range analysisResult.Types {
+		if typeDef.Kind != "struct" && typeDef.Kind != "union" {
+			continue
+		}
+		for _, member := range typeDef.Fields {
+			if len(sourceCode.TypeReferrers) >= maxTypeReferrers {
+				break referrers
+			}
+			if member.PointerDepth > 0 && freedNames[parser.NormalizeTypeName(member.Type)] {
+				sourceCode.TypeReferrers = append(sourceCode.TypeReferrers, TypeReferrer{
+					Type:      typeDef.Name,
+					Field:     member.Name,
+					FieldType: member.Type,
+				})
+			}
+		}
+	}
+}
+
+// maxTypeReferrers caps how many pointing members are attached to a finding
+const maxTypeReferrers = 20
+
+// addFieldAccessContext attaches every other access to the freed struct member (e.g. "->data" of
+// struct ctx), since the real dangling use often lives in one CodeQL did not report
+func (e *QueryEnricher) addFieldAccessContext(sourceCode *SourceCode, freeFunc *parser.Function, result CodeQLResult) {
+	path := parseObjectPath(freedExpression(freeFunc, result))
+	if len(path.Fields) == 0 {
+		return
+	}
+	
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return
+	}
+	
+	rootType := objectRootType(analysisResult, freeFunc, path.Base)
+	if rootType == "" {
+		return
+	}
+	containers := path.Fields[:len(path.Fields)-1]
+	types := analysisResult.ResolveFieldPath(rootType, containers)
+	if len(types) != len(containers)+1 {
+		return
+	}
+	
+	field := path.Fields[len(path.Fields)-1]
+	for _, access := range analysisResult.FieldAccessesOf(types[len(types)-1], field) {
+		if access.FunctionID == freeFunc.ID && access.Line == result.FreeLine {
+			continue
+		}
+		if len(sourceCode.FieldAccesses) >= maxFieldAccesses {
+			e.logger.Debug("truncated field accesses",
+				"component", "codeql",
+				"object", path.String(),
+				"limit", maxFieldAccesses)
+			break
+		}
+		sourceCode.FieldAccesses = append(sourceCode.FieldAccesses, access)
+	}
+}
+
+// maxFieldAccesses caps how many member accesses are attached to a finding
+const maxFieldAccesses = 50
+
 // getLineFromFile retrieves a specific line from a file
 func (e *QueryEnricher) getLineFromFile(filePath string, lineNum int) (string, error) {
 	file, err := os.Open(filePath)
@@ -288,56 +538,30 @@ func (e *QueryEnricher) getLineFromFile(filePath string, lineNum int) (string, e
 	return "", fmt.Errorf("line %d not found in file %s", lineNum, filePath)
 }
 
-// extractIntermediateFunctions finds functions that appear in call chains between free and use functions
-func (e *QueryEnricher) extractIntermediateFunctions(callChains [][]string, freeFunc, useFunc string) []string {
-	intermediateMap := make(map[string]bool)
-	
-	for _, chain := range callChains {
-		for _, funcName := range chain {
-			// Skip the free and use functions themselves
-			if funcName != freeFunc && funcName != useFunc {
-				intermediateMap[funcName] = true
-			}
-		}
-	}
-	
-	// Convert map to slice
-	var intermediates []string
-	for funcName := range intermediateMap {
-		intermediates = append(intermediates, funcName)
-	}
-	
-	return intermediates
+// extractIntermediateFunctions finds the IDs of functions that appear in call chains between free and use functions
+func (e *QueryEnricher) extractIntermediateFunctions(validation *CallValidation, result CodeQLResult) []string {
+	freeID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.FreeFunctionFile), result.FreeFunctionDefLine, result.FreeFunctionName)
+	useID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.UseFunctionFile), result.UseFunctionDefLine, result.UseFunctionName)
+	return validation.IntermediateIDs(freeID, useID)
 }
 
-// findFunctionByName searches for a function definition by name across all files
-func (e *QueryEnricher) findFunctionByName(funcName string) (FunctionCode, error) {
-	// Get cached analysis result
-	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+// functionIDs returns the call graph IDs of a finding's free and use functions
+func (e *QueryEnricher) functionIDs(result CodeQLResult) (string, string) {
+	freeID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.FreeFunctionFile), result.FreeFunctionDefLine, result.FreeFunctionName)
+	useID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.UseFunctionFile), result.UseFunctionDefLine, result.UseFunctionName)
+	return freeID, useID
+}
+
+// findFunctionCode gets the full definition of a chain function by its ID
+func (e *QueryEnricher) findFunctionCode(funcID string) (FunctionCode, error) {
+	function, err := parser.FindFunctionByID(e.sourceDir, funcID)
 	if err != nil {
-		return FunctionCode{}, fmt.Errorf("failed to get cached analysis: %w", err)
+		return FunctionCode{}, fmt.Errorf("function %s not found in codebase: %w", funcID, err)
 	}
 	
-	// Search for the function by name
-	for _, function := range analysisResult.Functions {
-		if function.Name == funcName {
-			// Found the function, now get its full definition
-			funcID := function.ID
-			fullFunc, err := parser.FindFunctionByID(e.sourceDir, funcID)
-			if err != nil {
-				// Try to return what we have
-				return FunctionCode{
-					DefinitionWithLineNumbers: function.DefinitionWithLineNumbers,
-					Snippet:                  "",
-				}, nil
-			}
-			
-			return FunctionCode{
-				DefinitionWithLineNumbers: fullFunc.DefinitionWithLineNumbers,
-				Snippet:                  "", // We don't have a specific line for intermediate functions
-			}, nil
-		}
-	}
-	
-	return FunctionCode{}, fmt.Errorf("function %s not found in codebase", funcName)
-}
\ No newline at end of file
+	return FunctionCode{
+		FunctionID:                function.ID,
+		DefinitionWithLineNumbers: function.DefinitionWithLineNumbers,
+		Snippet:                  "", // We don't have a specific line for intermediate functions
+	}, nil
+}
@@ -0,0 +1,332 @@
+package codeql
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// Entry point kinds with built-in or suggested rules; an entry point file may name others
+const (
+	EntryMain    = "main"    // Program entry
+	EntryFuzzer  = "fuzzer"  // libFuzzer harness
+	EntrySyscall = "syscall" // Linux system call handler
+	EntryAPI     = "api"     // Exported library function
+	EntryOps     = "ops"     // Callback stored into an operations table
+)
+
+// maxEntryKinds bounds the distinct entry point kinds, one bit each per function
+const maxEntryKinds = 64
+
+// builtinEntryRules are used unless an entry point file turns them off
+var builtinEntryRules = []EntryRule{
+	{Kind: EntryMain, Name: "main"},
+	{Kind: EntryFuzzer, Name: "LLVMFuzzerTestOneInput"},
+	{Kind: EntrySyscall, Name: "SYSCALL_DEFINE*"},
+	{Kind: EntrySyscall, Name: "COMPAT_SYSCALL_DEFINE*"},
+}
+
+// EntryConfig is a project's YAML entry point file, declaring where attacker input arrives, e.g.
+//
+//	entry_points:
+//	  - {kind: api, prefix: png_}                 # exported functions named png_*
+//	  - {kind: ops, field: unlocked_ioctl}        # functions stored into ->unlocked_ioctl
+//	  - {kind: ops, field: read}
+//	  - {kind: netlink, name: "*_nl_cmd_*"}
+type EntryConfig struct {
+	Builtins    *bool       `yaml:"builtins,omitempty"` // Keep main, LLVMFuzzerTestOneInput and SYSCALL_DEFINE* (default true)
+	EntryPoints []EntryRule `yaml:"entry_points"`
+}
+
+// EntryRule matches entry point functions. Every condition given must hold.
+type EntryRule struct {
+	Kind   string `yaml:"kind"`
+	Name   string `yaml:"name,omitempty"`   // Function name or glob, also matched against a defining macro such as SYSCALL_DEFINE3
+	Prefix string `yaml:"prefix,omitempty"` // Function name prefix; only non-static functions match
+	Field  string `yaml:"field,omitempty"`  // Struct member the function is stored into; kind ops without a field matches any member
+}
+
+// EntryFunction is a function matched as an entry point
+type EntryFunction struct {
+	ID   string
+	Name string
+	Kind string
+}
+
+// EntryPath is the shortest call chain from an entry point to a function
+type EntryPath struct {
+	Entry string    `json:"entry"`
+	Kind  string    `json:"kind"`  // Kind of that entry point
+	Kinds []string  `json:"kinds"` // Every entry point kind that reaches the function
+	Chain CallChain `json:"chain"`
+}
+
+// EntryReachability records whether entry points reach a finding's free and use functions
+type EntryReachability struct {
+	Reachable bool       `json:"reachable"`       // Both the free and the use function are reachable
+	Kinds     []string   `json:"kinds,omitempty"` // Entry point kinds reaching the free or the use function
+	Free      *EntryPath `json:"free,omitempty"`
+	Use       *EntryPath `json:"use,omitempty"`
+}
+
+// entryReach is the reachability of every function from the entry points
+type entryReach struct {
+	kinds  []string // Kind names, indexed by bit
+	own    []uint64 // Function index -> kinds it is an entry point of
+	mask   []uint64 // Function index -> kinds of the entry points reaching it
+	depth  []int32  // Function index -> calls from the nearest entry point, -1 when unreachable
+	parent []int32  // Function index -> caller on a shortest path from an entry point
+}
+
+// DefaultEntryConfig returns the built-in entry points only
+func DefaultEntryConfig() *EntryConfig {
+	return &EntryConfig{EntryPoints: append([]EntryRule(nil), builtinEntryRules...)}
+}
+
+// LoadEntryConfig reads a YAML entry point file and merges it with the built-in entry points
+func LoadEntryConfig(file string) (*EntryConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry point file: %w", err)
+	}
+
+	var config EntryConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse entry point file %s: %w", file, err)
+	}
+
+	kinds := make(map[string]bool)
+	for i, rule := range config.EntryPoints {
+		if rule.Kind == "" {
+			return nil, fmt.Errorf("entry point file %s: rule %d has no kind", file, i)
+		}
+		if rule.Name == "" && rule.Prefix == "" && rule.Field == "" && rule.Kind != EntryOps {
+			return nil, fmt.Errorf("entry point file %s: %s rule %d needs a name, prefix or field", file, rule.Kind, i)
+		}
+		if _, err := path.Match(rule.Name, ""); err != nil {
+			return nil, fmt.Errorf("entry point file %s: %s rule %d has a bad name pattern %q", file, rule.Kind, i, rule.Name)
+		}
+		kinds[rule.Kind] = true
+	}
+
+	if config.Builtins == nil || *config.Builtins {
+		config.EntryPoints = append(append([]EntryRule(nil), builtinEntryRules...), config.EntryPoints...)
+		for _, rule := range builtinEntryRules {
+			kinds[rule.Kind] = true
+		}
+	}
+	if len(kinds) > maxEntryKinds {
+		return nil, fmt.Errorf("entry point file %s: %d kinds, at most %d supported", file, len(kinds), maxEntryKinds)
+	}
+	return &config, nil
+}
+
+// Resolve lists the functions of a source tree matching the entry point rules
+func (c *EntryConfig) Resolve(result *parser.AnalysisResult) []EntryFunction {
+	stored := make(map[string]map[string]bool)
+	for _, pointer := range result.FunctionPointers {
+		if stored[pointer.Function] == nil {
+			stored[pointer.Function] = make(map[string]bool)
+		}
+		stored[pointer.Function][pointer.Field] = true
+	}
+
+	var entries []EntryFunction
+	for i := range result.Functions {
+		function := &result.Functions[i]
+		seen := make(map[string]bool)
+		for _, rule := range c.EntryPoints {
+			if !seen[rule.Kind] && rule.matches(function, stored[function.Name]) {
+				seen[rule.Kind] = true
+				entries = append(entries, EntryFunction{ID: function.ID, Name: function.Name, Kind: rule.Kind})
+			}
+		}
+	}
+	return entries
+}
+
+// matches reports whether a function, stored into the given struct members, is an entry point
+func (r EntryRule) matches(function *parser.Function, fields map[string]bool) bool {
+	if r.Name != "" {
+		matched, _ := path.Match(r.Name, function.Name)
+		if !matched && function.Macro != "" {
+			matched, _ = path.Match(r.Name, function.Macro)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.Prefix != "" && (!strings.HasPrefix(function.Name, r.Prefix) || isStatic(function)) {
+		return false
+	}
+	if r.Field != "" {
+		return fields[r.Field]
+	}
+	if r.Kind == EntryOps && r.Name == "" && r.Prefix == "" {
+		return len(fields) > 0
+	}
+	return true
+}
+
+// isStatic reports whether a function has internal linkage
+func isStatic(function *parser.Function) bool {
+	declaration, _, _ := strings.Cut(function.Signature, function.Name+"(")
+	for _, word := range strings.Fields(declaration) {
+		if word == "static" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetEntryPoints computes which functions the entry points reach, the shortest path from one,
+// and which kinds of entry point reach each function. It returns how many entry points are in
+// the graph; with none, reachability stays unknown rather than every function unreachable.
+func (cg *CallGraph) SetEntryPoints(entries []EntryFunction) int {
+	n := len(cg.ids)
+	reach := &entryReach{
+		own:    make([]uint64, n),
+		mask:   make([]uint64, n),
+		depth:  make([]int32, n),
+		parent: make([]int32, n),
+	}
+	for v := range reach.depth {
+		reach.depth[v], reach.parent[v] = -1, -1
+	}
+
+	kindBits := make(map[string]uint64)
+	var queue []int32
+	for _, entry := range entries {
+		v, ok := cg.index[entry.ID]
+		if !ok {
+			continue
+		}
+		bit, ok := kindBits[entry.Kind]
+		if !ok {
+			if len(reach.kinds) == maxEntryKinds {
+				continue
+			}
+			bit = 1 << uint(len(reach.kinds))
+			kindBits[entry.Kind] = bit
+			reach.kinds = append(reach.kinds, entry.Kind)
+		}
+		reach.own[v] |= bit
+		if reach.depth[v] < 0 {
+			reach.depth[v] = 0
+			queue = append(queue, v)
+		}
+	}
+	count := len(queue)
+	if count == 0 {
+		cg.entries = nil
+		return 0
+	}
+
+	// Shortest paths from the nearest entry point
+	for i := 0; i < len(queue); i++ {
+		v := queue[i]
+		for _, w := range cg.calls.neighbours(v) {
+			if reach.depth[w] < 0 {
+				reach.depth[w], reach.parent[w] = reach.depth[v]+1, v
+				queue = append(queue, w)
+			}
+		}
+	}
+
+	// Every kind reaching each function; a function is revisited only when its kinds grow
+	copy(reach.mask, reach.own)
+	work := append([]int32(nil), queue[:count]...)
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, w := range cg.calls.neighbours(v) {
+			if grown := reach.mask[w] | reach.mask[v]; grown != reach.mask[w] {
+				reach.mask[w] = grown
+				work = append(work, w)
+			}
+		}
+	}
+
+	cg.entries = reach
+	return count
+}
+
+// kindNames returns the kind names of a bitmask, in the order the kinds were first seen
+func (r *entryReach) kindNames(mask uint64) []string {
+	var names []string
+	for mask != 0 {
+		bit := bits.TrailingZeros64(mask)
+		names = append(names, r.kinds[bit])
+		mask &^= 1 << uint(bit)
+	}
+	return names
+}
+
+// EntryPath returns the shortest call chain from an entry point to a function, or nil when no
+// entry point reaches it or none are set
+func (cg *CallGraph) EntryPath(id string) *EntryPath {
+	v, ok := cg.index[id]
+	if !ok || cg.entries == nil || cg.entries.depth[v] < 0 {
+		return nil
+	}
+	reach := cg.entries
+
+	path := []int32{v}
+	for reach.parent[path[len(path)-1]] >= 0 {
+		path = append(path, reach.parent[path[len(path)-1]])
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	root := path[0]
+
+	return &EntryPath{
+		Entry: extractFunctionName(cg.ids[root]),
+		Kind:  reach.kindNames(reach.own[root])[0],
+		Kinds: reach.kindNames(reach.mask[v]),
+		Chain: cg.newCallChain(path),
+	}
+}
+
+// EntryReachability reports whether entry points reach a free and a use function, or nil when
+// no entry points are set
+func (cg *CallGraph) EntryReachability(freeID, useID string) *EntryReachability {
+	if cg.entries == nil {
+		return nil
+	}
+	reachability := &EntryReachability{Free: cg.EntryPath(freeID), Use: cg.EntryPath(useID)}
+	reachability.Reachable = reachability.Free != nil && reachability.Use != nil
+
+	var mask uint64
+	for _, id := range []string{freeID, useID} {
+		if v, ok := cg.index[id]; ok {
+			mask |= cg.entries.mask[v]
+		}
+	}
+	reachability.Kinds = cg.entries.kindNames(mask)
+	return reachability
+}
+
+// addEntryReachability records whether entry points reach the free and the use function
+func (e *QueryEnricher) addEntryReachability(finding *Finding, callGraph *CallGraph) {
+	freeID, useID := e.functionIDs(finding.CodeQLResult)
+	reachability := callGraph.EntryReachability(freeID, useID)
+	if reachability == nil {
+		return
+	}
+	for _, path := range []*EntryPath{reachability.Free, reachability.Use} {
+		if path != nil {
+			path.Chain = path.Chain.relativeTo(e.sourceDir)
+		}
+	}
+
+	if finding.Annotations == nil {
+		finding.Annotations = &Annotations{}
+	}
+	finding.Annotations.Entry = reachability
+}
@@ -0,0 +1,355 @@
+package codeql
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Roles of functions in a graph exported around a finding
+const (
+	RoleFree   = "free"
+	RoleUse    = "use"
+	RoleChain  = "chain"
+	RoleCaller = "caller" // Common caller of the free and the use
+)
+
+// ReachedFunction is a function found walking the call graph from a start function
+type ReachedFunction struct {
+	Function   string `json:"func"`
+	FunctionID string `json:"func_id"`
+	File       string `json:"file"`
+	DefLine    int    `json:"def_ln"`
+	Depth      int    `json:"depth"`          // Calls away from the nearest start function
+	Via        string `json:"via,omitempty"`  // Function it was reached through, towards the start
+	Edge       string `json:"edge,omitempty"` // Kind of that call
+}
+
+// GraphNode is a function in an exported call graph
+type GraphNode struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	File      string `json:"file"`
+	DefLine   int    `json:"def_ln"`
+	Component int    `json:"scc"`
+	Recursive bool   `json:"recursive,omitempty"`
+	Role      string `json:"role,omitempty"` // free, use, chain or caller when exported around a finding
+}
+
+// GraphEdge is a call in an exported call graph
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Kind     string `json:"kind"`
+	CallLine int    `json:"call_ln,omitempty"`
+	Chain    bool   `json:"chain,omitempty"` // Part of a finding's call chains
+}
+
+// GraphExport is the whole call graph or a subgraph, ready to write as DOT, GraphML or JSON
+type GraphExport struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// Lookup returns the IDs of the functions with a name, or the function with an ID
+func (cg *CallGraph) Lookup(function string) []string {
+	if v, ok := cg.index[function]; ok {
+		return []string{cg.ids[v]}
+	}
+	var ids []string
+	for _, v := range cg.functions[function] {
+		ids = append(ids, cg.ids[v])
+	}
+	return ids
+}
+
+// Size returns the number of functions, calls and strongly connected components
+func (cg *CallGraph) Size() (functions, calls, components int) {
+	return len(cg.ids), len(cg.calls.targets), cg.components
+}
+
+// Callees walks calls from the start functions up to maxDepth calls away (negative for no
+// limit), nearest first
+func (cg *CallGraph) Callees(start []string, maxDepth int) []ReachedFunction {
+	return cg.walk(start, maxDepth, false)
+}
+
+// Callers walks callers of the start functions up to maxDepth calls away (negative for no
+// limit), nearest first
+func (cg *CallGraph) Callers(start []string, maxDepth int) []ReachedFunction {
+	return cg.walk(start, maxDepth, true)
+}
+
+// walk is a multi-source BFS over calls or callers
+func (cg *CallGraph) walk(start []string, maxDepth int, reverse bool) []ReachedFunction {
+	adjacency := &cg.calls
+	if reverse {
+		adjacency = &cg.callers
+	}
+
+	seen := make(map[int32]bool)
+	var frontier []int32
+	for _, id := range start {
+		if v, ok := cg.index[id]; ok && !seen[v] {
+			seen[v] = true
+			frontier = append(frontier, v)
+		}
+	}
+
+	var reached []ReachedFunction
+	for depth := 1; len(frontier) > 0 && (maxDepth < 0 || depth <= maxDepth); depth++ {
+		var next []int32
+		for _, v := range frontier {
+			for _, w := range adjacency.neighbours(v) {
+				if seen[w] {
+					continue
+				}
+				seen[w] = true
+				next = append(next, w)
+
+				caller, callee := v, w
+				if reverse {
+					caller, callee = w, v
+				}
+				function := cg.reachedFunction(w, depth)
+				function.Via = extractFunctionName(cg.ids[v])
+				if e := cg.edge(caller, callee); e >= 0 {
+					function.Edge = edgeKindNames[cg.edgeKinds[e]]
+				}
+				reached = append(reached, function)
+			}
+		}
+		frontier = next
+	}
+	return reached
+}
+
+// reachedFunction describes a function found at a depth
+func (cg *CallGraph) reachedFunction(v int32, depth int) ReachedFunction {
+	return ReachedFunction{
+		Function:   extractFunctionName(cg.ids[v]),
+		FunctionID: cg.ids[v],
+		File:       cg.locations[v].file,
+		DefLine:    cg.locations[v].line,
+		Depth:      depth,
+	}
+}
+
+// Chains returns up to k shortest call chains from one function to another, by name or ID,
+// of at most maxDepth calls
+func (cg *CallGraph) Chains(from, to string, maxDepth, k int) []CallChain {
+	var chains []CallChain
+	for _, fromID := range cg.Lookup(from) {
+		for _, toID := range cg.Lookup(to) {
+			for _, path := range cg.cachedPaths(cg.index[fromID], cg.index[toID], k, maxDepth, time.Time{}) {
+				chains = append(chains, cg.newCallChain(path))
+			}
+		}
+	}
+	sort.SliceStable(chains, func(i, j int) bool { return chains[i].Length < chains[j].Length })
+	if len(chains) > k {
+		chains = chains[:k]
+	}
+	return chains
+}
+
+// Neighbourhood returns the functions, and their callers and callees up to depth calls away
+func (cg *CallGraph) Neighbourhood(ids []string, depth int) []string {
+	seen := make(map[string]bool)
+	var functions []string
+	add := func(id string) {
+		if _, ok := cg.index[id]; ok && !seen[id] {
+			seen[id] = true
+			functions = append(functions, id)
+		}
+	}
+	for _, id := range ids {
+		add(id)
+	}
+	if depth > 0 {
+		for _, reached := range cg.Callers(ids, depth) {
+			add(reached.FunctionID)
+		}
+		for _, reached := range cg.Callees(ids, depth) {
+			add(reached.FunctionID)
+		}
+	}
+	return functions
+}
+
+// Export returns the given functions and the calls between them, or the whole graph when ids
+// is nil
+func (cg *CallGraph) Export(ids []string) *GraphExport {
+	include := make([]bool, len(cg.ids))
+	if ids == nil {
+		for v := range include {
+			include[v] = true
+		}
+	}
+	for _, id := range ids {
+		if v, ok := cg.index[id]; ok {
+			include[v] = true
+		}
+	}
+
+	export := &GraphExport{}
+	for v := int32(0); v < int32(len(cg.ids)); v++ {
+		if !include[v] {
+			continue
+		}
+		export.Nodes = append(export.Nodes, GraphNode{
+			ID:        cg.ids[v],
+			Name:      extractFunctionName(cg.ids[v]),
+			File:      cg.locations[v].file,
+			DefLine:   cg.locations[v].line,
+			Component: int(cg.component[v]),
+			Recursive: cg.recursive[cg.component[v]],
+		})
+		for i := cg.calls.offsets[v]; i < cg.calls.offsets[v+1]; i++ {
+			if w := cg.calls.targets[i]; include[w] {
+				export.Edges = append(export.Edges, GraphEdge{
+					From:     cg.ids[v],
+					To:       cg.ids[w],
+					Kind:     edgeKindNames[cg.edgeKinds[i]],
+					CallLine: int(cg.callLines[i]),
+				})
+			}
+		}
+	}
+	return export
+}
+
+// MarkFinding sets the roles of a finding's functions and marks the calls of its chains
+func (g *GraphExport) MarkFinding(freeID, useID string, analysis *ReachabilityAnalysis) {
+	roles := map[string]string{}
+	chainEdges := map[[2]string]bool{}
+	if analysis != nil {
+		for _, ancestor := range analysis.Ancestors {
+			roles[ancestor.CallerID] = RoleCaller
+		}
+		for _, chain := range analysis.CallChains {
+			for i, hop := range chain.Hops {
+				if roles[hop.FunctionID] == "" {
+					roles[hop.FunctionID] = RoleChain
+				}
+				if i > 0 {
+					chainEdges[[2]string{chain.Hops[i-1].FunctionID, hop.FunctionID}] = true
+				}
+			}
+		}
+	}
+	roles[useID] = RoleUse
+	roles[freeID] = RoleFree
+
+	for i := range g.Nodes {
+		g.Nodes[i].Role = roles[g.Nodes[i].ID]
+	}
+	for i := range g.Edges {
+		g.Edges[i].Chain = chainEdges[[2]string{g.Edges[i].From, g.Edges[i].To}]
+	}
+}
+
+// dotNodeStyles are the DOT attributes of each role
+var dotNodeStyles = map[string][]string{
+	RoleFree:   {"style=filled", `fillcolor="#f4a6a6"`},
+	RoleUse:    {"style=filled", `fillcolor="#f9d49b"`},
+	RoleChain:  {"style=bold"},
+	RoleCaller: {"style=filled", `fillcolor="#b9d3f0"`},
+}
+
+// dotEdgeStyles are the DOT attributes of each edge kind
+var dotEdgeStyles = map[string][]string{
+	EdgeIndirect: {"style=dashed"},
+	EdgeCallback: {"style=dotted"},
+}
+
+// WriteDOT writes the graph in Graphviz DOT format. Indirect calls are dashed, callbacks
+// dotted, recursive functions double-bordered, and a finding's free, use and common callers
+// filled.
+func (g *GraphExport) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph callgraph {\n")
+	b.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	for _, node := range g.Nodes {
+		attrs := []string{fmt.Sprintf("label=%q", fmt.Sprintf("%s\n%s:%d", node.Name, node.File, node.DefLine))}
+		attrs = append(attrs, dotNodeStyles[node.Role]...)
+		if node.Recursive {
+			attrs = append(attrs, "peripheries=2")
+		}
+		fmt.Fprintf(&b, "  %q [%s];\n", node.ID, strings.Join(attrs, ", "))
+	}
+	for _, edge := range g.Edges {
+		var attrs []string
+		if edge.CallLine > 0 {
+			attrs = append(attrs, fmt.Sprintf(`label="L%d"`, edge.CallLine))
+		}
+		attrs = append(attrs, dotEdgeStyles[edge.Kind]...)
+		if edge.Chain {
+			attrs = append(attrs, "penwidth=2", `color="#c0392b"`)
+		}
+		fmt.Fprintf(&b, "  %q -> %q [%s];\n", edge.From, edge.To, strings.Join(attrs, ", "))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteGraphML writes the graph in GraphML, with the function's name, file, line, component
+// and role as node data and the call's kind and line as edge data
+func (g *GraphExport) WriteGraphML(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	keys := []struct{ id, scope, name, kind string }{
+		{"name", "node", "name", "string"},
+		{"file", "node", "file", "string"},
+		{"def_ln", "node", "def_ln", "int"},
+		{"scc", "node", "scc", "int"},
+		{"recursive", "node", "recursive", "boolean"},
+		{"role", "node", "role", "string"},
+		{"kind", "edge", "kind", "string"},
+		{"call_ln", "edge", "call_ln", "int"},
+		{"chain", "edge", "chain", "boolean"},
+	}
+	for _, key := range keys {
+		fmt.Fprintf(&b, "  <key id=%q for=%q attr.name=%q attr.type=%q/>\n", key.id, key.scope, key.name, key.kind)
+	}
+	b.WriteString(`  <graph id="callgraph" edgedefault="directed">` + "\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&b, "    <node id=\"%s\">\n", xmlEscape(node.ID))
+		writeGraphMLData(&b, "name", node.Name)
+		writeGraphMLData(&b, "file", node.File)
+		writeGraphMLData(&b, "def_ln", fmt.Sprint(node.DefLine))
+		writeGraphMLData(&b, "scc", fmt.Sprint(node.Component))
+		writeGraphMLData(&b, "recursive", fmt.Sprint(node.Recursive))
+		if node.Role != "" {
+			writeGraphMLData(&b, "role", node.Role)
+		}
+		b.WriteString("    </node>\n")
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "    <edge source=\"%s\" target=\"%s\">\n", xmlEscape(edge.From), xmlEscape(edge.To))
+		writeGraphMLData(&b, "kind", edge.Kind)
+		writeGraphMLData(&b, "call_ln", fmt.Sprint(edge.CallLine))
+		writeGraphMLData(&b, "chain", fmt.Sprint(edge.Chain))
+		b.WriteString("    </edge>\n")
+	}
+	b.WriteString("  </graph>\n</graphml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGraphMLData writes one data element of a GraphML node or edge
+func writeGraphMLData(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "      <data key=%q>%s</data>\n", key, xmlEscape(value))
+}
+
+// xmlEscape escapes text for an XML attribute or element
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package codeql
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	cg := graphOf("main->parse", "main->run", "run->parse", "parse->lex", "lex->next", "run->next")
+	id := func(name string) string { return cg.ids[node(cg, name)] }
+
+	type reached struct {
+		Function string
+		Depth    int
+		Via      string
+	}
+	tests := []struct {
+		name     string
+		start    []string
+		maxDepth int
+		reverse  bool
+		want     []reached
+	}{
+		{"callees", []string{id("main")}, -1, false, []reached{{"parse", 1, "main"}, {"run", 1, "main"}, {"lex", 2, "parse"}, {"next", 2, "run"}}},
+		{"callees bounded", []string{id("main")}, 1, false, []reached{{"parse", 1, "main"}, {"run", 1, "main"}}},
+		{"callers", []string{id("next")}, -1, true, []reached{{"run", 1, "next"}, {"lex", 1, "next"}, {"main", 2, "run"}, {"parse", 2, "lex"}}},
+		{"several starts", []string{id("lex"), id("run")}, 1, true, []reached{{"parse", 1, "lex"}, {"main", 1, "run"}}},
+		{"unknown start", []string{"missing"}, -1, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			walked := cg.Callees(tt.start, tt.maxDepth)
+			if tt.reverse {
+				walked = cg.Callers(tt.start, tt.maxDepth)
+			}
+			var got []reached
+			for _, function := range walked {
+				got = append(got, reached{function.Function, function.Depth, function.Via})
+				if function.Edge != EdgeDirect {
+					t.Errorf("%s edge = %q, want direct", function.Function, function.Edge)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("walk = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupAndChains(t *testing.T) {
+	cg := graphOf("main->parse", "main->run", "run->parse", "parse->lex")
+	if got := cg.Lookup("parse"); !reflect.DeepEqual(got, []string{"g.c:2:parse"}) {
+		t.Errorf("Lookup by name = %v", got)
+	}
+	if got := cg.Lookup("g.c:3:run"); !reflect.DeepEqual(got, []string{"g.c:3:run"}) {
+		t.Errorf("Lookup by ID = %v", got)
+	}
+	if got := cg.Lookup("missing"); got != nil {
+		t.Errorf("Lookup of a missing function = %v", got)
+	}
+
+	chains := cg.Chains("main", "lex", -1, 5)
+	var got [][]string
+	for _, chain := range chains {
+		got = append(got, chain.Functions)
+	}
+	want := [][]string{{"main", "parse", "lex"}, {"main", "run", "parse", "lex"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Chains = %v, want %v", got, want)
+	}
+	if chains := cg.Chains("main", "lex", -1, 1); len(chains) != 1 {
+		t.Errorf("Chains with k 1 = %d chains", len(chains))
+	}
+}
+
+func TestExport(t *testing.T) {
+	cg := graphOf("main->parse", "main->run", "run->parse", "parse->lex", "lex->parse")
+	id := func(name string) string { return cg.ids[node(cg, name)] }
+
+	neighbourhood := cg.Neighbourhood([]string{id("run")}, 1)
+	if want := []string{id("run"), id("main"), id("parse")}; !reflect.DeepEqual(neighbourhood, want) {
+		t.Fatalf("Neighbourhood = %v, want %v", neighbourhood, want)
+	}
+
+	export := cg.Export(neighbourhood)
+	var nodes []string
+	for _, n := range export.Nodes {
+		nodes = append(nodes, n.Name)
+	}
+	if want := []string{"main", "parse", "run"}; !reflect.DeepEqual(nodes, want) {
+		t.Errorf("nodes = %v, want %v", nodes, want)
+	}
+	var edges []string
+	for _, e := range export.Edges {
+		edges = append(edges, extractFunctionName(e.From)+"->"+extractFunctionName(e.To))
+	}
+	// parse->lex leaves the subgraph
+	if want := []string{"main->parse", "main->run", "run->parse"}; !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges = %v, want %v", edges, want)
+	}
+	if whole := cg.Export(nil); len(whole.Nodes) != 4 || len(whole.Edges) != 5 {
+		t.Errorf("whole graph = %d nodes and %d edges, want 4 and 5", len(whole.Nodes), len(whole.Edges))
+	}
+	for _, n := range cg.Export(nil).Nodes {
+		if recursive := n.Name == "parse" || n.Name == "lex"; n.Recursive != recursive {
+			t.Errorf("%s recursive = %v", n.Name, n.Recursive)
+		}
+	}
+
+	analysis := &ReachabilityAnalysis{CallChains: []CallChain{cg.newCallChain([]int32{node(cg, "main"), node(cg, "run"), node(cg, "parse")})}}
+	export.MarkFinding(id("main"), id("parse"), analysis)
+	roles := make(map[string]string)
+	for _, n := range export.Nodes {
+		roles[n.Name] = n.Role
+	}
+	if want := map[string]string{"main": RoleFree, "run": RoleChain, "parse": RoleUse}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("roles = %v, want %v", roles, want)
+	}
+	for _, e := range export.Edges {
+		onChain := e.From == id("main") && e.To == id("run") || e.From == id("run") && e.To == id("parse")
+		if e.Chain != onChain {
+			t.Errorf("%s->%s chain = %v, want %v", e.From, e.To, e.Chain, onChain)
+		}
+	}
+
+	var dot bytes.Buffer
+	if err := export.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"g.c:1:main" -> "g.c:3:run" [label="L2", penwidth=2`, `fillcolor="#f4a6a6"`} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("DOT lacks %s:\n%s", want, dot.String())
+		}
+	}
+
+	var graphml bytes.Buffer
+	if err := export.WriteGraphML(&graphml); err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Graph struct {
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(graphml.Bytes(), &parsed); err != nil {
+		t.Fatalf("GraphML does not parse: %v", err)
+	}
+	if len(parsed.Graph.Nodes) != 3 || len(parsed.Graph.Edges) != 3 {
+		t.Errorf("GraphML has %d nodes and %d edges, want 3 and 3", len(parsed.Graph.Nodes), len(parsed.Graph.Edges))
+	}
+}
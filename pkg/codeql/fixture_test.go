@@ -0,0 +1,125 @@
+package codeql
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// fixture is a parsed C source directory with its call graph
+type fixture struct {
+	dir       string
+	enricher  *QueryEnricher
+	graph     *CallGraph
+	functions map[string]*parser.Function
+}
+
+// loadC writes a C source to test.c in a temporary directory, parses it and builds the call
+// graph
+func loadC(t *testing.T, source string) *fixture {
+	t.Helper()
+	return loadFiles(t, map[string]string{"test.c": source})
+}
+
+// loadFiles is loadC for several C files. Of functions defined in more than one file, only one
+// is kept in functions; look the others up by ID.
+func loadFiles(t *testing.T, files map[string]string) *fixture {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := parser.GetCachedAnalysisResult(dir)
+	if err != nil {
+		t.Fatalf("GetCachedAnalysisResult: %v", err)
+	}
+	f := &fixture{
+		dir:       dir,
+		enricher:  NewQueryEnricher(dir),
+		graph:     BuildCallGraph(result.Functions, result.FunctionPointers...),
+		functions: make(map[string]*parser.Function),
+	}
+	for i := range result.Functions {
+		f.functions[result.Functions[i].Name] = &result.Functions[i]
+	}
+	return f
+}
+
+// id returns the call graph index of a function
+func (f *fixture) id(t *testing.T, name string) int32 {
+	t.Helper()
+	function, ok := f.functions[name]
+	if !ok {
+		t.Fatalf("function %s not found", name)
+	}
+	return f.graph.index[function.ID]
+}
+
+// result builds a finding in test.c between two functions
+func (f *fixture) result(t *testing.T, object, freeName string, freeLine int, useName string, useLine int) CodeQLResult {
+	t.Helper()
+	free, use := f.functions[freeName], f.functions[useName]
+	if free == nil || use == nil {
+		t.Fatalf("function %s or %s not found", freeName, useName)
+	}
+	return CodeQLResult{
+		ObjName:             object,
+		FreeFunctionName:    freeName,
+		FreeFunctionFile:    "test.c",
+		FreeFunctionDefLine: free.StartLine,
+		FreeLine:            freeLine,
+		UseFunctionName:     useName,
+		UseFunctionFile:     "test.c",
+		UseFunctionDefLine:  use.StartLine,
+		UseLine:             useLine,
+	}
+}
+
+// graphOf builds a call graph from calls written as "caller->callee", without parsing any C.
+// Functions are numbered in order of first appearance.
+func graphOf(calls ...string) *CallGraph {
+	var functions []parser.Function
+	byName := make(map[string]int)
+	add := func(name string) int {
+		if i, ok := byName[name]; ok {
+			return i
+		}
+		byName[name] = len(functions)
+		functions = append(functions, parser.Function{
+			ID:        fmt.Sprintf("g.c:%d:%s", len(functions)+1, name),
+			Filename:  "g.c",
+			Name:      name,
+			StartLine: len(functions) + 1,
+		})
+		return byName[name]
+	}
+	for _, call := range calls {
+		caller, callee, _ := strings.Cut(call, "->")
+		from := add(caller)
+		if callee != "" {
+			add(callee)
+			functions[from].Callees = append(functions[from].Callees, parser.Callee{Name: callee, Line: len(functions[from].Callees) + 1})
+		}
+	}
+	return BuildCallGraph(functions)
+}
+
+// node returns the index of a function in a graph built by graphOf
+func node(cg *CallGraph, name string) int32 {
+	return cg.functions[name][0]
+}
+
+// names converts a path of function indices to names
+func names(cg *CallGraph, path []int32) []string {
+	names := make([]string, len(path))
+	for i, v := range path {
+		names[i] = extractFunctionName(cg.ids[v])
+	}
+	return names
+}
@@ -0,0 +1,220 @@
+package codeql
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// maxBetweennessSources bounds the shortest-path searches betweenness is estimated from. Smaller
+// graphs are computed exactly; larger ones from evenly spaced sources, scaled up.
+const maxBetweennessSources = 256
+
+// betweennessWorkers is fixed rather than the CPU count so sums are grouped, and rounded, the
+// same way on every machine
+const betweennessWorkers = 8
+
+// FunctionMetrics describes where a function sits in the call graph and how complex it is
+type FunctionMetrics struct {
+	Function       string  `json:"func"`
+	FanIn          int     `json:"fan_in"`               // Distinct callers
+	FanOut         int     `json:"fan_out"`              // Distinct callees defined in the source
+	Betweenness    float64 `json:"betweenness"`          // Share of shortest call paths through the function
+	BetweennessPct float64 `json:"betweenness_pct"`      // Share of functions with lower betweenness
+	EntryDistance  *int    `json:"entry_dist,omitempty"` // Calls from the nearest entry point, -1 when none reaches it; absent without entry points
+	Recursive      bool    `json:"recursive,omitempty"`
+	SCCSize        int     `json:"scc_size"`             // Functions in its strongly connected component
+	Complexity     int     `json:"complexity,omitempty"` // Cyclomatic complexity
+	Lines          int     `json:"lines"`
+}
+
+// FindingMetrics holds the metrics of a finding's free and use functions
+type FindingMetrics struct {
+	Free *FunctionMetrics `json:"free,omitempty"`
+	Use  *FunctionMetrics `json:"use,omitempty"`
+}
+
+// graphMetrics are the whole-graph metrics, computed on first use
+type graphMetrics struct {
+	betweenness    []float64 // Function index -> normalized betweenness
+	betweennessPct []float64 // Function index -> share of functions with lower betweenness
+	componentSize  []int32   // Component -> number of functions
+}
+
+// graphMetrics computes betweenness and component sizes once
+func (cg *CallGraph) graphMetrics() *graphMetrics {
+	cg.metricsOnce.Do(func() {
+		metrics := &graphMetrics{componentSize: make([]int32, cg.components)}
+		for _, c := range cg.component {
+			metrics.componentSize[c]++
+		}
+		metrics.betweenness = cg.betweenness()
+
+		n := len(metrics.betweenness)
+		sorted := append([]float64(nil), metrics.betweenness...)
+		sort.Float64s(sorted)
+		metrics.betweennessPct = make([]float64, n)
+		if n > 1 {
+			for v, score := range metrics.betweenness {
+				metrics.betweennessPct[v] = float64(sort.SearchFloat64s(sorted, score)) / float64(n-1)
+			}
+		}
+		cg.metrics = metrics
+	})
+	return cg.metrics
+}
+
+// betweenness estimates each function's betweenness centrality with Brandes' algorithm,
+// normalized by the (n-1)(n-2) ordered pairs of other functions. Sources are split across
+// workers in a fixed way and their sums added in worker order, so results are reproducible.
+func (cg *CallGraph) betweenness() []float64 {
+	n := len(cg.ids)
+	scores := make([]float64, n)
+	if n < 3 {
+		return scores
+	}
+
+	sources := n
+	if sources > maxBetweennessSources {
+		sources = maxBetweennessSources
+	}
+	workers := betweennessWorkers
+	if workers > sources {
+		workers = sources
+	}
+	partial := make([][]float64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			partial[w] = cg.dependencies(n, sources, w, workers)
+		}(w)
+	}
+	wg.Wait()
+
+	scale := float64(n) / float64(sources) / (float64(n-1) * float64(n-2))
+	for _, sums := range partial {
+		for v := range scores {
+			scores[v] += sums[v]
+		}
+	}
+	for v := range scores {
+		scores[v] *= scale
+	}
+	return scores
+}
+
+// dependencies sums the dependencies of every function over every workers-th of the sampled
+// sources, starting at the first-th
+func (cg *CallGraph) dependencies(n, sources, first, workers int) []float64 {
+	scores := make([]float64, n)
+	sigma := make([]float64, n) // Shortest paths from the source
+	delta := make([]float64, n) // Dependency of the source on each function
+	dist := make([]int32, n)
+	for v := range dist {
+		dist[v] = -1
+	}
+	order := make([]int32, 0, n)
+
+	for i := first; i < sources; i += workers {
+		source := int32(i * n / sources)
+		for _, v := range order {
+			sigma[v], delta[v], dist[v] = 0, 0, -1
+		}
+		order = append(order[:0], source)
+		sigma[source], dist[source] = 1, 0
+
+		for next := 0; next < len(order); next++ {
+			v := order[next]
+			for _, w := range cg.calls.neighbours(v) {
+				if dist[w] < 0 {
+					dist[w] = dist[v] + 1
+					order = append(order, w)
+				}
+				if dist[w] == dist[v]+1 {
+					sigma[w] += sigma[v]
+				}
+			}
+		}
+
+		for j := len(order) - 1; j > 0; j-- {
+			w := order[j]
+			for _, v := range cg.callers.neighbours(w) {
+				if dist[v] >= 0 && dist[v] == dist[w]-1 {
+					delta[v] += sigma[v] / sigma[w] * (1 + delta[w])
+				}
+			}
+			scores[w] += delta[w]
+		}
+	}
+	return scores
+}
+
+// Metrics returns the call graph and complexity metrics of a parsed function. Functions missing
+// from the graph only get their complexity and length.
+func (cg *CallGraph) Metrics(function *parser.Function) *FunctionMetrics {
+	cfg, _ := parser.BuildCFG(function)
+	return cg.functionMetrics(function, cfg)
+}
+
+// functionMetrics is Metrics given the function's CFG, or nil when it could not be built
+func (cg *CallGraph) functionMetrics(function *parser.Function, cfg *parser.CFG) *FunctionMetrics {
+	metrics := &FunctionMetrics{
+		Function: function.Name,
+		Lines:    function.EndLine - function.StartLine + 1,
+	}
+	if cfg != nil {
+		metrics.Complexity = cfg.CyclomaticComplexity()
+	}
+
+	v, ok := cg.index[function.ID]
+	if !ok {
+		return metrics
+	}
+	graph := cg.graphMetrics()
+	metrics.FanIn = len(cg.callers.neighbours(v))
+	metrics.FanOut = len(cg.calls.neighbours(v))
+	metrics.Betweenness = graph.betweenness[v]
+	metrics.BetweennessPct = graph.betweennessPct[v]
+	metrics.Recursive = cg.recursive[cg.component[v]]
+	metrics.SCCSize = int(graph.componentSize[cg.component[v]])
+	if cg.entries != nil {
+		distance := int(cg.entries.depth[v])
+		metrics.EntryDistance = &distance
+	}
+	return metrics
+}
+
+// addMetrics records the metrics of the free and the use function
+func (e *QueryEnricher) addMetrics(finding *Finding, callGraph *CallGraph, parsed *parsedFunctions) {
+	freeID, useID := e.functionIDs(finding.CodeQLResult)
+	freeFunc, err := parser.FindFunctionByID(e.sourceDir, freeID)
+	if err != nil {
+		return
+	}
+	useFunc, err := parser.FindFunctionByID(e.sourceDir, useID)
+	if err != nil {
+		return
+	}
+
+	functionMetrics := func(function *parser.Function) *FunctionMetrics {
+		var cfg *parser.CFG
+		if function, err := parsed.get(function); err == nil {
+			cfg = function.CFG()
+		}
+		return callGraph.functionMetrics(function, cfg)
+	}
+	metrics := &FindingMetrics{Free: functionMetrics(freeFunc)}
+	if useFunc.ID == freeFunc.ID {
+		metrics.Use = metrics.Free
+	} else {
+		metrics.Use = functionMetrics(useFunc)
+	}
+
+	if finding.Annotations == nil {
+		finding.Annotations = &Annotations{}
+	}
+	finding.Annotations.Metrics = metrics
+}
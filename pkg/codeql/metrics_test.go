@@ -0,0 +1,142 @@
+package codeql
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+func TestBetweenness(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []string
+		want  map[string]float64
+	}{
+		// b lies on the only a->c path, one of the (3-1)(3-2) ordered pairs
+		{"chain", []string{"a->b", "b->c"}, map[string]float64{"a": 0, "b": 0.5, "c": 0}},
+		// a and b each carry half of the s->t paths
+		{"diamond", []string{"s->a", "s->b", "a->t", "b->t"}, map[string]float64{"s": 0, "a": 1.0 / 12, "b": 1.0 / 12, "t": 0}},
+		{"cycle", []string{"a->b", "b->c", "c->a"}, map[string]float64{"a": 0.5, "b": 0.5, "c": 0.5}},
+		{"too small", []string{"a->b"}, map[string]float64{"a": 0, "b": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := graphOf(tt.calls...)
+			scores := cg.betweenness()
+			for name, want := range tt.want {
+				if got := scores[node(cg, name)]; math.Abs(got-want) > 1e-12 {
+					t.Errorf("betweenness(%s) = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBetweennessSampled(t *testing.T) {
+	// A chain longer than maxBetweennessSources: function k lies on k*(n-1-k) ordered pairs
+	n := 2 * maxBetweennessSources
+	calls := make([]string, 0, n-1)
+	for i := 0; i+1 < n; i++ {
+		calls = append(calls, fmt.Sprintf("f%d->f%d", i, i+1))
+	}
+	cg := graphOf(calls...)
+	scores := cg.betweenness()
+	for _, k := range []int{n / 4, n / 2, 3 * n / 4} {
+		exact := float64(k*(n-1-k)) / float64((n-1)*(n-2))
+		if got := scores[node(cg, fmt.Sprintf("f%d", k))]; math.Abs(got-exact)/exact > 0.02 {
+			t.Errorf("betweenness(f%d) = %v, want about %v", k, got, exact)
+		}
+	}
+	if again := cg.betweenness(); !reflect.DeepEqual(scores, again) {
+		t.Error("sampled betweenness differs between runs")
+	}
+}
+
+func TestFunctionMetrics(t *testing.T) {
+	f := loadC(t, `void kfree(void *p);
+void release(char *p)
+{
+	if (p)
+		kfree(p);
+}
+void a(char *p) { release(p); }
+void b(char *p) { release(p); b(p); }
+`)
+	tests := []struct {
+		function   string
+		fanIn      int
+		fanOut     int
+		recursive  bool
+		complexity int
+		lines      int
+	}{
+		{"release", 2, 0, false, 2, 5},
+		{"a", 0, 1, false, 1, 1},
+		{"b", 1, 2, true, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.function, func(t *testing.T) {
+			m := f.graph.Metrics(f.functions[tt.function])
+			if m.FanIn != tt.fanIn || m.FanOut != tt.fanOut || m.Recursive != tt.recursive || m.Complexity != tt.complexity || m.Lines != tt.lines {
+				t.Errorf("metrics = %+v, want fan-in %d, fan-out %d, recursive %v, complexity %d, %d lines", m, tt.fanIn, tt.fanOut, tt.recursive, tt.complexity, tt.lines)
+			}
+			if m.SCCSize != 1 || m.EntryDistance != nil {
+				t.Errorf("metrics = %+v, want a single-function component and no entry distance", m)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	distance := func(d int) *int { return &d }
+	total := 0.0
+	for _, weight := range scoreWeights {
+		total += weight
+	}
+	tests := []struct {
+		name        string
+		annotations *Annotations
+		prefilter   *Prefilter
+		want        float64
+	}{
+		{"nothing known", nil, nil, (3*unknownFeature + 3*unknownFeature + unknownFeature) / total},
+		{"likely false positive", nil, &Prefilter{LikelyFP: true}, likelyFPFactor * (3*unknownFeature + 3*unknownFeature + unknownFeature) / total},
+		{
+			name: "reachable double free on an error path",
+			annotations: &Annotations{
+				Free:         &parser.SiteContext{ErrorPath: true},
+				UsePrimitive: &parser.UsePrimitive{Class: parser.UseDoubleFree},
+				Entry:        &EntryReachability{Reachable: true},
+				Metrics: &FindingMetrics{
+					Free: &FunctionMetrics{FanIn: 63, Complexity: 1, EntryDistance: distance(1)},
+					Use:  &FunctionMetrics{Complexity: 1, EntryDistance: distance(0), BetweennessPct: 1, Recursive: true},
+				},
+			},
+			// Everything but complexity: 3 + 3 + 1/2 + 2 + 1 + 1 + 0.5
+			want: 11 / total,
+		},
+		{
+			name: "unreachable read",
+			annotations: &Annotations{
+				UsePrimitive: &parser.UsePrimitive{Class: parser.UseRead},
+				Entry:        &EntryReachability{},
+				Metrics: &FindingMetrics{
+					Free: &FunctionMetrics{Complexity: 32, EntryDistance: distance(-1)},
+					Use:  &FunctionMetrics{Complexity: 1, EntryDistance: distance(-1)},
+				},
+			},
+			want: (3*useSeverity[parser.UseRead] + 1) / total,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, features := Score(tt.annotations, tt.prefilter)
+			if math.Abs(score-tt.want) > 1e-12 {
+				t.Errorf("Score = %v, want %v (features %v)", score, tt.want, features)
+			}
+		})
+	}
+}
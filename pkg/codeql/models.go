@@ -0,0 +1,226 @@
+package codeql
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// Model function kinds accepted in a YAML model file
+const (
+	ModelDeallocator = "deallocator"
+	ModelAllocator   = "allocator"
+	ModelBarrier     = "barrier"
+)
+
+// ModelConfig is a project's YAML model file, e.g.
+//
+//	name_heuristic: false
+//	functions:
+//	  - {name: obj_put, kind: deallocator, arg: 0}
+//	  - {name: obj_new, kind: allocator}
+//	  - {name: obj_reinit, kind: barrier, arg: 0}
+type ModelConfig struct {
+	Builtins      *bool        `yaml:"builtins,omitempty"`       // Keep the built-in allocators and deallocators (default true)
+	NameHeuristic *bool        `yaml:"name_heuristic,omitempty"` // Also treat any "%free%" function as a deallocator (default true)
+	Functions     []ModelEntry `yaml:"functions"`
+}
+
+// ModelEntry declares one allocator, deallocator or barrier function
+type ModelEntry struct {
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+	Arg  *int   `yaml:"arg,omitempty"` // Freed or reset argument; defaults to 0, ignored for allocators
+	Via  string `yaml:"via,omitempty"` // Informational: the function this one wraps
+}
+
+// LoadModelFile reads a YAML model file and merges it with the built-in model
+func LoadModelFile(path string) (*parser.MemoryModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model file: %w", err)
+	}
+
+	var config ModelConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse model file %s: %w", path, err)
+	}
+
+	model := &parser.MemoryModel{NameHeuristic: true}
+	if config.Builtins == nil || *config.Builtins {
+		model = parser.BuiltinMemoryModel()
+	}
+	if config.NameHeuristic != nil {
+		model.NameHeuristic = *config.NameHeuristic
+	}
+
+	for i, entry := range config.Functions {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("model file %s: function %d has no name", path, i)
+		}
+		function := parser.ModelFunction{Name: entry.Name, Via: entry.Via}
+		if entry.Arg != nil {
+			function.Arg = *entry.Arg
+		}
+		if function.Arg < 0 {
+			return nil, fmt.Errorf("model file %s: %s has negative arg %d", path, entry.Name, function.Arg)
+		}
+
+		switch entry.Kind {
+		case ModelDeallocator:
+			model.Deallocators = replaceModelFunction(model.Deallocators, function)
+		case ModelAllocator:
+			function.Arg = -1
+			model.Allocators = replaceModelFunction(model.Allocators, function)
+		case ModelBarrier:
+			model.Barriers = replaceModelFunction(model.Barriers, function)
+		default:
+			return nil, fmt.Errorf("model file %s: %s has unknown kind %q (want %s, %s or %s)",
+				path, entry.Name, entry.Kind, ModelDeallocator, ModelAllocator, ModelBarrier)
+		}
+	}
+
+	model.Sort()
+	return model, nil
+}
+
+// replaceModelFunction adds a function to a model list, overriding any entry with the same name
+func replaceModelFunction(functions []parser.ModelFunction, function parser.ModelFunction) []parser.ModelFunction {
+	for i := range functions {
+		if functions[i].Name == function.Name {
+			functions[i] = function
+			return functions
+		}
+	}
+	return append(functions, function)
+}
+
+// NewModelConfig converts a memory model to the YAML model file format, listing every function
+// explicitly so the file does not depend on the built-ins
+func NewModelConfig(model *parser.MemoryModel) ModelConfig {
+	builtins := false
+	config := ModelConfig{Builtins: &builtins, NameHeuristic: &model.NameHeuristic}
+	add := func(kind string, functions []parser.ModelFunction) {
+		for _, function := range functions {
+			entry := ModelEntry{Name: function.Name, Kind: kind, Via: function.Via}
+			if kind != ModelAllocator {
+				arg := function.Arg
+				entry.Arg = &arg
+			}
+			config.Functions = append(config.Functions, entry)
+		}
+	}
+	add(ModelDeallocator, model.Deallocators)
+	add(ModelAllocator, model.Allocators)
+	add(ModelBarrier, model.Barriers)
+	return config
+}
+
+// WriteModelQLL renders a memory model as a QL library that spec/uaf/query.ql imports as "models"
+func WriteModelQLL(w io.Writer, model *parser.MemoryModel) error {
+	var b strings.Builder
+
+	b.WriteString("/**\n * Memory model generated by slice; do not edit by hand.\n */\n\n")
+
+	b.WriteString("/** Holds when `name` frees its argument at index `arg` */\n")
+	b.WriteString("predicate modelDeallocator(string name, int arg) {\n")
+	writeModelRows(&b, model.Deallocators, func(f parser.ModelFunction) string {
+		return fmt.Sprintf("name = %q and arg = %d", f.Name, f.Arg)
+	})
+	b.WriteString("}\n\n")
+
+	b.WriteString("/** Holds when `name` returns freshly allocated memory */\n")
+	b.WriteString("predicate modelAllocator(string name) {\n")
+	writeModelRows(&b, model.Allocators, func(f parser.ModelFunction) string {
+		return fmt.Sprintf("name = %q", f.Name)
+	})
+	b.WriteString("}\n\n")
+
+	b.WriteString("/** Holds when `name` resets its argument at index `arg`, so it no longer refers to freed memory */\n")
+	b.WriteString("predicate modelBarrier(string name, int arg) {\n")
+	writeModelRows(&b, model.Barriers, func(f parser.ModelFunction) string {
+		return fmt.Sprintf("name = %q and arg = %d", f.Name, f.Arg)
+	})
+	b.WriteString("}\n\n")
+
+	heuristic := "none()"
+	if model.NameHeuristic {
+		heuristic = "any()"
+	}
+	b.WriteString("/** Holds when functions named like \"%free%\" also count as deallocators */\n")
+	fmt.Fprintf(&b, "predicate nameHeuristicDeallocators() { %s }\n", heuristic)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeModelRows writes one disjunct per model function, annotated with the function it wraps
+func writeModelRows(b *strings.Builder, functions []parser.ModelFunction, row func(parser.ModelFunction) string) {
+	if len(functions) == 0 {
+		b.WriteString("  none()\n")
+		return
+	}
+	for i, f := range functions {
+		b.WriteString("  ")
+		if i > 0 {
+			b.WriteString("or ")
+		}
+		b.WriteString(row(f))
+		if f.Via != "" {
+			fmt.Fprintf(b, " // wraps %s", f.Via)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// modelQueryPrefix names the temporary directories PrepareModelQuery creates next to a query
+const modelQueryPrefix = ".slice-model-"
+
+// PrepareModelQuery copies a query and its sibling .qll libraries into a temporary directory
+// next to it, replacing models.qll with the given model. Staying inside the query's pack keeps
+// its dependencies resolvable. The caller runs the returned query and then calls cleanup.
+func PrepareModelQuery(query string, model *parser.MemoryModel) (string, func(), error) {
+	dir, err := os.MkdirTemp(filepath.Dir(query), modelQueryPrefix)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create model query directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	libraries, err := filepath.Glob(filepath.Join(filepath.Dir(query), "*.qll"))
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	for _, source := range append(libraries, query) {
+		if filepath.Base(source) == "models.qll" {
+			continue
+		}
+		data, err := os.ReadFile(source)
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("failed to copy %s: %w", source, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(source)), data, 0o644); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("failed to copy %s: %w", source, err)
+		}
+	}
+
+	file, err := os.Create(filepath.Join(dir, "models.qll"))
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write models.qll: %w", err)
+	}
+	defer file.Close()
+	if err := WriteModelQLL(file, model); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write models.qll: %w", err)
+	}
+
+	return filepath.Join(dir, filepath.Base(query)), cleanup, nil
+}
@@ -0,0 +1,142 @@
+package codeql
+
+import (
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// objectPath is a freed object expression broken into its base variable and field accesses,
+// e.g. "ctx->data->buf" has base "ctx" and fields ["data", "buf"]
+type objectPath struct {
+	Base   string
+	Fields []string
+}
+
+// parseObjectPath splits an object expression such as "ctx->data", "(*p).x" or "arr[i]" into a base and field path
+func parseObjectPath(expr string) objectPath {
+	expr = strings.TrimSpace(expr)
+
+	// Drop casts, dereferences, address-of and wrapping parentheses
+	for {
+		trimmed := strings.TrimLeft(expr, "*&( \t")
+		if strings.HasPrefix(expr, "(") {
+			if end := strings.Index(expr, ")"); end > 0 && isTypeName(expr[1:end]) {
+				trimmed = strings.TrimSpace(expr[end+1:])
+			}
+		}
+		if trimmed == expr {
+			break
+		}
+		expr = trimmed
+	}
+
+	var path objectPath
+	var current strings.Builder
+	flush := func() {
+		name := strings.Trim(current.String(), "() \t")
+		current.Reset()
+		if name == "" {
+			return
+		}
+		if path.Base == "" {
+			path.Base = name
+		} else {
+			path.Fields = append(path.Fields, name)
+		}
+	}
+
+	depth := 0
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth > 0:
+		case c == '-' && i+1 < len(expr) && expr[i+1] == '>':
+			flush()
+			i++
+		case c == '.':
+			flush()
+		case c == '_' || c == '(' || c == ')' || c == ' ' || isAlnum(c):
+			current.WriteByte(c)
+		default:
+			// Anything else (arithmetic, calls) ends the object expression
+			flush()
+			return path
+		}
+	}
+	flush()
+
+	return path
+}
+
+// String renders the path back as a C expression using "->" for every field access
+func (p objectPath) String() string {
+	if len(p.Fields) == 0 {
+		return p.Base
+	}
+	return p.Base + "->" + strings.Join(p.Fields, "->")
+}
+
+// isTypeName reports whether a parenthesized prefix looks like a cast, e.g. "(struct foo *)"
+func isTypeName(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasSuffix(s, "*") || strings.HasPrefix(s, "struct ") || strings.HasPrefix(s, "union ") ||
+		strings.HasPrefix(s, "const ") || strings.HasPrefix(s, "unsigned ")
+}
+
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// freedExpression returns the argument of the deallocator call on the free line,
+// falling back to the object name reported by CodeQL
+func freedExpression(function *parser.Function, result CodeQLResult) string {
+	if function == nil {
+		return result.ObjName
+	}
+	for _, callee := range function.Callees {
+		if callee.Line != result.FreeLine || !parser.IsDeallocator(callee.Name) {
+			continue
+		}
+		for _, arg := range callee.Args {
+			if strings.Contains(arg, result.ObjName) {
+				return arg
+			}
+		}
+		if len(callee.Args) > 0 {
+			return callee.Args[0]
+		}
+	}
+	return result.ObjName
+}
+
+// findVariable looks up a param or local of a function by name
+func findVariable(function *parser.Function, name string) *parser.Variable {
+	if function == nil {
+		return nil
+	}
+	for i := range function.Vars {
+		if function.Vars[i].Name == name {
+			return &function.Vars[i]
+		}
+	}
+	return nil
+}
+
+// objectRootType returns the declared type of an object's base variable, looking at the
+// function's params and locals before globals
+func objectRootType(analysisResult *parser.AnalysisResult, function *parser.Function, base string) string {
+	if v := findVariable(function, base); v != nil {
+		return v.Type
+	}
+	if function != nil {
+		if g := analysisResult.LookupGlobal(base, function.Filename); g != nil {
+			return g.Type
+		}
+	}
+	return ""
+}
@@ -0,0 +1,231 @@
+package codeql
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// ObjectHop says how the freed object crosses one call of a chain
+type ObjectHop struct {
+	Caller       string `json:"caller"`
+	Callee       string `json:"callee"`
+	Line         int    `json:"line"`             // Call site line in the caller
+	Call         string `json:"call"`             // Call site snippet
+	Argument     string `json:"arg,omitempty"`    // Argument carrying the object, e.g. "req"; empty for globals
+	Param        string `json:"param,omitempty"`  // Callee parameter receiving it, e.g. "r"
+	CallerObject string `json:"caller_obj"`       // The object as named in the caller, e.g. "req->buf"
+	CalleeObject string `json:"callee_obj"`       // The object as named in the callee, e.g. "r->buf"
+	Global       bool   `json:"global,omitempty"` // The object is reached through a global on both sides
+}
+
+// ObjectFlow is the freed object's path through one call chain
+type ObjectFlow struct {
+	Chain int         `json:"chain"` // Index into CallChains
+	Hops  []ObjectHop `json:"hops"`
+}
+
+// addObjectFlows maps arguments to parameters along every chain of a finding, starting from
+// the freed expression in the free function and moving out to callers and callees
+func (e *QueryEnricher) addObjectFlows(finding *Finding) {
+	validation := finding.CallValidation
+	if validation == nil || len(validation.CallChains) == 0 {
+		return
+	}
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return
+	}
+	result := finding.CodeQLResult
+	freeID := fmt.Sprintf("%s:%d:%s", filepath.Join(e.sourceDir, result.FreeFunctionFile), result.FreeFunctionDefLine, result.FreeFunctionName)
+	freeFunc, err := parser.FindFunctionByID(e.sourceDir, freeID)
+	if err != nil {
+		return
+	}
+	freed := parser.NormalizeTarget(freedExpression(freeFunc, result))
+
+	for index, chain := range validation.CallChains {
+		position := -1
+		for i, hop := range chain.Hops {
+			if hop.FunctionID == freeFunc.ID {
+				position = i
+				break
+			}
+		}
+		if position < 0 || len(chain.Hops) < 2 {
+			continue
+		}
+		functions := make([]*parser.Function, len(chain.Hops))
+		for i, hop := range chain.Hops {
+			functions[i] = analysisResult.FunctionByID(hop.FunctionID)
+		}
+
+		var hops []ObjectHop
+
+		// Towards callers: the object in each callee is a parameter (or global) of the caller's call
+		object := freed
+		for k := position; k > 0 && object != ""; k-- {
+			caller, callee := functions[k-1], functions[k]
+			if caller == nil || callee == nil {
+				break
+			}
+			hop, ok := e.mapToCaller(analysisResult, caller, callee, chain.Hops[k-1], object)
+			if !ok {
+				break
+			}
+			hops = append([]ObjectHop{hop}, hops...)
+			object = hop.CallerObject
+		}
+
+		// Towards callees: find the argument the object, or its container, is passed in
+		object = freed
+		for k := position; k < len(chain.Hops)-1 && object != ""; k++ {
+			caller, callee := functions[k], functions[k+1]
+			if caller == nil || callee == nil {
+				break
+			}
+			hop, ok := e.mapToCallee(analysisResult, caller, callee, chain.Hops[k], object)
+			if !ok {
+				break
+			}
+			hops = append(hops, hop)
+			object = hop.CalleeObject
+		}
+
+		if len(hops) > 0 {
+			validation.ObjectFlows = append(validation.ObjectFlows, ObjectFlow{Chain: index, Hops: hops})
+		}
+	}
+}
+
+// chainCall returns the call a chain hop makes to the next function: the call on the hop's call
+// line naming it, or for an indirect call the call through a struct member on that line. Calls
+// that only pass the next function as a callback do not hand it their arguments.
+func chainCall(caller *parser.Function, hop ChainHop) (parser.Callee, bool) {
+	if hop.Edge == EdgeCallback {
+		return parser.Callee{}, false
+	}
+	var indirect *parser.Callee
+	for i := range caller.Callees {
+		call := &caller.Callees[i]
+		if call.Line != hop.CallLine {
+			continue
+		}
+		if call.Name == hop.Calls {
+			return *call, true
+		}
+		if hop.Edge == EdgeIndirect && indirect == nil && parser.CalledField(call.Name) != "" {
+			indirect = call
+		}
+	}
+	if indirect != nil {
+		return *indirect, true
+	}
+	return parser.Callee{}, false
+}
+
+// newObjectHop describes the call of a chain hop, or the hop's line alone when the call could
+// not be found
+func newObjectHop(caller, callee *parser.Function, hop ChainHop, call parser.Callee, found bool) ObjectHop {
+	objectHop := ObjectHop{
+		Caller: caller.Name,
+		Callee: callee.Name,
+		Line:   hop.CallLine,
+		Call:   hop.CallSnippet,
+	}
+	if found {
+		objectHop.Call = call.Snippet
+	}
+	return objectHop
+}
+
+// mapToCaller names an object of a callee in terms of the arguments of the chain's call to it
+func (e *QueryEnricher) mapToCaller(analysisResult *parser.AnalysisResult, caller, callee *parser.Function, chainHop ChainHop, object string) (ObjectHop, bool) {
+	path := parseObjectPath(object)
+	global := findVariable(callee, path.Base) == nil && analysisResult.LookupGlobal(path.Base, callee.Filename) != nil
+	index := parameterIndex(callee, path.Base)
+	if index < 0 && !global {
+		return ObjectHop{}, false
+	}
+
+	call, found := chainCall(caller, chainHop)
+	hop := newObjectHop(caller, callee, chainHop, call, found)
+	hop.CalleeObject = object
+	if global {
+		hop.Global = true
+		hop.CallerObject = object
+		return hop, true
+	}
+	if !found || index >= len(call.Args) {
+		return ObjectHop{}, false
+	}
+	hop.Argument = strings.TrimSpace(call.Args[index])
+	hop.Param = path.Base
+	hop.CallerObject = substituteRoot(object, path.Base, hop.Argument)
+	return hop, true
+}
+
+// mapToCallee names an object of a caller in terms of the parameters the chain's call passes
+// it in
+func (e *QueryEnricher) mapToCallee(analysisResult *parser.AnalysisResult, caller, callee *parser.Function, chainHop ChainHop, object string) (ObjectHop, bool) {
+	path := parseObjectPath(object)
+	global := findVariable(caller, path.Base) == nil && analysisResult.LookupGlobal(path.Base, caller.Filename) != nil
+
+	call, found := chainCall(caller, chainHop)
+	hop := newObjectHop(caller, callee, chainHop, call, found)
+	hop.CallerObject = object
+	if found {
+		for i, arg := range call.Args {
+			if i >= len(callee.Params) {
+				break
+			}
+			argument := strings.TrimSpace(arg)
+			passed := strings.TrimLeft(parser.NormalizeTarget(parser.StripCasts(argument)), "&")
+			if !isObjectExpression(passed) || !parser.TargetCovers(passed, object) {
+				continue
+			}
+			param := parser.ParameterName(callee.Params[i].Snippet)
+			hop.Argument = argument
+			hop.Param = param
+			hop.CalleeObject = substituteRoot(object, passed, param)
+			if strings.HasPrefix(parser.NormalizeTarget(argument), "&") && strings.HasPrefix(hop.CalleeObject, param+".") {
+				// The callee gets a pointer to the container
+				hop.CalleeObject = param + "->" + strings.TrimPrefix(hop.CalleeObject, param+".")
+			}
+			return hop, true
+		}
+	}
+	if global {
+		hop.Global = true
+		hop.CalleeObject = object
+		return hop, true
+	}
+	return ObjectHop{}, false
+}
+
+// parameterIndex returns the position of a named parameter, or -1
+func parameterIndex(function *parser.Function, name string) int {
+	for i, param := range function.Params {
+		if parser.ParameterName(param.Snippet) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// substituteRoot replaces the root of an object expression, e.g. "c->data" with root "c"
+// and replacement "req->ctx" becomes "req->ctx->data"
+func substituteRoot(object, root, replacement string) string {
+	replacement = parser.NormalizeTarget(parser.StripCasts(replacement))
+	rest := strings.TrimPrefix(object, root)
+	if strings.HasPrefix(replacement, "&") && strings.HasPrefix(rest, "->") {
+		// Passing &x for a pointer parameter p turns p->f into x.f
+		return strings.TrimPrefix(replacement, "&") + "." + strings.TrimPrefix(rest, "->")
+	}
+	if rest == "" {
+		return strings.TrimPrefix(replacement, "&")
+	}
+	return replacement + rest
+}
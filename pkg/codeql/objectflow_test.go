@@ -0,0 +1,58 @@
+package codeql
+
+import "testing"
+
+const objectFlowSource = `struct obj { char *buf; };
+struct ops { void (*release)(struct obj *o); };
+struct dev { struct ops *ops; struct obj *obj; };
+void kfree(void *p);
+void obj_release(struct obj *o)
+{
+	kfree(o->buf);
+}
+static struct ops dev_ops = { .release = obj_release };
+void dev_close(struct dev *d)
+{
+	d->ops->release(d->obj);
+}
+void use(struct dev *d)
+{
+	d->obj->buf[0] = 0;
+}
+void run(struct dev *d)
+{
+	dev_close(d);
+	use(d);
+}
+`
+
+func TestAddObjectFlows(t *testing.T) {
+	f := loadC(t, objectFlowSource)
+	finding := Finding{CodeQLResult: f.result(t, "buf", "obj_release", 7, "use", 16)}
+	finding.CallValidation = f.graph.ValidateCallRelationship("obj_release", "use", f.graph.pathOptions.MaxDepth)
+	f.enricher.addObjectFlows(&finding)
+
+	// dev_close reaches obj_release through the ops member, so the hop is found by its call line
+	// rather than by the callee's name
+	for i, flow := range finding.CallValidation.ObjectFlows {
+		chain := finding.CallValidation.CallChains[flow.Chain]
+		if len(chain.Functions) != 3 || chain.Functions[1] != "dev_close" {
+			continue
+		}
+		want := []ObjectHop{
+			{Caller: "run", Callee: "dev_close", Line: 20, CallerObject: "d->obj->buf", CalleeObject: "d->obj->buf"},
+			{Caller: "dev_close", Callee: "obj_release", Line: 12, CallerObject: "d->obj->buf", CalleeObject: "o->buf"},
+		}
+		if len(flow.Hops) != len(want) {
+			t.Fatalf("flow %d: got %d hops %+v, want %d", i, len(flow.Hops), flow.Hops, len(want))
+		}
+		for j, hop := range flow.Hops {
+			if hop.Caller != want[j].Caller || hop.Callee != want[j].Callee || hop.Line != want[j].Line ||
+				hop.CallerObject != want[j].CallerObject || hop.CalleeObject != want[j].CalleeObject {
+				t.Errorf("hop %d = %+v, want %+v", j, hop, want[j])
+			}
+		}
+		return
+	}
+	t.Fatalf("no object flow along run -> dev_close -> obj_release: %+v", finding.CallValidation.ObjectFlows)
+}
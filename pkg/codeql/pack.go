@@ -0,0 +1,301 @@
+package codeql
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/noperator/slice/pkg/logging"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// tokenEncoding is the tokenizer budgets are counted in, the one raink ranks with
+const tokenEncoding = "o200k_base"
+
+// bytesPerToken estimates token counts when the tokenizer cannot be loaded; source code rarely
+// averages fewer bytes per token, so budgets stay conservative
+const bytesPerToken = 3
+
+// siteContext is the lines kept on each side of a free, use or chain call when a function is cut
+// down to its call sites
+const siteContext = 3
+
+var tokenizer struct {
+	once     sync.Once
+	encoding *tiktoken.Tiktoken
+}
+
+// CountTokens counts the o200k_base tokens of a text. The encoding is loaded from the binary
+// rather than downloaded. It reports false when the count is an estimate because the tokenizer
+// could not be loaded.
+func CountTokens(text string) (int, bool) {
+	tokenizer.once.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+		encoding, err := tiktoken.GetEncoding(tokenEncoding)
+		if err != nil {
+			logging.NewLoggerFromEnv().Warn("tokenizer unavailable; estimating token counts",
+				"component", "codeql",
+				"encoding", tokenEncoding,
+				"error", err)
+			return
+		}
+		tokenizer.encoding = encoding
+	})
+	if tokenizer.encoding == nil {
+		return (len(text) + bytesPerToken - 1) / bytesPerToken, false
+	}
+	return len(tokenizer.encoding.EncodeOrdinary(text)), true
+}
+
+// ContextTokens reports the size of a finding's function code and how it was packed
+type ContextTokens struct {
+	Tokens    int      `json:"tokens"`              // Tokens of the free, use and intermediate function code
+	Budget    int      `json:"budget,omitempty"`    // Budget packed into, 0 when unlimited
+	Packed    []string `json:"packed,omitempty"`    // Functions cut down to fit, e.g. "dev_ioctl: call sites"
+	Estimated bool     `json:"estimated,omitempty"` // Counted without the tokenizer
+}
+
+// PackOptions configures PackContext
+type PackOptions struct {
+	Budget int  // Tokens of function code allowed, 0 = no limit
+	Slices bool // Start from slices rather than whole function bodies, as "context: slice" templates do
+}
+
+// Packing levels of a function, each no larger than the one before
+const (
+	packFull      = iota // Whole definition
+	packSlice            // Slice around the freed object
+	packSites            // Lines around the free, use and chain calls, the rest replaced by markers
+	packTruncated        // Leading lines of the level before, cut off once nothing else fits
+)
+
+var packLevelNames = [...]string{packFull: "full", packSlice: "slice", packSites: "call sites", packTruncated: "truncated"}
+
+// truncationMarker ends a function cut off at a line boundary
+var truncationMarker = fmt.Sprintf("%5s  /* ... truncated to fit the token budget ... */", "")
+
+// packedFunction is one function of a finding with its text at each packing level
+type packedFunction struct {
+	name    string
+	code    *FunctionCode
+	primary bool // The free or the use function
+	views   [4]string
+	tokens  [4]int
+	level   int
+}
+
+// PackContext returns a finding's source code with its function code counted and, when over the
+// budget, packed into it. The free, use and call-site hops are kept longest: intermediate
+// functions are cut down to their slices first, then the free and use functions, then
+// intermediate functions to the lines around their chain calls, then the free and use functions
+// to the lines around the free and use. A finding still over budget has its intermediate
+// functions, then the free and use functions, truncated at a line boundary until it fits.
+// Functions cut down have their definition replaced and their slice cleared.
+func PackContext(source SourceCode, result CodeQLResult, validation *CallValidation, options PackOptions) SourceCode {
+	source.IntermediateFunctions = append([]FunctionCode(nil), source.IntermediateFunctions...)
+	var chains []CallChain
+	if validation != nil {
+		chains = validation.CallChains
+	}
+
+	exact := true
+	count := func(text string) int {
+		tokens, ok := CountTokens(text)
+		exact = exact && ok
+		return tokens
+	}
+
+	free := &packedFunction{name: result.FreeFunctionName, code: &source.FreeFunction, primary: true}
+	use := &packedFunction{name: result.UseFunctionName, code: &source.UseFunction, primary: true}
+	freeSites := append(chainCallLines(chains, result.FreeFunctionFile, result.FreeFunctionDefLine), result.FreeLine)
+	useSites := append(chainCallLines(chains, result.UseFunctionFile, result.UseFunctionDefLine), result.UseLine)
+	if sameFile(result.FreeFunctionFile, result.UseFunctionFile) && result.FreeFunctionDefLine == result.UseFunctionDefLine {
+		freeSites = append(freeSites, result.UseLine)
+		useSites = append(useSites, result.FreeLine)
+	}
+	free.build(freeSites, count)
+	use.build(useSites, count)
+
+	functions := []*packedFunction{free, use}
+	for i := range source.IntermediateFunctions {
+		code := &source.IntermediateFunctions[i]
+		function := &packedFunction{name: fmt.Sprintf("intermediate %d", i+1), code: code}
+		var sites []int
+		for _, chain := range chains {
+			for _, hop := range chain.Hops {
+				if code.FunctionID != "" && hop.FunctionID == code.FunctionID && hop.CallLine > 0 {
+					function.name = hop.Function
+					sites = append(sites, hop.CallLine)
+				}
+			}
+		}
+		function.build(sites, count)
+		functions = append(functions, function)
+	}
+
+	total := 0
+	for _, function := range functions {
+		if options.Slices {
+			function.level = packSlice
+		}
+		total += function.tokens[function.level]
+	}
+
+	if options.Budget > 0 {
+		for _, phase := range []struct {
+			level   int
+			primary bool
+		}{{packSlice, false}, {packSlice, true}, {packSites, false}, {packSites, true}, {packTruncated, false}, {packTruncated, true}} {
+			var candidates []*packedFunction
+			for _, function := range functions {
+				if function.primary == phase.primary && function.level < phase.level {
+					candidates = append(candidates, function)
+				}
+			}
+			// Largest first, so the fewest functions are cut down
+			sort.SliceStable(candidates, func(i, j int) bool {
+				return candidates[i].tokens[candidates[i].level] > candidates[j].tokens[candidates[j].level]
+			})
+			for _, function := range candidates {
+				if total <= options.Budget {
+					break
+				}
+				if phase.level == packTruncated {
+					function.truncate(function.tokens[function.level]-(total-options.Budget), count)
+				}
+				total += function.tokens[phase.level] - function.tokens[function.level]
+				function.level = phase.level
+			}
+		}
+	}
+
+	report := &ContextTokens{Tokens: total, Budget: options.Budget, Estimated: !exact}
+	for _, function := range functions {
+		if function.level == packFull {
+			continue
+		}
+		if !options.Slices || function.level > packSlice {
+			if function.views[function.level] != function.views[packFull] {
+				report.Packed = append(report.Packed, function.name+": "+packLevelNames[function.level])
+			}
+		}
+		function.code.DefinitionWithLineNumbers = function.views[function.level]
+		function.code.Slice = ""
+	}
+	source.Tokens = report
+	return source
+}
+
+// build renders and counts a function at each packing level. A level that would not shrink the
+// function keeps the text of the level before.
+func (p *packedFunction) build(sites []int, count func(string) int) {
+	p.views[packFull] = p.code.DefinitionWithLineNumbers
+	p.views[packSlice] = p.code.Slice
+	p.views[packSites] = siteView(p.code.DefinitionWithLineNumbers, sites)
+
+	p.tokens[packFull] = count(p.views[packFull])
+	for level := packSlice; level <= packSites; level++ {
+		if p.views[level] != "" && p.views[level] != p.views[level-1] {
+			if tokens := count(p.views[level]); tokens < p.tokens[level-1] {
+				p.tokens[level] = tokens
+				continue
+			}
+		}
+		p.views[level], p.tokens[level] = p.views[level-1], p.tokens[level-1]
+	}
+	p.views[packTruncated], p.tokens[packTruncated] = p.views[packSites], p.tokens[packSites]
+}
+
+// truncate cuts the function's current view down to as many leading lines as fit in a number of
+// tokens, followed by a marker. Only the marker is left when not even the first line fits.
+func (p *packedFunction) truncate(tokens int, count func(string) int) {
+	lines := strings.Split(p.views[p.level], "\n")
+	render := func(n int) string {
+		return strings.Join(append(lines[:n:n], truncationMarker), "\n")
+	}
+	low, high := 0, len(lines)-1
+	for low < high {
+		mid := (low + high + 1) / 2
+		if count(render(mid)) <= tokens {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	view := render(low)
+	if truncated := count(view); truncated < p.tokens[p.level] {
+		p.views[packTruncated], p.tokens[packTruncated] = view, truncated
+	}
+}
+
+// chainCallLines returns the lines where the function defined at a line of a file calls the
+// next function of any chain. The file is relative to the source directory, like the hops'.
+func chainCallLines(chains []CallChain, file string, defLine int) []int {
+	var lines []int
+	for _, chain := range chains {
+		for _, hop := range chain.Hops {
+			if hop.DefLine == defLine && sameFile(hop.File, file) && hop.CallLine > 0 {
+				lines = append(lines, hop.CallLine)
+			}
+		}
+	}
+	return lines
+}
+
+// sameFile reports whether two paths name the same file
+func sameFile(a, b string) bool {
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// siteView keeps the first and last line of a numbered definition and the lines around each
+// site, replacing every run of other lines with a marker
+func siteView(definition string, sites []int) string {
+	lines := strings.Split(definition, "\n")
+	if len(lines) <= 2 {
+		return definition
+	}
+
+	var result strings.Builder
+	omitted := 0
+	flush := func() {
+		if omitted > 0 {
+			result.WriteString(fmt.Sprintf("%5s  /* ... %d lines omitted to fit the token budget ... */\n", "", omitted))
+			omitted = 0
+		}
+	}
+	for i, text := range lines {
+		keep := i == 0 || i == len(lines)-1
+		if line, ok := lineNumber(text); ok {
+			for _, site := range sites {
+				if line >= site-siteContext && line <= site+siteContext {
+					keep = true
+					break
+				}
+			}
+		} else {
+			keep = true
+		}
+		if !keep {
+			omitted++
+			continue
+		}
+		flush()
+		result.WriteString(text)
+		result.WriteString("\n")
+	}
+	flush()
+	return strings.TrimSuffix(result.String(), "\n")
+}
+
+// lineNumber parses the line number DefinitionWithLineNumbers prefixes each line with
+func lineNumber(text string) (int, bool) {
+	if len(text) < 5 {
+		return 0, false
+	}
+	line, err := strconv.Atoi(strings.TrimSpace(text[:5]))
+	return line, err == nil
+}
@@ -0,0 +1,236 @@
+package codeql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// numbered returns a numbered definition of a function spanning lines start to end
+func numbered(name string, start, end int) string {
+	lines := []string{fmt.Sprintf("%5d  void %s(void) {", start, name)}
+	for line := start + 1; line < end; line++ {
+		lines = append(lines, fmt.Sprintf("%5d  \tstep_%d();", line, line))
+	}
+	lines = append(lines, fmt.Sprintf("%5d  }", end))
+	return strings.Join(lines, "\n")
+}
+
+// keptLines returns the line numbers a packed definition keeps
+func keptLines(definition string) []int {
+	var lines []int
+	for _, text := range strings.Split(definition, "\n") {
+		if line, ok := lineNumber(text); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// siteTokens counts the tokens of a finding's functions cut down to their call sites, given the
+// chain call line of each intermediate function
+func siteTokens(source SourceCode, calls map[int]int) int {
+	views := []string{
+		siteView(source.FreeFunction.DefinitionWithLineNumbers, []int{20}),
+		siteView(source.UseFunction.DefinitionWithLineNumbers, []int{120}),
+	}
+	for i, code := range source.IntermediateFunctions {
+		var sites []int
+		if line, ok := calls[i]; ok {
+			sites = []int{line}
+		}
+		views = append(views, siteView(code.DefinitionWithLineNumbers, sites))
+	}
+	total := 0
+	for _, view := range views {
+		tokens, _ := CountTokens(view)
+		total += tokens
+	}
+	return total
+}
+
+func TestCountTokens(t *testing.T) {
+	tokens, exact := CountTokens("hello world")
+	if !exact {
+		t.Fatal("tokenizer not loaded offline")
+	}
+	if tokens != 2 {
+		t.Errorf("CountTokens = %d, want 2", tokens)
+	}
+}
+
+func TestSiteView(t *testing.T) {
+	definition := numbered("f", 10, 30)
+	tests := []struct {
+		name  string
+		sites []int
+		want  []int
+	}{
+		{"one site", []int{20}, []int{10, 17, 18, 19, 20, 21, 22, 23, 30}},
+		{"overlapping sites", []int{12, 15}, []int{10, 11, 12, 13, 14, 15, 16, 17, 18, 30}},
+		{"no sites", nil, []int{10, 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view := siteView(definition, tt.sites)
+			if got := keptLines(view); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept lines = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(view, "lines omitted to fit the token budget") {
+				t.Error("no omission marker")
+			}
+		})
+	}
+}
+
+func TestPackContext(t *testing.T) {
+	// Two intermediate functions defined on the same line of different files, and a hop in b.c
+	// defined on the free function's line
+	result := CodeQLResult{
+		FreeFunctionName: "do_free", FreeFunctionFile: "a.c", FreeFunctionDefLine: 1, FreeLine: 20,
+		UseFunctionName: "do_use", UseFunctionFile: "a.c", UseFunctionDefLine: 100, UseLine: 120,
+	}
+	validation := &CallValidation{CallChains: []CallChain{
+		{Hops: []ChainHop{
+			{Function: "outer", FunctionID: "/src/b.c:1:outer", File: "b.c", DefLine: 1, Calls: "helper", CallLine: 5},
+			{Function: "helper", FunctionID: "/src/a.c:50:helper", File: "a.c", DefLine: 50, Calls: "do_free", CallLine: 60},
+			{Function: "do_free", FunctionID: "/src/a.c:1:do_free", File: "a.c", DefLine: 1},
+		}},
+		{Hops: []ChainHop{
+			{Function: "outer", FunctionID: "/src/b.c:1:outer", File: "b.c", DefLine: 1, Calls: "relay", CallLine: 6},
+			{Function: "relay", FunctionID: "/src/b.c:50:relay", File: "b.c", DefLine: 50, Calls: "do_use", CallLine: 75},
+			{Function: "do_use", FunctionID: "/src/a.c:100:do_use", File: "a.c", DefLine: 100},
+		}},
+	}}
+	source := SourceCode{
+		FreeFunction: FunctionCode{DefinitionWithLineNumbers: numbered("do_free", 1, 40)},
+		UseFunction:  FunctionCode{DefinitionWithLineNumbers: numbered("do_use", 100, 140)},
+		IntermediateFunctions: []FunctionCode{
+			{FunctionID: "/src/a.c:50:helper", DefinitionWithLineNumbers: numbered("helper", 50, 90)},
+			{FunctionID: "/src/b.c:50:relay", DefinitionWithLineNumbers: numbered("relay", 50, 90)},
+		},
+	}
+
+	unpacked := PackContext(source, result, validation, PackOptions{})
+	if unpacked.Tokens == nil || unpacked.Tokens.Tokens == 0 || len(unpacked.Tokens.Packed) != 0 {
+		t.Fatalf("unpacked tokens = %+v", unpacked.Tokens)
+	}
+	if unpacked.FreeFunction.DefinitionWithLineNumbers != source.FreeFunction.DefinitionWithLineNumbers {
+		t.Error("free function changed without a budget")
+	}
+
+	// A budget the call-site views just fit in
+	sites := siteTokens(source, map[int]int{0: 60, 1: 75})
+
+	packed := PackContext(source, result, validation, PackOptions{Budget: sites})
+	tests := []struct {
+		name string
+		code FunctionCode
+		site int
+	}{
+		{"do_free", packed.FreeFunction, 20},
+		{"do_use", packed.UseFunction, 120},
+		{"helper", packed.IntermediateFunctions[0], 60},
+		{"relay", packed.IntermediateFunctions[1], 75},
+	}
+	for _, tt := range tests {
+		kept := keptLines(tt.code.DefinitionWithLineNumbers)
+		first, last := kept[0], kept[len(kept)-1]
+		want := []int{first}
+		for line := tt.site - siteContext; line <= tt.site+siteContext; line++ {
+			want = append(want, line)
+		}
+		want = append(want, last)
+		if !reflect.DeepEqual(kept, want) {
+			t.Errorf("%s keeps lines %v, want %v", tt.name, kept, want)
+		}
+	}
+	wantPacked := []string{"do_free: call sites", "do_use: call sites", "helper: call sites", "relay: call sites"}
+	if !reflect.DeepEqual(packed.Tokens.Packed, wantPacked) {
+		t.Errorf("packed = %v, want %v", packed.Tokens.Packed, wantPacked)
+	}
+	if packed.Tokens.Tokens != sites || packed.Tokens.Budget != sites {
+		t.Errorf("packed tokens = %+v, unpacked %d", packed.Tokens, unpacked.Tokens.Tokens)
+	}
+	if source.IntermediateFunctions[0].DefinitionWithLineNumbers != numbered("helper", 50, 90) {
+		t.Error("PackContext changed its input")
+	}
+}
+
+func TestPackContextTruncates(t *testing.T) {
+	result := CodeQLResult{
+		FreeFunctionName: "do_free", FreeFunctionFile: "a.c", FreeFunctionDefLine: 1, FreeLine: 20,
+		UseFunctionName: "do_use", UseFunctionFile: "a.c", UseFunctionDefLine: 100, UseLine: 120,
+	}
+	source := SourceCode{
+		FreeFunction: FunctionCode{DefinitionWithLineNumbers: numbered("do_free", 1, 40)},
+		UseFunction:  FunctionCode{DefinitionWithLineNumbers: numbered("do_use", 100, 140)},
+		IntermediateFunctions: []FunctionCode{
+			{FunctionID: "/src/a.c:50:helper", DefinitionWithLineNumbers: numbered("helper", 50, 90)},
+		},
+	}
+	sites := siteTokens(source, nil)
+
+	tests := []struct {
+		name   string
+		budget int
+		packed []string
+	}{
+		// Even the call-site views are over budget: the intermediate function goes first
+		{"intermediate", sites - 10, []string{"do_free: call sites", "do_use: call sites", "intermediate 1: truncated"}},
+		{"every function", sites / 2, []string{"do_free: truncated", "do_use: truncated", "intermediate 1: truncated"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packed := PackContext(source, result, nil, PackOptions{Budget: tt.budget})
+			if packed.Tokens.Tokens > tt.budget {
+				t.Errorf("tokens = %d, over the budget of %d", packed.Tokens.Tokens, tt.budget)
+			}
+			if !reflect.DeepEqual(packed.Tokens.Packed, tt.packed) {
+				t.Errorf("packed = %v, want %v", packed.Tokens.Packed, tt.packed)
+			}
+			for _, code := range append([]FunctionCode{packed.FreeFunction, packed.UseFunction}, packed.IntermediateFunctions...) {
+				definition := code.DefinitionWithLineNumbers
+				if strings.Contains(definition, "truncated to fit the token budget") && !strings.HasSuffix(definition, truncationMarker) {
+					t.Errorf("truncated definition does not end in the marker:\n%s", definition)
+				}
+			}
+		})
+	}
+}
+
+func TestEnricherCountsTokens(t *testing.T) {
+	f := loadC(t, `void kfree(void *p);
+void release(char *p)
+{
+	kfree(p);
+	p[0] = 0;
+}
+`)
+	result := f.result(t, "p", "release", 4, "release", 5)
+	tests := []struct {
+		name   string
+		count  bool
+		budget int
+		want   bool
+	}{
+		{"off by default", false, 0, false},
+		{"count", true, 0, true},
+		{"budget", false, 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher := NewQueryEnricher(f.dir)
+			enricher.SetCountTokens(tt.count)
+			enricher.SetTokenBudget(tt.budget)
+			findings, err := enricher.EnrichResults([]CodeQLResult{result}, f.graph, true, -1, 1)
+			if err != nil || len(findings) != 1 {
+				t.Fatalf("EnrichResults = %d findings, %v", len(findings), err)
+			}
+			if got := findings[0].SourceCode.Tokens != nil; got != tt.want {
+				t.Errorf("tokens counted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package codeql
+
+import "github.com/noperator/slice/pkg/parser"
+
+// parsedFunctions parses each function a finding's analyses look at once, so slicing, guards,
+// site classification, locks, dataflow and metrics share one syntax tree and CFG per function.
+// It belongs to a single worker and is closed once the finding is enriched.
+type parsedFunctions struct {
+	byID   map[string]*parser.ParsedFunction
+	errors map[string]error
+}
+
+// newParsedFunctions returns an empty per-finding parse cache
+func newParsedFunctions() *parsedFunctions {
+	return &parsedFunctions{
+		byID:   make(map[string]*parser.ParsedFunction),
+		errors: make(map[string]error),
+	}
+}
+
+// get returns the parse of a function, parsing it on first use
+func (p *parsedFunctions) get(function *parser.Function) (*parser.ParsedFunction, error) {
+	if parsed, ok := p.byID[function.ID]; ok {
+		return parsed, nil
+	}
+	if err, ok := p.errors[function.ID]; ok {
+		return nil, err
+	}
+	parsed, err := parser.ParseFunction(function)
+	if err != nil {
+		p.errors[function.ID] = err
+		return nil, err
+	}
+	p.byID[function.ID] = parsed
+	return parsed, nil
+}
+
+// heldLocks is parser.HeldLocks on the cached parse, skipping the parse for functions that
+// take no locks
+func (p *parsedFunctions) heldLocks(function *parser.Function, lines []int) (map[int][]parser.HeldLock, error) {
+	if len(parser.FindLockOps(function)) == 0 {
+		return make(map[int][]parser.HeldLock), nil
+	}
+	parsed, err := p.get(function)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.HeldLocks(lines), nil
+}
+
+// close releases every parse
+func (p *parsedFunctions) close() {
+	for id, parsed := range p.byID {
+		parsed.Close()
+		delete(p.byID, id)
+	}
+}
@@ -0,0 +1,393 @@
+package codeql
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of call graph edges
+const (
+	EdgeDirect   = "direct"   // foo() calls bar() by name
+	EdgeIndirect = "indirect" // foo() calls through a struct member bar() was stored into
+	EdgeCallback = "callback" // foo() passes bar to another function, which may call it
+)
+
+// maxIndirectTargets bounds how many functions a call through one struct member may reach
+const maxIndirectTargets = 16
+
+// PathOptions bounds the call chains enumerated between a free and a use
+type PathOptions struct {
+	MaxPaths int           // Chains per direction, shortest first
+	MaxDepth int           // Calls per chain when no call depth is given
+	Budget   time.Duration // Time allowed per finding; zero for no limit
+}
+
+// DefaultPathOptions returns up to three chains of at most ten calls per finding, spending at
+// most two seconds on each
+var DefaultPathOptions = PathOptions{MaxPaths: 3, MaxDepth: 10, Budget: 2 * time.Second}
+
+// SetPathOptions changes how many chains are enumerated per finding and how long that may take
+func (cg *CallGraph) SetPathOptions(options PathOptions) {
+	if options.MaxPaths <= 0 {
+		options.MaxPaths = DefaultPathOptions.MaxPaths
+	}
+	if options.MaxDepth <= 0 {
+		options.MaxDepth = DefaultPathOptions.MaxDepth
+	}
+	cg.pathOptions = options
+}
+
+// CallChain is a caller-to-callee path through the call graph
+type CallChain struct {
+	Functions []string   `json:"funcs"`
+	Edges     []string   `json:"edges"` // Kind of each call, one fewer than Functions
+	Length    int        `json:"len"`   // Number of calls
+	Hops      []ChainHop `json:"hops,omitempty"`
+}
+
+// ChainHop is one function of a call chain and, unless it is the last, its call to the next
+type ChainHop struct {
+	Function    string `json:"func"`
+	FunctionID  string `json:"func_id"`
+	File        string `json:"file"`
+	DefLine     int    `json:"def_ln"`
+	Calls       string `json:"calls,omitempty"`        // Next function in the chain
+	CallLine    int    `json:"call_ln,omitempty"`      // First line calling it
+	CallSnippet string `json:"call_snippet,omitempty"` // Statement text of that call
+	Edge        string `json:"edge,omitempty"`         // Kind of that call
+}
+
+// functionLocation is where a call graph function is defined
+type functionLocation struct {
+	file string
+	line int
+}
+
+// UnmarshalJSON also accepts chains written as a plain list of function names by older versions
+func (c *CallChain) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		*c = CallChain{Functions: names}
+		for i := 1; i < len(names); i++ {
+			c.Edges = append(c.Edges, EdgeDirect)
+		}
+		c.Length = len(c.Edges)
+		return nil
+	}
+
+	type plain CallChain
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// EdgeInto returns the kind of call reaching the function at a position in the chain
+func (c CallChain) EdgeInto(position int) string {
+	if position <= 0 || position > len(c.Edges) {
+		return ""
+	}
+	return c.Edges[position-1]
+}
+
+// newCallChain turns a path of functions into hops, recording where and how each function
+// calls the next
+func (cg *CallGraph) newCallChain(path []int32) CallChain {
+	chain := CallChain{Length: len(path) - 1}
+	for i, v := range path {
+		location := cg.locations[v]
+		hop := ChainHop{
+			Function:   extractFunctionName(cg.ids[v]),
+			FunctionID: cg.ids[v],
+			File:       location.file,
+			DefLine:    location.line,
+		}
+		if i < len(path)-1 {
+			next := path[i+1]
+			hop.Calls = extractFunctionName(cg.ids[next])
+			hop.Edge = EdgeDirect
+			if e := cg.edge(v, next); e >= 0 {
+				hop.CallLine = int(cg.callLines[e])
+				hop.CallSnippet = cg.callText[e]
+				hop.Edge = edgeKindNames[cg.edgeKinds[e]]
+			}
+			chain.Edges = append(chain.Edges, hop.Edge)
+		}
+		chain.Functions = append(chain.Functions, hop.Function)
+		chain.Hops = append(chain.Hops, hop)
+	}
+	return chain
+}
+
+// pathIDs converts a path of function indices to function IDs
+func (cg *CallGraph) pathIDs(path []int32) []string {
+	ids := make([]string, len(path))
+	for i, v := range path {
+		ids[i] = cg.ids[v]
+	}
+	return ids
+}
+
+// relativeTo returns the chain with its hops' files relative to the source directory, like the
+// query's own file paths. The hops are copied, as memoized chains share them between findings.
+func (c CallChain) relativeTo(sourceDir string) CallChain {
+	hops := make([]ChainHop, len(c.Hops))
+	for i, hop := range c.Hops {
+		hop.File = relativePath(sourceDir, hop.File)
+		hops[i] = hop
+	}
+	c.Hops = hops
+	return c
+}
+
+// relativePath returns a file relative to the source directory when it lies inside it
+func relativePath(sourceDir, file string) string {
+	if rel, err := filepath.Rel(sourceDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+// relativeTo rewrites the files of every chain relative to the source directory
+func (r *ReachabilityAnalysis) relativeTo(sourceDir string) {
+	for i := range r.CallChains {
+		r.CallChains[i] = r.CallChains[i].relativeTo(sourceDir)
+	}
+}
+
+// IntermediateIDs returns the IDs of the chain functions other than the free and the use
+// function, in the order the chains reach them
+func (r *ReachabilityAnalysis) IntermediateIDs(freeID, useID string) []string {
+	seen := map[string]bool{freeID: true, useID: true}
+	var ids []string
+	for _, chain := range r.CallChains {
+		for _, hop := range chain.Hops {
+			if !seen[hop.FunctionID] {
+				seen[hop.FunctionID] = true
+				ids = append(ids, hop.FunctionID)
+			}
+		}
+	}
+	return ids
+}
+
+// pathQuery identifies a memoized search between two functions
+type pathQuery struct {
+	kind     byte // 'p' for chains, 'a' for common callers
+	from, to int32
+	k, depth int
+}
+
+// cachedPaths returns the k shortest chains between two functions, reusing the result of an
+// earlier finding with the same pair. Searches cut short by the deadline are not memoized.
+func (cg *CallGraph) cachedPaths(from, to int32, k, maxDepth int, deadline time.Time) [][]int32 {
+	query := pathQuery{kind: 'p', from: from, to: to, k: k, depth: maxDepth}
+	if cached, ok := cg.pathCache.Load(query); ok {
+		return cached.([][]int32)
+	}
+	if !cg.mayReach(from, to) {
+		cg.pathCache.Store(query, [][]int32(nil))
+		return nil
+	}
+	paths, complete := cg.kShortestPaths(from, to, k, maxDepth, deadline)
+	if complete {
+		cg.pathCache.Store(query, paths)
+	}
+	return paths
+}
+
+// bfsScratch holds the visit marks of one search, reused across searches so that a BFS over
+// a large graph allocates nothing per visited function
+type bfsScratch struct {
+	stamp             uint32
+	forward, backward []uint32 // == stamp when visited from the source / from the target
+	parent, next      []int32  // Caller on the forward side, callee on the backward side
+	depth             []int32  // Distance from the source, or to the target
+	otherDepth        []int32  // Distance on the backward side, when both sides keep one
+}
+
+// scratch takes a search scratch sized for the graph from the pool
+func (cg *CallGraph) scratch() *bfsScratch {
+	if s, ok := cg.scratchPool.Get().(*bfsScratch); ok {
+		s.stamp++
+		if s.stamp == 0 {
+			clear(s.forward)
+			clear(s.backward)
+			s.stamp = 1
+		}
+		return s
+	}
+	n := len(cg.ids)
+	return &bfsScratch{
+		stamp:      1,
+		forward:    make([]uint32, n),
+		backward:   make([]uint32, n),
+		parent:     make([]int32, n),
+		next:       make([]int32, n),
+		depth:      make([]int32, n),
+		otherDepth: make([]int32, n),
+	}
+}
+
+// shortestPath finds a fewest-calls path by bidirectional BFS, expanding the smaller frontier
+// each round and skipping blocked functions and calls. A negative maxDepth means no limit.
+func (cg *CallGraph) shortestPath(from, to int32, maxDepth int, blockedNodes map[int32]bool, blockedEdges map[[2]int32]bool) []int32 {
+	if from == to {
+		return []int32{from}
+	}
+	if maxDepth == 0 || cg.component[from] < cg.component[to] {
+		return nil // Components are numbered so that callers come after their callees
+	}
+
+	s := cg.scratch()
+	defer cg.scratchPool.Put(s)
+	s.forward[from], s.parent[from], s.depth[from] = s.stamp, -1, 0
+	s.backward[to], s.next[to], s.depth[to] = s.stamp, -1, 0
+
+	forward, backward := []int32{from}, []int32{to}
+	forwardDepth, backwardDepth := 0, 0
+	meet, best := int32(-1), 0
+	for len(forward) > 0 && len(backward) > 0 && (maxDepth < 0 || forwardDepth+backwardDepth < maxDepth) {
+		var frontier []int32
+		if len(forward) <= len(backward) {
+			forwardDepth++
+			for _, v := range forward {
+				for _, w := range cg.calls.neighbours(v) {
+					if s.forward[w] == s.stamp || blockedNodes[w] || blockedEdges[[2]int32{v, w}] {
+						continue
+					}
+					s.forward[w], s.parent[w] = s.stamp, v
+					if s.backward[w] == s.stamp {
+						if length := forwardDepth + int(s.depth[w]); meet < 0 || length < best {
+							meet, best = w, length
+						}
+						continue // Its depth holds the distance to the target
+					}
+					s.depth[w] = int32(forwardDepth)
+					frontier = append(frontier, w)
+				}
+			}
+			forward = frontier
+		} else {
+			backwardDepth++
+			for _, w := range backward {
+				for _, u := range cg.callers.neighbours(w) {
+					if s.backward[u] == s.stamp || blockedNodes[u] || blockedEdges[[2]int32{u, w}] {
+						continue
+					}
+					s.backward[u], s.next[u] = s.stamp, w
+					if s.forward[u] == s.stamp {
+						if length := int(s.depth[u]) + backwardDepth; meet < 0 || length < best {
+							meet, best = u, length
+						}
+						continue // Its depth holds the distance from the source
+					}
+					s.depth[u] = int32(backwardDepth)
+					frontier = append(frontier, u)
+				}
+			}
+			backward = frontier
+		}
+
+		if meet >= 0 {
+			var path []int32
+			for at := meet; at >= 0; at = s.parent[at] {
+				path = append(path, at)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			for at := s.next[meet]; at >= 0; at = s.next[at] {
+				path = append(path, at)
+			}
+			return path
+		}
+	}
+	return nil
+}
+
+// kShortestPaths enumerates up to k loop-free paths in order of length using Yen's algorithm,
+// stopping early once the deadline passes; complete is false when it did
+func (cg *CallGraph) kShortestPaths(from, to int32, k, maxDepth int, deadline time.Time) (paths [][]int32, complete bool) {
+	first := cg.shortestPath(from, to, maxDepth, nil, nil)
+	if first == nil {
+		return nil, true
+	}
+	paths = [][]int32{first}
+	seen := map[string]bool{pathKey(first): true}
+	var candidates [][]int32
+
+	expired := func() bool { return !deadline.IsZero() && time.Now().After(deadline) }
+
+	for len(paths) < k {
+		if expired() {
+			return paths, false
+		}
+		previous := paths[len(paths)-1]
+		for i := 0; i < len(previous)-1; i++ {
+			if expired() {
+				return paths, false
+			}
+			spur := previous[i]
+			root := previous[:i+1]
+
+			// Block the next call of every known path sharing this root, and the root itself
+			blockedEdges := make(map[[2]int32]bool)
+			for _, path := range paths {
+				if len(path) > i+1 && equalPaths(path[:i+1], root) {
+					blockedEdges[[2]int32{path[i], path[i+1]}] = true
+				}
+			}
+			blockedNodes := make(map[int32]bool, i)
+			for _, v := range root[:i] {
+				blockedNodes[v] = true
+			}
+
+			spurDepth := -1
+			if maxDepth >= 0 {
+				spurDepth = maxDepth - i
+			}
+			spurPath := cg.shortestPath(spur, to, spurDepth, blockedNodes, blockedEdges)
+			if spurPath == nil {
+				continue
+			}
+			candidate := append(append([]int32(nil), root[:i]...), spurPath...)
+			if key := pathKey(candidate); !seen[key] {
+				seen[key] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+
+		sort.SliceStable(candidates, func(a, b int) bool { return len(candidates[a]) < len(candidates[b]) })
+		paths = append(paths, candidates[0])
+		candidates = candidates[1:]
+	}
+	return paths, true
+}
+
+// pathKey identifies a path for deduplication
+func pathKey(path []int32) string {
+	var b strings.Builder
+	for _, v := range path {
+		b.WriteString(strconv.Itoa(int(v)))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// equalPaths reports whether two paths visit the same functions
+func equalPaths(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
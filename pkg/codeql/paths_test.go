@@ -0,0 +1,83 @@
+package codeql
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChainHops(t *testing.T) {
+	// Both files define a static helper; only a.c's one calls down to the free
+	f := loadFiles(t, map[string]string{
+		"a.c": `void do_free(char *p);
+static void helper(char *p)
+{
+	do_free(p);
+}
+void entry(char *p)
+{
+	helper(p);
+}
+`,
+		"b.c": `void kfree(void *p);
+static void helper(char *p) { }
+void do_free(char *p)
+{
+	kfree(p);
+}
+`,
+	})
+	id := func(file string, line int, name string) string {
+		return fmt.Sprintf("%s:%d:%s", filepath.Join(f.dir, file), line, name)
+	}
+
+	validation := f.graph.ValidateCallRelationship("entry", "do_free", f.graph.pathOptions.MaxDepth)
+	if len(validation.CallChains) != 1 {
+		t.Fatalf("chains = %+v, want one", validation.CallChains)
+	}
+	want := []ChainHop{
+		{Function: "entry", FunctionID: id("a.c", 6, "entry"), File: filepath.Join(f.dir, "a.c"), DefLine: 6, Calls: "helper", CallLine: 8, CallSnippet: "helper(p);", Edge: EdgeDirect},
+		{Function: "helper", FunctionID: id("a.c", 2, "helper"), File: filepath.Join(f.dir, "a.c"), DefLine: 2, Calls: "do_free", CallLine: 4, CallSnippet: "do_free(p);", Edge: EdgeDirect},
+		{Function: "do_free", FunctionID: id("b.c", 3, "do_free"), File: filepath.Join(f.dir, "b.c"), DefLine: 3},
+	}
+	chain := validation.CallChains[0]
+	if !reflect.DeepEqual(chain.Hops, want) {
+		t.Errorf("hops = %+v, want %+v", chain.Hops, want)
+	}
+	if wantEdges := []string{EdgeDirect, EdgeDirect}; !reflect.DeepEqual(chain.Edges, wantEdges) || chain.Length != 2 {
+		t.Errorf("edges = %v, length %d, want %v and 2", chain.Edges, chain.Length, wantEdges)
+	}
+
+	intermediates := validation.IntermediateIDs(id("a.c", 6, "entry"), id("b.c", 3, "do_free"))
+	if want := []string{id("a.c", 2, "helper")}; !reflect.DeepEqual(intermediates, want) {
+		t.Errorf("intermediates = %v, want %v", intermediates, want)
+	}
+
+	validation.relativeTo(f.dir)
+	if file := validation.CallChains[0].Hops[2].File; file != "b.c" {
+		t.Errorf("relative file = %q, want b.c", file)
+	}
+}
+
+func TestCallChainUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want CallChain
+	}{
+		{"names", `["a","b","c"]`, CallChain{Functions: []string{"a", "b", "c"}, Edges: []string{EdgeDirect, EdgeDirect}, Length: 2}},
+		{"object", `{"funcs":["a","b"],"edges":["callback"],"len":1}`, CallChain{Functions: []string{"a", "b"}, Edges: []string{EdgeCallback}, Length: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chain CallChain
+			if err := chain.UnmarshalJSON([]byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(chain, tt.want) {
+				t.Errorf("chain = %+v, want %+v", chain, tt.want)
+			}
+		})
+	}
+}
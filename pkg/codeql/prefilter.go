@@ -0,0 +1,214 @@
+package codeql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// Prefilter reasons
+const (
+	PrefilterNullAfterFree = "null_after_free" // The freed pointer is set to NULL on every path to the use
+	PrefilterReassigned    = "reassigned"      // The freed pointer is given a new value on every path to the use
+	PrefilterUseBeforeFree = "use_before_free" // The use cannot execute after the free in the same function
+)
+
+// Prefilter is the static verdict on a finding, computed before any LLM triage
+type Prefilter struct {
+	LikelyFP bool   `json:"likely_fp"`
+	Reason   string `json:"reason,omitempty"`
+	Line     int    `json:"line,omitempty"` // Line of the nulling or reassignment
+	Detail   string `json:"detail,omitempty"`
+}
+
+// prefilterFinding checks a finding against the free function's reaching definitions. When free
+// and use share a function it asks whether the free still reaches the use. Otherwise it asks
+// whether the free reaches each call site that leads to the use function and, when the use may
+// run after the free function returns, its exit in a form callers can still see.
+func (e *QueryEnricher) prefilterFinding(freeFunc, useFunc *parser.Function, result CodeQLResult, callGraph *CallGraph, parsed *parsedFunctions) *Prefilter {
+	function, err := parsed.get(freeFunc)
+	if err != nil {
+		e.logger.Debug("could not analyze free function",
+			"component", "prefilter",
+			"function", freeFunc.ID,
+			"error", err)
+		return &Prefilter{}
+	}
+	dataflow := function.Dataflow()
+	cfg := dataflow.CFG
+
+	freeNode := cfg.NodeAtLine(result.FreeLine)
+	if freeNode < 0 {
+		return &Prefilter{}
+	}
+	freeDef := -1
+	expr := parser.NormalizeTarget(freedExpression(freeFunc, result))
+	for _, def := range dataflow.DefsAt(freeNode) {
+		if def.Kind != "free" {
+			continue
+		}
+		index := indexOfDefinition(dataflow, def)
+		if freeDef < 0 || def.Target == expr {
+			freeDef = index
+		}
+	}
+	if freeDef < 0 {
+		return &Prefilter{}
+	}
+	freed := dataflow.Defs[freeDef]
+	reaching := dataflow.ReachingDefinitions()
+
+	var targets []int
+	exit := false
+	if freeFunc.ID == useFunc.ID {
+		target := cfg.NodeAtLine(result.UseLine)
+		if target < 0 || target == freeNode {
+			return &Prefilter{}
+		}
+		if !cfg.Reachable(freeNode, target) {
+			return &Prefilter{
+				LikelyFP: true,
+				Reason:   PrefilterUseBeforeFree,
+				Detail:   fmt.Sprintf("no path from the free on L%d to the use on L%d", result.FreeLine, result.UseLine),
+			}
+		}
+		targets = append(targets, target)
+	} else {
+		var lines []int
+		lines, exit = callGraph.prefilterSites(freeFunc, useFunc)
+		for _, line := range lines {
+			node := cfg.NodeAtLine(line)
+			if node < 0 || node == freeNode {
+				return &Prefilter{}
+			}
+			if cfg.Reachable(freeNode, node) {
+				targets = append(targets, node)
+			}
+		}
+		if exit {
+			targets = append(targets, cfg.Exit)
+		}
+	}
+	if len(targets) == 0 {
+		return &Prefilter{}
+	}
+
+	// The free must be killed on every path to every target; find the definitions that did it
+	var kills []parser.Definition
+	seen := make(map[int]bool)
+	for _, target := range targets {
+		killed := false
+		for _, def := range reaching[target] {
+			if def == freeDef {
+				return &Prefilter{}
+			}
+			kill := dataflow.Defs[def]
+			if kill.Kind != "free" && parser.TargetCovers(kill.Target, freed.Target) && cfg.Reachable(freeNode, kill.Node) {
+				killed = true
+				if !seen[def] {
+					seen[def] = true
+					kills = append(kills, kill)
+				}
+			}
+		}
+		if !killed {
+			return &Prefilter{}
+		}
+	}
+
+	verdict := &Prefilter{LikelyFP: true, Reason: PrefilterNullAfterFree, Line: kills[0].Line}
+	for _, kill := range kills {
+		if exit && !e.visibleToCallers(freeFunc, kill.Target) {
+			// Reassigning a local copy leaves the caller's pointer dangling
+			return &Prefilter{}
+		}
+		if !parser.IsNullValue(kill.Value) {
+			verdict.Reason = PrefilterReassigned
+			verdict.Line = kill.Line
+		}
+	}
+	if verdict.Reason == PrefilterNullAfterFree {
+		verdict.Detail = fmt.Sprintf("%s is set to NULL on L%d after the free on L%d", freed.Target, verdict.Line, result.FreeLine)
+	} else {
+		verdict.Detail = fmt.Sprintf("%s is reassigned on L%d after the free on L%d", freed.Target, verdict.Line, result.FreeLine)
+	}
+	return verdict
+}
+
+// prefilterSites returns the lines where the free function makes a call that may lead to the use
+// function, and whether the use may also run after the free function returns: when the use
+// reaches the free, or the free does not reach the use at all. Without a graph, or with either
+// function missing from it, every call counts and so does the return.
+func (cg *CallGraph) prefilterSites(freeFunc, useFunc *parser.Function) ([]int, bool) {
+	var lines []int
+	if cg == nil {
+		for _, callee := range freeFunc.Callees {
+			lines = append(lines, callee.Line)
+		}
+		return lines, true
+	}
+	from, okFree := cg.index[freeFunc.ID]
+	to, okUse := cg.index[useFunc.ID]
+	if !okFree || !okUse {
+		for _, callee := range freeFunc.Callees {
+			lines = append(lines, callee.Line)
+		}
+		return lines, true
+	}
+
+	leads := func(name string) bool {
+		for _, v := range cg.functions[name] {
+			if v == to || cg.canReach(v, to) {
+				return true
+			}
+		}
+		return false
+	}
+	indirect := false
+	for _, v := range cg.calls.neighbours(from) {
+		if e := cg.edge(from, v); cg.edgeKinds[e] == edgeIndirect && (v == to || cg.canReach(v, to)) {
+			indirect = true
+			break
+		}
+	}
+	for _, callee := range freeFunc.Callees {
+		lead := leads(callee.Name) || (indirect && parser.CalledField(callee.Name) != "")
+		for _, arg := range callee.Args {
+			if name := strings.TrimLeft(strings.TrimSpace(arg), "&"); !lead && name != callee.Name {
+				lead = leads(name)
+			}
+		}
+		if lead {
+			lines = append(lines, callee.Line)
+		}
+	}
+	return lines, cg.canReach(to, from) || !cg.canReach(from, to)
+}
+
+// indexOfDefinition returns the index of a definition in a dataflow's Defs
+func indexOfDefinition(dataflow *parser.Dataflow, def parser.Definition) int {
+	for i, candidate := range dataflow.Defs {
+		if candidate == def {
+			return i
+		}
+	}
+	return -1
+}
+
+// visibleToCallers reports whether an assignment inside a function is observable after it
+// returns: through a pointer, or to a global
+func (e *QueryEnricher) visibleToCallers(function *parser.Function, target string) bool {
+	if strings.Contains(target, "->") || strings.HasPrefix(target, "*") {
+		return true
+	}
+	path := parseObjectPath(target)
+	if variable := findVariable(function, path.Base); variable != nil {
+		return variable.Origin == "param" && variable.PointerDepth > 0 && strings.Contains(target, "[")
+	}
+	analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+	if err != nil {
+		return false
+	}
+	return analysisResult.LookupGlobal(path.Base, function.Filename) != nil
+}
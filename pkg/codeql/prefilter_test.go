@@ -0,0 +1,96 @@
+package codeql
+
+import "testing"
+
+const prefilterSource = `struct conn { char *buf; };
+void kfree(void *p);
+void use_buf(struct conn *c)
+{
+	c->buf[0] = 0;
+}
+void free_then_call(struct conn *c)
+{
+	kfree(c->buf);
+	use_buf(c);
+	c->buf = 0;
+}
+void null_then_call(struct conn *c)
+{
+	kfree(c->buf);
+	c->buf = 0;
+	use_buf(c);
+}
+void null_on_one_path(struct conn *c, int a)
+{
+	kfree(c->buf);
+	if (a)
+		c->buf = 0;
+	use_buf(c);
+}
+void free_only(struct conn *c)
+{
+	kfree(c->buf);
+	c->buf = 0;
+}
+void caller(struct conn *c)
+{
+	free_only(c);
+	use_buf(c);
+}
+void free_local(char *buf)
+{
+	kfree(buf);
+	buf = 0;
+}
+void local_caller(struct conn *c)
+{
+	free_local(c->buf);
+	use_buf(c);
+}
+void same_function(struct conn *c)
+{
+	c->buf[0] = 1;
+	kfree(c->buf);
+}
+void same_function_null(struct conn *c)
+{
+	kfree(c->buf);
+	c->buf = 0;
+	c->buf[0] = 1;
+}
+`
+
+func TestPrefilterFinding(t *testing.T) {
+	f := loadC(t, prefilterSource)
+	parsed := newParsedFunctions()
+	defer parsed.close()
+	tests := []struct {
+		name     string
+		free     string
+		freeLine int
+		use      string
+		useLine  int
+		likelyFP bool
+		reason   string
+	}{
+		// The use runs inside the call, before the pointer is nulled
+		{"nulled after the call", "free_then_call", 9, "use_buf", 5, false, ""},
+		{"nulled before the call", "null_then_call", 15, "use_buf", 5, true, PrefilterNullAfterFree},
+		{"nulled on one path", "null_on_one_path", 21, "use_buf", 5, false, ""},
+		// The use runs in a caller after the free function returns
+		{"nulled before return", "free_only", 28, "use_buf", 5, true, PrefilterNullAfterFree},
+		{"local copy nulled", "free_local", 38, "use_buf", 5, false, ""},
+		{"use before free", "same_function", 49, "same_function", 48, true, PrefilterUseBeforeFree},
+		{"nulled before use", "same_function_null", 53, "same_function_null", 55, true, PrefilterNullAfterFree},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := f.result(t, "buf", tt.free, tt.freeLine, tt.use, tt.useLine)
+			verdict := f.enricher.prefilterFinding(f.functions[tt.free], f.functions[tt.use], result, f.graph, parsed)
+			if verdict.LikelyFP != tt.likelyFP || verdict.Reason != tt.reason {
+				t.Errorf("got likely_fp=%v reason=%q (%s), want likely_fp=%v reason=%q",
+					verdict.LikelyFP, verdict.Reason, verdict.Detail, tt.likelyFP, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package codeql
+
+import (
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// RefcountCall is a reference taken or dropped in one of a finding's chain functions
+type RefcountCall struct {
+	Function string `json:"func"`
+	parser.RefcountOp
+}
+
+// RefcountContext relates a finding to the reference counting around the freed object
+type RefcountContext struct {
+	ViaPut  string                 `json:"via_put,omitempty"` // Put helper the free is reached through
+	Release string                 `json:"release,omitempty"` // Release function containing the free
+	Fields  []parser.RefcountField `json:"fields,omitempty"`  // Reference counts of the freed type
+	Gets    []RefcountCall         `json:"gets,omitempty"`
+	Puts    []RefcountCall         `json:"puts,omitempty"`
+}
+
+// refcounting returns the refcount fields and get/put helpers of the source tree, detected once
+func (e *QueryEnricher) refcounting() *parser.RefcountModel {
+	e.refcountOnce.Do(func() {
+		analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir)
+		if err != nil {
+			return
+		}
+		e.refcount = parser.FindRefcounting(analysisResult)
+		e.logger.Debug("detected reference counting",
+			"component", "codeql",
+			"fields", len(e.refcount.Fields),
+			"helpers", len(e.refcount.Helpers))
+	})
+	return e.refcount
+}
+
+// addRefcountContext annotates a finding whose free is reached through a put, and lists the
+// gets and puts made by its chain functions so their balance can be checked
+func (e *QueryEnricher) addRefcountContext(finding *Finding, intermediates []string) {
+	model := e.refcounting()
+	if model == nil {
+		return
+	}
+	functions := e.chainFunctions(finding, intermediates)
+	if len(functions) == 0 {
+		return
+	}
+
+	context := &RefcountContext{}
+	freeFunc := functions[0].function
+	if helper := model.Helper(freeFunc.Name); helper != nil && helper.Kind == "put" {
+		context.ViaPut = helper.Name
+	} else if puts := model.ReleasedBy(freeFunc.Name); len(puts) > 0 {
+		context.ViaPut = puts[0].Name
+		context.Release = freeFunc.Name
+	}
+
+	if analysisResult, err := parser.GetCachedAnalysisResult(e.sourceDir); err == nil && finding.SourceCode.FreedType != "" {
+		freedType := analysisResult.CanonicalTypeName(finding.SourceCode.FreedType)
+		for _, field := range model.Fields {
+			if analysisResult.CanonicalTypeName(field.Type) == freedType {
+				context.Fields = append(context.Fields, field)
+			}
+		}
+	}
+
+	for _, chainFunc := range functions {
+		for _, op := range model.Operations(chainFunc.function, nil) {
+			call := RefcountCall{Function: chainFunc.function.Name, RefcountOp: op}
+			if op.Kind == "get" {
+				context.Gets = append(context.Gets, call)
+			} else {
+				context.Puts = append(context.Puts, call)
+			}
+		}
+	}
+
+	if context.ViaPut == "" && len(context.Fields) == 0 && len(context.Gets) == 0 && len(context.Puts) == 0 {
+		return
+	}
+	if finding.Annotations == nil {
+		finding.Annotations = &Annotations{}
+	}
+	finding.Annotations.Refcount = context
+}
@@ -0,0 +1,68 @@
+package codeql
+
+import (
+	"reflect"
+	"testing"
+)
+
+const refcountSource = `typedef struct { int counter; } atomic_t;
+struct kref { int count; };
+struct obj { struct kref kref; atomic_t stats; char *name; };
+void kfree(void *p);
+void kref_get(struct kref *kref);
+int kref_put(struct kref *kref, void (*release)(struct obj *o));
+void atomic_inc(atomic_t *v);
+void obj_release(struct obj *o)
+{
+	kfree(o->name);
+}
+void obj_get(struct obj *o)
+{
+	kref_get(&o->kref);
+}
+void obj_put(struct obj *o)
+{
+	kref_put(&o->kref, obj_release);
+}
+void obj_user(struct obj *o)
+{
+	obj_get(o);
+	atomic_inc(&o->stats);
+	o->name[0] = 0;
+	obj_put(o);
+	obj_put(o);
+}
+`
+
+func TestAddRefcountContext(t *testing.T) {
+	f := loadC(t, refcountSource)
+	finding := Finding{CodeQLResult: f.result(t, "name", "obj_release", 10, "obj_user", 30)}
+	finding.SourceCode.FreedType = "struct obj"
+	f.enricher.addRefcountContext(&finding, nil)
+	if finding.Annotations == nil || finding.Annotations.Refcount == nil {
+		t.Fatal("no refcount context")
+	}
+
+	context := finding.Annotations.Refcount
+	if context.ViaPut != "obj_put" || context.Release != "obj_release" {
+		t.Errorf("via put %q and release %q, want obj_put and obj_release", context.ViaPut, context.Release)
+	}
+	if len(context.Fields) != 1 || context.Fields[0].Field != "kref" {
+		t.Errorf("fields = %+v, want the kref of struct obj", context.Fields)
+	}
+
+	calls := func(calls []RefcountCall) []string {
+		var callees []string
+		for _, call := range calls {
+			callees = append(callees, call.Function+":"+call.Callee)
+		}
+		return callees
+	}
+	// The atomic_inc of o->stats is not a reference
+	if gets := calls(context.Gets); !reflect.DeepEqual(gets, []string{"obj_user:obj_get"}) {
+		t.Errorf("gets = %v, want [obj_user:obj_get]", gets)
+	}
+	if puts := calls(context.Puts); !reflect.DeepEqual(puts, []string{"obj_user:obj_put", "obj_user:obj_put"}) {
+		t.Errorf("puts = %v, want obj_put twice in obj_user", puts)
+	}
+}
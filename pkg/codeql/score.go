@@ -0,0 +1,111 @@
+package codeql
+
+import (
+	"math"
+
+	"github.com/noperator/slice/pkg/parser"
+)
+
+// scoreWeights weighs each static feature of a finding; every feature is scaled to 0-1 first
+var scoreWeights = map[string]float64{
+	"use_primitive": 3, // How much damage the use can do
+	"reachable":     3, // Whether entry points reach both the free and the use
+	"entry_dist":    1, // How few calls separate the entry point from the free and use
+	"free_fan_in":   2, // Freed in a widely called function
+	"betweenness":   1, // Free or use on many shortest call paths
+	"complexity":    1, // Complex free and use functions hide more lifetime bugs
+	"error_path":    1, // Frees on error and cleanup paths are a classic source of UAFs
+	"recursive":     0.5,
+}
+
+// scoreFeatures fixes the order features are summed in, so scores are reproducible to the bit
+var scoreFeatures = []string{"use_primitive", "reachable", "entry_dist", "free_fan_in", "betweenness", "complexity", "error_path", "recursive"}
+
+// likelyFPFactor scales the score of findings the prefilter flags as likely false positives
+const likelyFPFactor = 0.25
+
+// useSeverity scores each use primitive class
+var useSeverity = map[string]float64{
+	parser.UseDoubleFree:   1,
+	parser.UseIndirectCall: 1,
+	parser.UseCopyDest:     0.9,
+	parser.UseArrayWrite:   0.8,
+	parser.UseFieldWrite:   0.8,
+	parser.UseWrite:        0.7,
+	parser.UseArrayRead:    0.5,
+	parser.UseFieldRead:    0.4,
+	parser.UseRead:         0.4,
+	parser.UsePass:         0.3,
+}
+
+// unknownFeature is the value of a feature that could not be computed, e.g. reachability
+// without entry points
+const unknownFeature = 0.5
+
+// Score ranks a finding from its static annotations alone, for ranking without a model. It
+// returns a score between 0 and 1 and the weighted contribution of each feature.
+func Score(annotations *Annotations, prefilter *Prefilter) (float64, map[string]float64) {
+	if annotations == nil {
+		annotations = &Annotations{}
+	}
+	features := map[string]float64{
+		"use_primitive": unknownFeature,
+		"reachable":     unknownFeature,
+		"entry_dist":    unknownFeature,
+		"free_fan_in":   0,
+		"betweenness":   0,
+		"complexity":    0,
+		"error_path":    0,
+		"recursive":     0,
+	}
+
+	if primitive := annotations.UsePrimitive; primitive != nil {
+		if severity, ok := useSeverity[primitive.Class]; ok {
+			features["use_primitive"] = severity
+		}
+	}
+
+	if entry := annotations.Entry; entry != nil {
+		features["reachable"] = 0
+		if entry.Reachable {
+			features["reachable"] = 1
+		}
+	}
+
+	if metrics := annotations.Metrics; metrics != nil && metrics.Free != nil && metrics.Use != nil {
+		free, use := metrics.Free, metrics.Use
+
+		// 64 callers saturate the fan-in, 32 independent paths the complexity
+		features["free_fan_in"] = math.Min(1, math.Log2(1+float64(free.FanIn))/6)
+		features["betweenness"] = math.Max(free.BetweennessPct, use.BetweennessPct)
+		complexity := math.Max(float64(free.Complexity), float64(use.Complexity))
+		if complexity > 1 {
+			features["complexity"] = math.Min(1, math.Log2(complexity)/5)
+		}
+		if free.Recursive || use.Recursive {
+			features["recursive"] = 1
+		}
+		if free.EntryDistance != nil && use.EntryDistance != nil {
+			features["entry_dist"] = 0
+			if *free.EntryDistance >= 0 && *use.EntryDistance >= 0 {
+				features["entry_dist"] = 1 / (1 + float64(max(*free.EntryDistance, *use.EntryDistance)))
+			}
+		}
+	}
+
+	if free := annotations.Free; free != nil && (free.ErrorPath || free.CleanupLabel != "") {
+		features["error_path"] = 1
+	}
+
+	var score, total float64
+	for _, feature := range scoreFeatures {
+		features[feature] *= scoreWeights[feature]
+		score += features[feature]
+		total += scoreWeights[feature]
+	}
+	score /= total
+	if prefilter != nil && prefilter.LikelyFP {
+		score *= likelyFPFactor
+	}
+	return score, features
+}
@@ -1,5 +1,7 @@
 package codeql
 
+import "github.com/noperator/slice/pkg/parser"
+
 type CodeQLResult struct {
 	ObjName               string `json:"object"`
 	FreeFunctionName      string `json:"free_func"`
@@ -13,19 +15,59 @@ type CodeQLResult struct {
 }
 
 type FunctionCode struct {
-	DefinitionWithLineNumbers string `json:"def"`
-	Snippet                  string `json:"snippet"`
+	FunctionID                string            `json:"func_id,omitempty"` // Set for intermediate functions, which have no line of their own
+	DefinitionWithLineNumbers string            `json:"def"`
+	Snippet                   string            `json:"snippet"`
+	Slice                     string            `json:"slice,omitempty"`  // Definition elided to the lines relevant to the freed object
+	Guards                    []SiteGuards      `json:"guards,omitempty"` // Conditions guarding the free, use and chain call sites
+	Vars                      []parser.Variable `json:"vars,omitempty"`
 }
 
 type SourceCode struct {
-	FreeFunction          FunctionCode   `json:"free_func"`
-	UseFunction           FunctionCode   `json:"use_func"`
-	IntermediateFunctions []FunctionCode `json:"inter_funcs"`
+	FreeFunction          FunctionCode         `json:"free_func"`
+	UseFunction           FunctionCode         `json:"use_func"`
+	IntermediateFunctions []FunctionCode       `json:"inter_funcs"`
+	Global                *parser.Global       `json:"global,omitempty"` // Set when the freed object is a global; accesses exclude the free/use functions
+	FreedType             string               `json:"freed_type,omitempty"`
+	TypeDefinitions       []parser.TypeDef     `json:"types,omitempty"`          // Freed object's type and the containers it was reached through
+	TypeReferrers         []TypeReferrer       `json:"type_referrers,omitempty"` // Other struct members pointing at the freed type
+	FieldAccesses         []parser.FieldAccess `json:"field_accesses,omitempty"` // Other accesses to the freed struct member
+	Allocations           []AllocationSite     `json:"allocs,omitempty"`         // Where the freed object was allocated
+	Tokens                *ContextTokens       `json:"tokens,omitempty"`         // Size of the function code, and how it was packed into a budget
+}
+
+// TypeReferrer is a struct or union member whose type is the freed object's type
+type TypeReferrer struct {
+	Type      string `json:"type"`
+	Field     string `json:"field"`
+	FieldType string `json:"field_type"`
 }
 
 type Finding struct {
 	CodeQLResult   CodeQLResult    `json:"codeql_result"`
 	SourceCode     SourceCode      `json:"source_code"`
 	CallValidation *CallValidation `json:"call_validation,omitempty"`
+	Prefilter      *Prefilter      `json:"prefilter,omitempty"`
+	Annotations    *Annotations    `json:"annotations,omitempty"`
 }
 
+// Annotations are static classifications of a finding, used by filter templates and rank
+type Annotations struct {
+	Free *parser.SiteContext `json:"free,omitempty"`
+	Use  *parser.SiteContext `json:"use,omitempty"`
+
+	// What the use line does to the freed object; an indirect call is far worse than a read
+	UsePrimitive *parser.UsePrimitive `json:"use_primitive,omitempty"`
+
+	// Set when the freed type is reference counted or the chain takes or drops references
+	Refcount *RefcountContext `json:"refcount,omitempty"`
+
+	// Set when chain functions take locks or run as thread, work or callback entry points
+	Concurrency *ConcurrencyContext `json:"concurrency,omitempty"`
+
+	// Set when entry points are found in the source: whether attacker input reaches the finding
+	Entry *EntryReachability `json:"entry,omitempty"`
+
+	// Call graph position and complexity of the free and use functions
+	Metrics *FindingMetrics `json:"metrics,omitempty"`
+}
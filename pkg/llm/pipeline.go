@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/noperator/slice/pkg/codeql"
 	"github.com/noperator/slice/pkg/logging"
 )
 
@@ -24,6 +25,8 @@ type PipelineConfig struct {
 	Concurrency     int
 	PromptTemplate  string
 	OutputAll       bool
+	SkipPrefiltered bool   // Don't send findings the prefilter marked likely FP to the LLM
+	Context         string // "full" or "slice" function bodies, overriding the template's context
 }
 
 // NewPipeline creates a new LLM processing pipeline
@@ -171,6 +174,32 @@ func (p *Pipeline) getTemplateMetadata() (*TemplateMetadata, error) {
 	return ParseTemplateMetadata(p.config.PromptTemplate)
 }
 
+// contextMode returns whether function bodies are shown in full or sliced, from the
+// --context flag or else the template
+func (p *Pipeline) contextMode(metadata *TemplateMetadata) string {
+	if p.config.Context != "" {
+		return p.config.Context
+	}
+	return metadata.Context
+}
+
+// sliceContext replaces each function definition with its slice, keeping functions without one
+// whole
+func sliceContext(source codeql.SourceCode) codeql.SourceCode {
+	sliced := func(funcCode *codeql.FunctionCode) {
+		if funcCode.Slice != "" {
+			funcCode.DefinitionWithLineNumbers = funcCode.Slice
+		}
+	}
+	sliced(&source.FreeFunction)
+	sliced(&source.UseFunction)
+	source.IntermediateFunctions = append([]codeql.FunctionCode(nil), source.IntermediateFunctions...)
+	for i := range source.IntermediateFunctions {
+		sliced(&source.IntermediateFunctions[i])
+	}
+	return source
+}
+
 // processWithTemplate performs unified processing using the specified template
 func (p *Pipeline) processWithTemplate(ctx context.Context, input *UnifiedOutput, metadata *TemplateMetadata) (*UnifiedOutput, error) {
 	p.logger.Info("processing findings",
@@ -217,8 +246,47 @@ func (p *Pipeline) createUnifiedProcessor(metadata *TemplateMetadata) ProcessFun
 			return result, nil
 		}
 
+		// With --skip-prefiltered, skip the LLM for findings the static prefilter already ruled out
+		if result.Prefilter != nil && result.Prefilter.LikelyFP && p.config.SkipPrefiltered {
+			p.logger.Debug("skipping prefiltered finding",
+				"component", "analyzer",
+				"template_type", metadata.Type,
+				"reason", result.Prefilter.Reason)
+			result.SetDynamicResult(metadata.Type, map[string]interface{}{
+				"valid":       false,
+				"prefiltered": true,
+				"reason":      result.Prefilter.Reason,
+			})
+			return result, nil
+		}
+
+		// Pack the function code into the template's token budget and swap in slices when asked
+		// for; the output keeps it whole
+		slices := p.contextMode(metadata) == "slice"
+		packed := result
+		if slices {
+			packed.SourceCode = sliceContext(result.SourceCode)
+		}
+		if metadata.TokenBudget > 0 {
+			packed.SourceCode = codeql.PackContext(result.SourceCode, result.CodeQLResult, result.CallValidation,
+				codeql.PackOptions{Budget: metadata.TokenBudget, Slices: slices})
+			tokens := packed.SourceCode.Tokens
+			level := slog.LevelDebug
+			if tokens.Tokens > tokens.Budget {
+				level = slog.LevelWarn
+			}
+			p.logger.Log(ctx, level, "packed finding context",
+				"component", "analyzer",
+				"template_type", metadata.Type,
+				"free_func", result.CodeQLResult.FreeFunctionName,
+				"use_func", result.CodeQLResult.UseFunctionName,
+				"tokens", tokens.Tokens,
+				"token_budget", tokens.Budget,
+				"packed", tokens.Packed)
+		}
+
 		// Create unified request
-		request := p.createCodeQLRequest(result)
+		request := p.createCodeQLRequest(packed)
 
 		// Process using unified analyzer method
 		response, err := p.analyzer.ProcessCodeQLFinding(ctx, request, p.config.PromptTemplate)
@@ -245,11 +313,15 @@ func (p *Pipeline) createUnifiedProcessor(metadata *TemplateMetadata) ProcessFun
 // createCodeQLRequest creates a unified request from a unified result
 func (p *Pipeline) createCodeQLRequest(result UnifiedResult) CodeQLRequest {
 	// Use all call chains from validation if available, otherwise create simple chain
-	var callChains [][]string
+	var callChains []codeql.CallChain
 	if result.CallValidation != nil && len(result.CallValidation.CallChains) > 0 {
 		callChains = result.CallValidation.CallChains
 	} else {
-		callChains = [][]string{{result.CodeQLResult.FreeFunctionName, result.CodeQLResult.UseFunctionName}}
+		callChains = []codeql.CallChain{{
+			Functions: []string{result.CodeQLResult.FreeFunctionName, result.CodeQLResult.UseFunctionName},
+			Edges:     []string{codeql.EdgeDirect},
+			Length:    1,
+		}}
 	}
 
 	// Extract all unique intermediate function definitions
@@ -262,6 +334,7 @@ func (p *Pipeline) createCodeQLRequest(result UnifiedResult) CodeQLRequest {
 		CodeQLResult:         result.CodeQLResult,
 		SourceCode:           result.SourceCode,
 		CallValidation:       result.CallValidation,
+		Annotations:          result.Annotations,
 		FreeFuncDef:          result.SourceCode.FreeFunction.DefinitionWithLineNumbers,
 		UseFuncDef:           result.SourceCode.UseFunction.DefinitionWithLineNumbers,
 		IntermediateFuncDefs: intermediateFuncDefs,
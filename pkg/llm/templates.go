@@ -8,6 +8,9 @@ import (
 	"path/filepath"
 	"strings"
 	"text/template"
+
+	"github.com/noperator/slice/pkg/codeql"
+	"github.com/noperator/slice/pkg/parser"
 )
 
 // CodeQLTemplateData holds the data for CodeQL template rendering
@@ -20,12 +23,24 @@ type codeQLTemplateData struct {
 	UseFunctionFile      string
 	UseLine              int
 	CallChain            []string     // For backward compatibility
-	CallChains           [][]string   // Multiple call chains
+	CallChains           []codeql.CallChain // Multiple call chains, with the kind of each call
 	FreeSnippet          string
 	UseSnippet           string
 	FreeFunctionDef      string
 	UseFunctionDef       string
 	IntermediateFuncDefs []string
+	FreeFunctionVars     []parser.Variable     // Params and locals of the free function, with allocation sites
+	UseFunctionVars      []parser.Variable     // Params and locals of the use function, with allocation sites
+	Global               *parser.Global        // Global the freed object lives in, if any
+	FreedType            string                // Resolved type of the freed object, e.g. "struct buf *"
+	TypeDefs             []parser.TypeDef      // Definitions of the freed type and the containers it was reached through
+	TypeReferrers        []codeql.TypeReferrer // Other struct members pointing at the freed type
+	FieldAccesses        []parser.FieldAccess  // Other places touching the freed struct member
+	Guards               []codeql.SiteGuards   // Conditions guarding the free, use and chain call sites
+	Annotations          *codeql.Annotations   // Static classifications of the free and use sites
+	Allocations          []codeql.AllocationSite // Where the freed object was allocated
+	ObjectFlows          []codeql.ObjectFlow   // How the freed object crosses each call of each chain
+	CommonCallers        []codeql.CommonCaller // Closest callers reaching both the free and the use, when neither calls the other
 	SchemaJSON           string       // Pretty-printed JSON schema for insertion into template
 }
 
@@ -62,12 +77,31 @@ func RenderCodeQLTemplate(request CodeQLRequest, customTemplatePath string) (str
 		FreeFunctionDef:      request.FreeFuncDef,
 		UseFunctionDef:       request.UseFuncDef,
 		IntermediateFuncDefs: request.IntermediateFuncDefs,
+		FreeFunctionVars:     request.SourceCode.FreeFunction.Vars,
+		UseFunctionVars:      request.SourceCode.UseFunction.Vars,
+		Global:               request.SourceCode.Global,
+		FreedType:            request.SourceCode.FreedType,
+		TypeDefs:             request.SourceCode.TypeDefinitions,
+		TypeReferrers:        request.SourceCode.TypeReferrers,
+		FieldAccesses:        request.SourceCode.FieldAccesses,
+		Annotations:          request.Annotations,
+		Allocations:          request.SourceCode.Allocations,
 	}
 
 	if len(data.CallChains) > 0 {
-		data.CallChain = data.CallChains[0]
+		data.CallChain = data.CallChains[0].Functions
+	}
+	if request.CallValidation != nil {
+		data.ObjectFlows = request.CallValidation.ObjectFlows
+		data.CommonCallers = request.CallValidation.Ancestors
 	}
 
+	data.Guards = append(data.Guards, request.SourceCode.FreeFunction.Guards...)
+	for _, funcCode := range request.SourceCode.IntermediateFunctions {
+		data.Guards = append(data.Guards, funcCode.Guards...)
+	}
+	data.Guards = append(data.Guards, request.SourceCode.UseFunction.Guards...)
+
 	if metadata.Schema != nil {
 		schemaBytes, err := json.MarshalIndent(convertSchemaToExample(metadata.Schema), "  ", "  ")
 		if err == nil {
@@ -99,6 +133,8 @@ type TemplateMetadata struct {
 	Timeout     int                    `json:"timeout"`
 	MaxTokens   int                    `json:"max_tokens"`
 	Temperature float32               `json:"temperature"`
+	Context     string                 `json:"context"` // "full" (default) or "slice" function bodies
+	TokenBudget int                    `json:"token_budget"` // Tokens of function code per finding, 0 = no limit
 }
 
 
@@ -154,6 +190,12 @@ func ParseTemplateMetadata(templatePath string) (*TemplateMetadata, error) {
 					if tempVal := parseFloat32(value); tempVal >= 0 {
 						metadata.Temperature = tempVal
 					}
+				case "context":
+					metadata.Context = value
+				case "token_budget":
+					if budgetVal := parseInt(value); budgetVal > 0 {
+						metadata.TokenBudget = budgetVal
+					}
 				}
 			}
 		}
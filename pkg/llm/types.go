@@ -12,10 +12,11 @@ type CodeQLRequest struct {
 	CodeQLResult         codeql.CodeQLResult    `json:"codeql_result"`
 	SourceCode           codeql.SourceCode      `json:"source_code"`
 	CallValidation       *codeql.CallValidation `json:"call_validation,omitempty"`
+	Annotations          *codeql.Annotations    `json:"annotations,omitempty"`
 	FreeFuncDef          string                 `json:"free_function_definition"`
 	UseFuncDef           string                 `json:"use_function_definition"`
 	IntermediateFuncDefs []string               `json:"intermediate_function_definitions"`
-	CallChains           [][]string             `json:"chains"`
+	CallChains           []codeql.CallChain     `json:"chains"`
 	FreeSnippet          string                 `json:"free_snippet"`
 	UseSnippet           string                 `json:"use_snippet"`
 }
@@ -29,6 +30,11 @@ type UnifiedResult struct {
 	// Optional call validation results (present when --validate-calls is enabled)
 	CallValidation *codeql.CallValidation `json:"calls,omitempty"`
 
+	// Optional static prefilter verdict (present unless --no-prefilter is set)
+	Prefilter *codeql.Prefilter `json:"prefilter,omitempty"`
+
+	// Static classifications of the free and use sites
+	Annotations *codeql.Annotations `json:"annotations,omitempty"`
 
 	// Optional ranking results (present after rank command)
 	Rank *RankInfo `json:"rank,omitempty"`
@@ -93,10 +99,12 @@ func (ur *UnifiedResult) UnmarshalJSON(data []byte) error {
 
 	// Known field names that should be handled by regular struct unmarshaling
 	knownFields := map[string]bool{
-		"query":   true,
-		"source":  true,
-		"calls":   true,
-		"rank":    true,
+		"query":       true,
+		"source":      true,
+		"calls":       true,
+		"prefilter":   true,
+		"annotations": true,
+		"rank":        true,
 	}
 
 	// Separate known and dynamic fields
@@ -275,4 +283,7 @@ type RankInfo struct {
 	Score    float64 `json:"score"`    // Ranking score from raink
 	Exposure int     `json:"exposure"` // How many times seen during ranking
 	Pos      int     `json:"pos"`      // 1-based rank position (1 = highest priority)
+
+	// Weighted contribution of each static feature, when ranked with --score
+	Features map[string]float64 `json:"features,omitempty"`
 }
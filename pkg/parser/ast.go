@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"fmt"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+	tree_sitter_c "github.com/tree-sitter/tree-sitter-c/bindings/go"
+)
+
+// functionAST is a re-parsed function definition, used by analyses that need the syntax tree
+// after the directory-wide parse has been cached. Callers must Close it.
+type functionAST struct {
+	parser  *sitter.Parser
+	tree    *sitter.Tree
+	content []byte
+	root    *sitter.Node // function_definition node, or the macro call of a SYSCALL_DEFINEn definition
+	body    *sitter.Node // compound_statement node
+	offset  int          // added to 0-based tree rows to get file line numbers
+}
+
+// parseFunction parses a function's definition text on its own, keeping file line numbers
+func parseFunction(function *Function) (*functionAST, error) {
+	if function == nil || function.Definition == "" {
+		return nil, fmt.Errorf("function has no definition")
+	}
+
+	parser := sitter.NewParser()
+	if err := parser.SetLanguage(sitter.NewLanguage(tree_sitter_c.Language())); err != nil {
+		parser.Close()
+		return nil, err
+	}
+
+	content := []byte(function.Definition)
+	tree := parser.Parse(content, nil)
+	if tree == nil {
+		parser.Close()
+		return nil, fmt.Errorf("failed to parse function: %s", function.ID)
+	}
+
+	ast := &functionAST{
+		parser:  parser,
+		tree:    tree,
+		content: content,
+		offset:  function.StartLine,
+	}
+
+	root := tree.RootNode()
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		if child := root.NamedChild(i); child.Kind() == "function_definition" {
+			ast.root = child
+			ast.body = child.ChildByFieldName("body")
+			break
+		}
+	}
+	if ast.body == nil && function.Macro != "" && root.NamedChildCount() >= 2 {
+		// SYSCALL_DEFINEn(...) { ... } parses as a call statement followed by a bare block
+		if body := root.NamedChild(1); body.Kind() == "compound_statement" {
+			ast.root = root.NamedChild(0)
+			ast.body = body
+		}
+	}
+	if ast.body == nil {
+		ast.Close()
+		return nil, fmt.Errorf("no function body found: %s", function.ID)
+	}
+
+	return ast, nil
+}
+
+// Close releases the tree-sitter resources held by the AST
+func (a *functionAST) Close() {
+	a.tree.Close()
+	a.parser.Close()
+}
+
+// text returns the source text of a node
+func (a *functionAST) text(node *sitter.Node) string {
+	return getNodeText(node, a.content)
+}
+
+// startLine returns the file line a node starts on
+func (a *functionAST) startLine(node *sitter.Node) int {
+	return int(node.StartPosition().Row) + a.offset
+}
+
+// endLine returns the file line a node ends on
+func (a *functionAST) endLine(node *sitter.Node) int {
+	return int(node.EndPosition().Row) + a.offset
+}
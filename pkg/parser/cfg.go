@@ -0,0 +1,387 @@
+package parser
+
+import (
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// CFG is a statement-level intraprocedural control-flow graph
+type CFG struct {
+	FunctionID string    `json:"func_id"`
+	Function   string    `json:"func"`
+	Entry      int       `json:"entry"`
+	Exit       int       `json:"exit"`
+	Nodes      []CFGNode `json:"nodes"`
+}
+
+// CFGNode is a simple statement, branch condition or label in a CFG
+type CFGNode struct {
+	ID      int       `json:"id"`
+	Kind    string    `json:"kind"` // entry, exit, stmt, cond, case, label, goto, break, continue, return, loop
+	Line    int       `json:"line"`
+	EndLine int       `json:"end"`
+	Text    string    `json:"text"`
+	Succs   []CFGEdge `json:"succs"`
+}
+
+// CFGEdge is a control-flow edge; Label is "true"/"false" for branches and the case value for switches
+type CFGEdge struct {
+	To    int    `json:"to"`
+	Label string `json:"label,omitempty"`
+}
+
+// BuildCFG builds the control-flow graph of a parsed function
+func BuildCFG(function *Function) (*CFG, error) {
+	ast, err := parseFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	defer ast.Close()
+
+	cfg, _ := buildCFG(ast, function)
+	return cfg, nil
+}
+
+// pendingEdge is an edge whose source is known but whose target has not been built yet
+type pendingEdge struct {
+	from  int
+	label string
+}
+
+// cfgBuilder translates a function body into a CFG
+type cfgBuilder struct {
+	ast       *functionAST
+	cfg       *CFG
+	nodes     []*sitter.Node // syntax node behind each CFG node, nil for synthetic nodes
+	breaks    [][]pendingEdge
+	continues [][]pendingEdge
+	labels    map[string]int
+	gotos     map[int]string
+}
+
+// buildCFG builds the CFG of a function and returns the syntax node behind each CFG node,
+// which stays valid until the AST is closed
+func buildCFG(ast *functionAST, function *Function) (*CFG, []*sitter.Node) {
+	b := &cfgBuilder{
+		ast:    ast,
+		cfg:    &CFG{FunctionID: function.ID, Function: function.Name},
+		labels: make(map[string]int),
+		gotos:  make(map[int]string),
+	}
+
+	b.cfg.Entry = b.addNode("entry", nil, ast.startLine(ast.root), "")
+	exits := b.build(ast.body, []pendingEdge{{from: b.cfg.Entry}})
+	b.cfg.Exit = b.addNode("exit", nil, ast.endLine(ast.body), "")
+	b.connect(exits, b.cfg.Exit)
+
+	// Patch gotos now that every label is known; unknown labels leave the function
+	for from, label := range b.gotos {
+		to, ok := b.labels[label]
+		if !ok {
+			to = b.cfg.Exit
+		}
+		b.cfg.Nodes[from].Succs = append(b.cfg.Nodes[from].Succs, CFGEdge{To: to})
+	}
+
+	return b.cfg, b.nodes
+}
+
+func (b *cfgBuilder) addNode(kind string, node *sitter.Node, line int, text string) int {
+	id := len(b.cfg.Nodes)
+	endLine := line
+	if node != nil {
+		endLine = b.ast.endLine(node)
+	}
+	b.cfg.Nodes = append(b.cfg.Nodes, CFGNode{
+		ID:      id,
+		Kind:    kind,
+		Line:    line,
+		EndLine: endLine,
+		Text:    text,
+		Succs:   []CFGEdge{},
+	})
+	b.nodes = append(b.nodes, node)
+	return id
+}
+
+// addStatement adds a node for a syntax node, using its text and lines
+func (b *cfgBuilder) addStatement(kind string, node *sitter.Node) int {
+	return b.addNode(kind, node, b.ast.startLine(node), strings.TrimSpace(b.ast.text(node)))
+}
+
+func (b *cfgBuilder) connect(preds []pendingEdge, to int) {
+	for _, pred := range preds {
+		b.cfg.Nodes[pred.from].Succs = append(b.cfg.Nodes[pred.from].Succs, CFGEdge{To: to, Label: pred.label})
+	}
+}
+
+// build adds the nodes for a statement, wiring preds into it, and returns its fall-through exits
+func (b *cfgBuilder) build(node *sitter.Node, preds []pendingEdge) []pendingEdge {
+	if node == nil {
+		return preds
+	}
+
+	switch node.Kind() {
+	case "compound_statement", "preproc_if", "preproc_ifdef", "preproc_else", "preproc_elif", "attributed_statement":
+		// Preprocessor branches are approximated as running in sequence
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			field := node.FieldNameForNamedChild(uint32(i))
+			if field == "condition" || field == "name" || child.Kind() == "comment" || child.Kind() == "attribute_declaration" {
+				continue
+			}
+			preds = b.build(child, preds)
+		}
+		return preds
+
+	case "if_statement":
+		cond := b.addStatement("cond", node.ChildByFieldName("condition"))
+		b.connect(preds, cond)
+		exits := b.build(node.ChildByFieldName("consequence"), []pendingEdge{{from: cond, label: "true"}})
+		if alternative := node.ChildByFieldName("alternative"); alternative != nil {
+			exits = append(exits, b.build(alternative, []pendingEdge{{from: cond, label: "false"}})...)
+		} else {
+			exits = append(exits, pendingEdge{from: cond, label: "false"})
+		}
+		return exits
+
+	case "else_clause":
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			preds = b.build(node.NamedChild(i), preds)
+		}
+		return preds
+
+	case "while_statement":
+		cond := b.addStatement("cond", node.ChildByFieldName("condition"))
+		b.connect(preds, cond)
+		b.pushLoop()
+		bodyExits := b.build(node.ChildByFieldName("body"), []pendingEdge{{from: cond, label: "true"}})
+		breaks, continues := b.popLoop()
+		b.connect(append(bodyExits, continues...), cond)
+		return append(breaks, pendingEdge{from: cond, label: "false"})
+
+	case "do_statement":
+		head := b.addNode("loop", nil, b.ast.startLine(node), "do")
+		b.connect(preds, head)
+		b.pushLoop()
+		bodyExits := b.build(node.ChildByFieldName("body"), []pendingEdge{{from: head}})
+		breaks, continues := b.popLoop()
+		cond := b.addStatement("cond", node.ChildByFieldName("condition"))
+		b.connect(append(bodyExits, continues...), cond)
+		b.connect([]pendingEdge{{from: cond, label: "true"}}, head)
+		return append(breaks, pendingEdge{from: cond, label: "false"})
+
+	case "for_statement":
+		if initializer := node.ChildByFieldName("initializer"); initializer != nil {
+			init := b.addStatement("stmt", initializer)
+			b.connect(preds, init)
+			preds = []pendingEdge{{from: init}}
+		}
+		var head int
+		if condition := node.ChildByFieldName("condition"); condition != nil {
+			head = b.addStatement("cond", condition)
+		} else {
+			head = b.addNode("loop", nil, b.ast.startLine(node), "for")
+		}
+		b.connect(preds, head)
+		b.pushLoop()
+		bodyExits := b.build(node.ChildByFieldName("body"), []pendingEdge{{from: head, label: "true"}})
+		breaks, continues := b.popLoop()
+		bodyExits = append(bodyExits, continues...)
+		if update := node.ChildByFieldName("update"); update != nil {
+			step := b.addStatement("stmt", update)
+			b.connect(bodyExits, step)
+			bodyExits = []pendingEdge{{from: step}}
+		}
+		b.connect(bodyExits, head)
+		if node.ChildByFieldName("condition") == nil {
+			return breaks
+		}
+		return append(breaks, pendingEdge{from: head, label: "false"})
+
+	case "switch_statement":
+		cond := b.addStatement("cond", node.ChildByFieldName("condition"))
+		b.connect(preds, cond)
+		b.breaks = append(b.breaks, nil)
+		var caseExits []pendingEdge
+		hasDefault := false
+		if body := node.ChildByFieldName("body"); body != nil {
+			for i := uint(0); i < body.NamedChildCount(); i++ {
+				child := body.NamedChild(i)
+				if child.Kind() != "case_statement" {
+					caseExits = b.build(child, caseExits)
+					continue
+				}
+				label := "default"
+				if value := child.ChildByFieldName("value"); value != nil {
+					label = b.ast.text(value)
+				} else {
+					hasDefault = true
+				}
+				caseNode := b.addNode("case", child, b.ast.startLine(child), caseHeader(b.ast.text(child)))
+				b.cfg.Nodes[caseNode].EndLine = b.cfg.Nodes[caseNode].Line
+				b.connect(append(caseExits, pendingEdge{from: cond, label: label}), caseNode)
+				caseExits = []pendingEdge{{from: caseNode}}
+				for j := uint(0); j < child.NamedChildCount(); j++ {
+					if child.FieldNameForNamedChild(uint32(j)) == "value" {
+						continue
+					}
+					caseExits = b.build(child.NamedChild(j), caseExits)
+				}
+			}
+		}
+		breaks := b.breaks[len(b.breaks)-1]
+		b.breaks = b.breaks[:len(b.breaks)-1]
+		exits := append(breaks, caseExits...)
+		if !hasDefault {
+			exits = append(exits, pendingEdge{from: cond, label: "default"})
+		}
+		return exits
+
+	case "break_statement":
+		id := b.addStatement("break", node)
+		b.connect(preds, id)
+		if len(b.breaks) > 0 {
+			b.breaks[len(b.breaks)-1] = append(b.breaks[len(b.breaks)-1], pendingEdge{from: id})
+		}
+		return nil
+
+	case "continue_statement":
+		id := b.addStatement("continue", node)
+		b.connect(preds, id)
+		if len(b.continues) > 0 {
+			b.continues[len(b.continues)-1] = append(b.continues[len(b.continues)-1], pendingEdge{from: id})
+		}
+		return nil
+
+	case "return_statement":
+		id := b.addStatement("return", node)
+		b.connect(preds, id)
+		b.gotos[id] = "" // resolved to the exit node once it exists
+		return nil
+
+	case "goto_statement":
+		id := b.addStatement("goto", node)
+		b.connect(preds, id)
+		if label := node.ChildByFieldName("label"); label != nil {
+			b.gotos[id] = b.ast.text(label)
+		}
+		return nil
+
+	case "labeled_statement":
+		name := ""
+		if label := node.ChildByFieldName("label"); label != nil {
+			name = b.ast.text(label)
+		}
+		id := b.addNode("label", node, b.ast.startLine(node), name+":")
+		b.cfg.Nodes[id].EndLine = b.cfg.Nodes[id].Line
+		b.connect(preds, id)
+		b.labels[name] = id
+		preds = []pendingEdge{{from: id}}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			if node.FieldNameForNamedChild(uint32(i)) == "label" {
+				continue
+			}
+			preds = b.build(node.NamedChild(i), preds)
+		}
+		return preds
+
+	case "comment", "preproc_include", "preproc_def", "preproc_function_def", "preproc_call":
+		return preds
+
+	default:
+		id := b.addStatement("stmt", node)
+		b.connect(preds, id)
+		return []pendingEdge{{from: id}}
+	}
+}
+
+func (b *cfgBuilder) pushLoop() {
+	b.breaks = append(b.breaks, nil)
+	b.continues = append(b.continues, nil)
+}
+
+func (b *cfgBuilder) popLoop() (breaks, continues []pendingEdge) {
+	breaks = b.breaks[len(b.breaks)-1]
+	continues = b.continues[len(b.continues)-1]
+	b.breaks = b.breaks[:len(b.breaks)-1]
+	b.continues = b.continues[:len(b.continues)-1]
+	return breaks, continues
+}
+
+// caseHeader returns the "case X:" part of a case statement
+func caseHeader(text string) string {
+	if i := strings.Index(text, ":"); i >= 0 {
+		return strings.TrimSpace(text[:i+1])
+	}
+	return strings.TrimSpace(text)
+}
+
+// NodeAtLine returns the innermost node covering a line, or -1 if none does
+func (c *CFG) NodeAtLine(line int) int {
+	best := -1
+	for _, node := range c.Nodes {
+		if node.Kind == "entry" || node.Kind == "exit" || line < node.Line || line > node.EndLine {
+			continue
+		}
+		if best < 0 || node.EndLine-node.Line < c.Nodes[best].EndLine-c.Nodes[best].Line {
+			best = node.ID
+		}
+	}
+	return best
+}
+
+// Predecessors returns the predecessor list of every node
+func (c *CFG) Predecessors() [][]int {
+	preds := make([][]int, len(c.Nodes))
+	for _, node := range c.Nodes {
+		for _, edge := range node.Succs {
+			preds[edge.To] = append(preds[edge.To], node.ID)
+		}
+	}
+	return preds
+}
+
+// Reachable reports whether there is a non-empty path from one node to another
+func (c *CFG) Reachable(from, to int) bool {
+	visited := make([]bool, len(c.Nodes))
+	stack := []int{from}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, edge := range c.Nodes[current].Succs {
+			if edge.To == to {
+				return true
+			}
+			if !visited[edge.To] {
+				visited[edge.To] = true
+				stack = append(stack, edge.To)
+			}
+		}
+	}
+	return false
+}
+
+// LineReaches reports whether execution can flow from the statement on one line to the
+// statement on another, i.e. whether fromLine precedes toLine on some path
+func (c *CFG) LineReaches(fromLine, toLine int) bool {
+	from, to := c.NodeAtLine(fromLine), c.NodeAtLine(toLine)
+	if from < 0 || to < 0 {
+		return false
+	}
+	return c.Reachable(from, to)
+}
+
+// CyclomaticComplexity returns the number of independent paths through the function: one plus
+// the extra successors of every branching node. Short-circuit operators inside a condition do
+// not count, since the CFG does not split them.
+func (c *CFG) CyclomaticComplexity() int {
+	complexity := 1
+	for _, node := range c.Nodes {
+		if len(node.Succs) > 1 {
+			complexity += len(node.Succs) - 1
+		}
+	}
+	return complexity
+}
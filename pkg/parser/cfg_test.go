@@ -0,0 +1,117 @@
+package parser
+
+import "testing"
+
+const cfgSource = `int branch(int a)
+{
+	int x = 0;
+	if (a)
+		x = 1;
+	else
+		x = 2;
+	return x;
+}
+int loop(int n)
+{
+	int s = 0;
+	for (int i = 0; i < n; i++) {
+		if (i == 3)
+			break;
+		s += i;
+	}
+	return s;
+}
+int early(int *p)
+{
+	if (!p)
+		return -1;
+	*p = 1;
+	return 0;
+}
+int cleanup(int *p)
+{
+	if (!p)
+		goto out;
+	*p = 2;
+out:
+	return 0;
+}
+int cases(int k)
+{
+	switch (k) {
+	case 1:
+		return 10;
+	case 2:
+		k++;
+	default:
+		k--;
+	}
+	return k;
+}
+`
+
+func TestLineReaches(t *testing.T) {
+	functions := parseC(t, cfgSource)
+	tests := []struct {
+		function string
+		from, to int
+		want     bool
+	}{
+		{"branch", 5, 8, true},  // then branch reaches the return
+		{"branch", 5, 7, false}, // then and else are exclusive
+		{"branch", 7, 5, false},
+		{"loop", 16, 15, true},   // the loop body reaches the next iteration's check
+		{"loop", 18, 13, false},  // nothing after the loop flows back into it
+		{"early", 23, 24, false}, // the early return skips the store
+		{"early", 22, 24, true},
+		{"cleanup", 31, 33, true}, // goto jumps to the label
+		{"cleanup", 30, 32, true},
+		{"cases", 39, 41, false}, // case 1 returns
+		{"cases", 41, 43, true},  // case 2 falls through into default
+		{"cases", 43, 45, true},
+	}
+	for _, tt := range tests {
+		cfg, err := BuildCFG(functions[tt.function])
+		if err != nil {
+			t.Fatalf("BuildCFG(%s): %v", tt.function, err)
+		}
+		if got := cfg.LineReaches(tt.from, tt.to); got != tt.want {
+			t.Errorf("%s: LineReaches(%d, %d) = %v, want %v", tt.function, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestCyclomaticComplexity(t *testing.T) {
+	functions := parseC(t, cfgSource)
+	tests := []struct {
+		function string
+		want     int
+	}{
+		{"branch", 2},
+		{"loop", 3},
+		{"early", 2},
+		{"cleanup", 2},
+		{"cases", 3},
+	}
+	for _, tt := range tests {
+		cfg, err := BuildCFG(functions[tt.function])
+		if err != nil {
+			t.Fatalf("BuildCFG(%s): %v", tt.function, err)
+		}
+		if got := cfg.CyclomaticComplexity(); got != tt.want {
+			t.Errorf("%s: CyclomaticComplexity() = %d, want %d", tt.function, got, tt.want)
+		}
+	}
+}
+
+func TestCFGEntryReachesExit(t *testing.T) {
+	for name, function := range parseC(t, cfgSource) {
+		cfg, err := BuildCFG(function)
+		if err != nil {
+			t.Fatalf("BuildCFG(%s): %v", name, err)
+		}
+		if !cfg.Reachable(cfg.Entry, cfg.Exit) {
+			t.Errorf("%s: exit not reachable from entry", name)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package parser
+
+import (
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Definition is a statement giving a variable or object expression a new value
+type Definition struct {
+	Node   int    `json:"node"`
+	Line   int    `json:"line"`
+	Target string `json:"target"`           // Normalized lvalue, e.g. "c->data"
+	Value  string `json:"value,omitempty"`  // Assigned expression; empty for frees
+	Source string `json:"source,omitempty"` // Normalized lvalue the value was copied from, for aliasing
+	Kind   string `json:"kind"`             // assign, decl or free
+}
+
+// Dataflow holds a function's CFG and the definitions made by each of its nodes
+type Dataflow struct {
+	CFG      *CFG         `json:"cfg"`
+	Defs     []Definition `json:"defs"`
+	nodeDefs [][]int
+}
+
+// AnalyzeDataflow builds a function's CFG and collects its definitions. Compound assignments
+// and increments keep pointing into the same object so they are not treated as definitions.
+func AnalyzeDataflow(function *Function) (*Dataflow, error) {
+	ast, err := parseFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	defer ast.Close()
+
+	d, _ := analyzeDataflow(ast, function)
+	return d, nil
+}
+
+// analyzeDataflow builds the dataflow of a parsed function and returns the syntax node
+// behind each CFG node, which stays valid until the AST is closed
+func analyzeDataflow(ast *functionAST, function *Function) (*Dataflow, []*sitter.Node) {
+	cfg, syntax := buildCFG(ast, function)
+	d := &Dataflow{CFG: cfg, nodeDefs: make([][]int, len(cfg.Nodes))}
+	for id, node := range syntax {
+		switch cfg.Nodes[id].Kind {
+		case "stmt", "cond", "return":
+			d.collectDefs(ast, id, node)
+		}
+	}
+
+	return d, syntax
+}
+
+// collectDefs records the definitions made anywhere inside a CFG node's syntax
+func (d *Dataflow) collectDefs(ast *functionAST, id int, node *sitter.Node) {
+	if node == nil {
+		return
+	}
+
+	add := func(target *sitter.Node, value *sitter.Node, kind string) {
+		if target == nil {
+			return
+		}
+		text, source := "", ""
+		if value != nil {
+			text = ast.text(value)
+			if isLvalue(unwrapExpression(value)) {
+				source = NormalizeTarget(ast.text(unwrapExpression(value)))
+			}
+		}
+		d.nodeDefs[id] = append(d.nodeDefs[id], len(d.Defs))
+		d.Defs = append(d.Defs, Definition{
+			Node:   id,
+			Line:   ast.startLine(target),
+			Target: NormalizeTarget(ast.text(unwrapExpression(target))),
+			Value:  strings.TrimSpace(text),
+			Source: source,
+			Kind:   kind,
+		})
+	}
+
+	switch node.Kind() {
+	case "assignment_expression":
+		if operator := node.ChildByFieldName("operator"); operator != nil && ast.text(operator) == "=" {
+			// Definitions inside the right-hand side happen first
+			d.collectDefs(ast, id, node.ChildByFieldName("right"))
+			add(node.ChildByFieldName("left"), node.ChildByFieldName("right"), "assign")
+			return
+		}
+	case "init_declarator":
+		if value := node.ChildByFieldName("value"); value != nil {
+			d.collectDefs(ast, id, value)
+			name := node.ChildByFieldName("declarator")
+			for name != nil && name.Kind() != "identifier" {
+				name = name.ChildByFieldName("declarator")
+			}
+			add(name, value, "decl")
+			return
+		}
+	case "call_expression":
+		if function := node.ChildByFieldName("function"); function != nil && function.Kind() == "identifier" && IsDeallocator(ast.text(function)) {
+			if args := node.ChildByFieldName("arguments"); args != nil && args.NamedChildCount() > 0 {
+				d.collectDefs(ast, id, args)
+				add(args.NamedChild(0), nil, "free")
+				return
+			}
+		}
+	}
+
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		d.collectDefs(ast, id, node.NamedChild(i))
+	}
+}
+
+// unwrapExpression strips casts and parentheses around an expression
+func unwrapExpression(node *sitter.Node) *sitter.Node {
+	for node != nil {
+		switch node.Kind() {
+		case "cast_expression":
+			node = node.ChildByFieldName("value")
+		case "parenthesized_expression":
+			node = node.NamedChild(0)
+		default:
+			return node
+		}
+	}
+	return node
+}
+
+// isLvalue reports whether an expression names an object rather than computing a value
+func isLvalue(node *sitter.Node) bool {
+	if node == nil {
+		return false
+	}
+	switch node.Kind() {
+	case "identifier", "field_expression", "subscript_expression", "pointer_expression":
+		return true
+	}
+	return false
+}
+
+// DefsAt returns the definitions made by a CFG node
+func (d *Dataflow) DefsAt(node int) []Definition {
+	defs := make([]Definition, 0, len(d.nodeDefs[node]))
+	for _, i := range d.nodeDefs[node] {
+		defs = append(defs, d.Defs[i])
+	}
+	return defs
+}
+
+// ReachingDefinitions returns, for every CFG node, the indexes into Defs that reach its entry.
+// A definition is killed by any later definition of the same target or of an expression it
+// is reached through, e.g. "c = NULL" kills "free(c->data)".
+func (d *Dataflow) ReachingDefinitions() [][]int {
+	nodes := d.CFG.Nodes
+	in := make([][]bool, len(nodes))
+	out := make([][]bool, len(nodes))
+	for i := range nodes {
+		in[i] = make([]bool, len(d.Defs))
+		out[i] = make([]bool, len(d.Defs))
+	}
+	preds := d.CFG.Predecessors()
+
+	for changed := true; changed; {
+		changed = false
+		for id := range nodes {
+			for _, pred := range preds[id] {
+				for def, reaches := range out[pred] {
+					if reaches && !in[id][def] {
+						in[id][def] = true
+					}
+				}
+			}
+
+			next := make([]bool, len(d.Defs))
+			copy(next, in[id])
+			for _, gen := range d.nodeDefs[id] {
+				for def := range next {
+					if next[def] && TargetCovers(d.Defs[gen].Target, d.Defs[def].Target) {
+						next[def] = false
+					}
+				}
+				next[gen] = true
+			}
+
+			for def := range next {
+				if next[def] != out[id][def] {
+					out[id] = next
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	reaching := make([][]int, len(nodes))
+	for id := range nodes {
+		for def, reaches := range in[id] {
+			if reaches {
+				reaching[id] = append(reaching[id], def)
+			}
+		}
+	}
+	return reaching
+}
+
+// NormalizeTarget strips whitespace, casts and wrapping parentheses from an lvalue
+func NormalizeTarget(expr string) string {
+	expr = strings.Join(strings.Fields(expr), "")
+	for strings.HasPrefix(expr, "(") && closingParen(expr, 0) == len(expr)-1 {
+		expr = expr[1 : len(expr)-1]
+	}
+	return expr
+}
+
+// StripCasts removes leading C casts such as "(struct buf *)" or "(void *)" from an expression
+func StripCasts(expr string) string {
+	expr = strings.TrimSpace(expr)
+	for strings.HasPrefix(expr, "(") {
+		end := strings.Index(expr, ")")
+		if end < 0 || !isCastType(expr[1:end]) {
+			break
+		}
+		expr = strings.TrimSpace(expr[end+1:])
+	}
+	return expr
+}
+
+// isCastType reports whether the text inside leading parentheses names a type, e.g. "struct foo *"
+func isCastType(s string) bool {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"struct ", "union ", "const ", "unsigned ", "void"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(s, "*")
+}
+
+// closingParen returns the index of the parenthesis closing the one at open, or -1
+func closingParen(expr string, open int) int {
+	depth := 0
+	for i := open; i < len(expr); i++ {
+		switch expr[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// TargetCovers reports whether assigning target also replaces path, i.e. path is target
+// itself or is reached through it ("c" covers "c->data", "c.buf[0]" and "*c")
+func TargetCovers(target, path string) bool {
+	target, path = NormalizeTarget(target), NormalizeTarget(path)
+	if target == path {
+		return true
+	}
+	deref := strings.TrimLeft(path, "*")
+	if deref != path && NormalizeTarget(deref) == target {
+		return true
+	}
+	path = NormalizeTarget(deref)
+	if !strings.HasPrefix(path, target) {
+		return false
+	}
+	rest := path[len(target):]
+	return strings.HasPrefix(rest, "->") || strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, "[")
+}
+
+// IsNullValue reports whether an assigned expression is a null pointer constant
+func IsNullValue(value string) bool {
+	value = NormalizeTarget(value)
+	for {
+		trimmed := strings.TrimPrefix(value, "(void*)")
+		trimmed = NormalizeTarget(trimmed)
+		if trimmed == value {
+			break
+		}
+		value = trimmed
+	}
+	return value == "NULL" || value == "0" || value == "nullptr" || value == "0L"
+}
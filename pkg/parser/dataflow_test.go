@@ -0,0 +1,113 @@
+package parser
+
+import "testing"
+
+func TestNormalizeTarget(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"c->buf", "c->buf"},
+		{" ( c -> buf ) ", "c->buf"},
+		{"((p))", "p"},
+		{"(a)+(b)", "(a)+(b)"},
+		{"((a)+(b))", "(a)+(b)"},
+		{"(*c).buf", "(*c).buf"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeTarget(tt.expr); got != tt.want {
+			t.Errorf("NormalizeTarget(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestStripCasts(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"(struct buf *)p", "p"},
+		{" (void *) (char *)q->data", "q->data"},
+		{"(unsigned long)n", "n"},
+		{"(a + b) * c", "(a + b) * c"},
+		{"(p)", "(p)"},
+	}
+	for _, tt := range tests {
+		if got := StripCasts(tt.expr); got != tt.want {
+			t.Errorf("StripCasts(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestTargetCovers(t *testing.T) {
+	tests := []struct {
+		target, path string
+		want         bool
+	}{
+		{"c", "c", true},
+		{"c", "*c", true},
+		{"c", "**c", true},
+		{"c", "c->data", true},
+		{"c", "c.buf[0]", true},
+		{"c", "*c->data", true},
+		{"(c)", "c->data", true},
+		{"c->buf", "c->buf", true},
+		{"c->buf", "c", false},
+		{"c", "cb", false},
+		{"c", "cb->data", false},
+		{"c->buf", "c->buffer", false},
+	}
+	for _, tt := range tests {
+		if got := TargetCovers(tt.target, tt.path); got != tt.want {
+			t.Errorf("TargetCovers(%q, %q) = %v, want %v", tt.target, tt.path, got, tt.want)
+		}
+	}
+}
+
+const reachingSource = `void reaching(int *p, int a)
+{
+	int x = 1;
+	if (a)
+		x = 2;
+	*p = x;
+	x = 3;
+	*p = x;
+}
+`
+
+func TestReachingDefinitions(t *testing.T) {
+	function := parseC(t, reachingSource)["reaching"]
+	dataflow, err := AnalyzeDataflow(function)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line  int
+		lines []int // Lines of the definitions of x reaching the line
+	}{
+		{6, []int{3, 5}},
+		{8, []int{7}},
+	}
+	reaching := dataflow.ReachingDefinitions()
+	for _, tt := range tests {
+		node := dataflow.CFG.NodeAtLine(tt.line)
+		if node < 0 {
+			t.Fatalf("no node at line %d", tt.line)
+		}
+		got := make(map[int]bool)
+		for _, def := range reaching[node] {
+			if dataflow.Defs[def].Target == "x" {
+				got[dataflow.Defs[def].Line] = true
+			}
+		}
+		if len(got) != len(tt.lines) {
+			t.Errorf("line %d: definitions of x from lines %v, want %v", tt.line, got, tt.lines)
+			continue
+		}
+		for _, line := range tt.lines {
+			if !got[line] {
+				t.Errorf("line %d: definition of x from line %d does not reach it", tt.line, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package parser
+
+import (
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// FieldAccess records a function touching a struct or union member
+type FieldAccess struct {
+	StructType string `json:"struct"` // Resolved container type, e.g. "struct ctx"; empty when unknown
+	Field      string `json:"field"`
+	FunctionID string `json:"func_id"`
+	Function   string `json:"func"`
+	Kind       string `json:"kind"` // read, write, free, addr
+	Line       int    `json:"line"`
+	Expression string `json:"expr"`
+}
+
+// fieldRef is an unresolved field access; the container type is resolved once all files are parsed
+type fieldRef struct {
+	Root       string   // Base variable name, or a type name when RootIsType
+	RootIsType bool     // Set when the base is a cast such as ((struct foo *)p)
+	Path       []string // Fields between the root and the accessed field
+	Field      string
+	Kind       string
+	Line       int
+	Expression string
+}
+
+// findFieldRefs collects every field_expression in a function body
+func findFieldRefs(node *sitter.Node, content []byte) []fieldRef {
+	var refs []fieldRef
+
+	for i := uint(0); i < node.ChildCount(); i++ {
+		child := node.Child(i)
+
+		if child.Kind() == "field_expression" {
+			field := child.ChildByFieldName("field")
+			if field != nil {
+				root, rootIsType, path, ok := expressionRoot(child.ChildByFieldName("argument"), content)
+				if ok {
+					refs = append(refs, fieldRef{
+						Root:       root,
+						RootIsType: rootIsType,
+						Path:       path,
+						Field:      getNodeText(field, content),
+						Kind:       fieldAccessKind(child, content),
+						Line:       int(child.StartPosition().Row) + 1,
+						Expression: getNodeText(child, content),
+					})
+				}
+			}
+		}
+
+		refs = append(refs, findFieldRefs(child, content)...)
+	}
+
+	return refs
+}
+
+// expressionRoot breaks an lvalue-like expression into its root variable (or cast type) and field path
+func expressionRoot(node *sitter.Node, content []byte) (root string, rootIsType bool, path []string, ok bool) {
+	if node == nil {
+		return "", false, nil, false
+	}
+
+	switch node.Kind() {
+	case "identifier":
+		return getNodeText(node, content), false, nil, true
+	case "field_expression":
+		root, rootIsType, path, ok = expressionRoot(node.ChildByFieldName("argument"), content)
+		if field := node.ChildByFieldName("field"); ok && field != nil {
+			path = append(path, getNodeText(field, content))
+		}
+		return root, rootIsType, path, ok
+	case "parenthesized_expression":
+		return expressionRoot(node.NamedChild(0), content)
+	case "pointer_expression", "subscript_expression":
+		return expressionRoot(node.ChildByFieldName("argument"), content)
+	case "cast_expression":
+		if typeNode := node.ChildByFieldName("type"); typeNode != nil {
+			return NormalizeTypeName(getNodeText(typeNode, content)), true, nil, true
+		}
+	}
+
+	return "", false, nil, false
+}
+
+// fieldAccessKind classifies a field access, distinguishing address-taking from plain reads
+func fieldAccessKind(node *sitter.Node, content []byte) string {
+	if parent := node.Parent(); parent != nil && parent.Kind() == "pointer_expression" {
+		if operator := parent.ChildByFieldName("operator"); operator != nil && getNodeText(operator, content) == "&" {
+			return "addr"
+		}
+	}
+	return expressionAccessKind(node, content)
+}
+
+// indexFieldAccesses resolves the container type of every field reference
+func (r *AnalysisResult) indexFieldAccesses() {
+	r.FieldAccesses = []FieldAccess{}
+	r.accessIndex = make(map[string][]int)
+
+	for i := range r.Functions {
+		function := &r.Functions[i]
+
+		seen := make(map[FieldAccess]bool)
+		rootTypes := make(map[string]string)
+		for _, v := range function.Vars {
+			rootTypes[v.Name] = v.Type
+		}
+
+		for _, ref := range function.fieldRefs {
+			rootType := ref.Root
+			if !ref.RootIsType {
+				var ok bool
+				if rootType, ok = rootTypes[ref.Root]; !ok {
+					if global := r.LookupGlobal(ref.Root, function.Filename); global != nil {
+						rootType = global.Type
+					} else {
+						rootType = ""
+					}
+				}
+			}
+
+			structType := ""
+			if rootType != "" {
+				types := r.ResolveFieldPath(rootType, ref.Path)
+				if len(types) == len(ref.Path)+1 {
+					structType = r.CanonicalTypeName(types[len(types)-1])
+				}
+			}
+
+			access := FieldAccess{
+				StructType: structType,
+				Field:      ref.Field,
+				FunctionID: function.ID,
+				Function:   function.Name,
+				Kind:       ref.Kind,
+				Line:       ref.Line,
+				Expression: ref.Expression,
+			}
+			if !seen[access] {
+				seen[access] = true
+				r.accessIndex[function.ID] = append(r.accessIndex[function.ID], len(r.FieldAccesses))
+				r.FieldAccesses = append(r.FieldAccesses, access)
+			}
+		}
+		function.fieldRefs = nil
+	}
+}
+
+// ResolveFieldPath returns the type of the root and of each field along the path.
+// Resolution stops at the first field that cannot be found, so the result may be shorter than the path.
+func (r *AnalysisResult) ResolveFieldPath(rootType string, fields []string) []string {
+	types := []string{rootType}
+	for _, field := range fields {
+		member, err := r.FieldOf(rootType, field)
+		if err != nil {
+			break
+		}
+		rootType = member.Type
+		types = append(types, rootType)
+	}
+	return types
+}
+
+// CanonicalTypeName maps a type to the name of the definition declaring its fields,
+// so that "ctx_t *" and "struct ctx" compare equal
+func (r *AnalysisResult) CanonicalTypeName(typeName string) string {
+	if chain := r.ResolveType(typeName); len(chain) > 0 {
+		return chain[len(chain)-1].Name
+	}
+	return NormalizeTypeName(typeName)
+}
+
+// FieldAccessesIn returns the field accesses made by the function with an ID
+func (r *AnalysisResult) FieldAccessesIn(functionID string) []FieldAccess {
+	var accesses []FieldAccess
+	if r.accessIndex != nil {
+		for _, i := range r.accessIndex[functionID] {
+			accesses = append(accesses, r.FieldAccesses[i])
+		}
+		return accesses
+	}
+	for _, access := range r.FieldAccesses {
+		if access.FunctionID == functionID {
+			accesses = append(accesses, access)
+		}
+	}
+	return accesses
+}
+
+// FieldAccessesOf returns every access to a member of the given container type
+func (r *AnalysisResult) FieldAccessesOf(structType, field string) []FieldAccess {
+	structType = r.CanonicalTypeName(structType)
+
+	var accesses []FieldAccess
+	for _, access := range r.FieldAccesses {
+		if access.Field == field && access.StructType == structType {
+			accesses = append(accesses, access)
+		}
+	}
+	return accesses
+}
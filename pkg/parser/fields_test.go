@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+)
+
+const fieldSource = `struct buf { char *data; int len; };
+struct ctx { struct buf *buf; struct buf inline_buf; int refs; };
+typedef struct ctx ctx_t;
+void kfree(const void *p);
+void take(int *p);
+static ctx_t *global_ctx;
+void touch(ctx_t *c, void *opaque)
+{
+	c->refs = 1;
+	c->refs++;
+	take(&c->refs);
+	kfree(c->buf->data);
+	c->inline_buf.len = c->buf->len;
+	((struct ctx *)opaque)->refs = 0;
+	global_ctx->buf = 0;
+	unknown->field = 1;
+}
+`
+
+func TestIndexFieldAccesses(t *testing.T) {
+	result := analyzeC(t, fieldSource)
+
+	got := make(map[string]bool)
+	for _, access := range result.FieldAccesses {
+		got[fmt.Sprintf("%s.%s %s %d %s", access.StructType, access.Field, access.Kind, access.Line, access.Expression)] = true
+	}
+
+	tests := []string{
+		"struct ctx.refs write 9 c->refs",
+		"struct ctx.refs write 10 c->refs",
+		"struct ctx.refs addr 11 c->refs",
+		"struct ctx.buf read 12 c->buf",
+		"struct buf.data free 12 c->buf->data",
+		"struct buf.len write 13 c->inline_buf.len",
+		"struct ctx.inline_buf read 13 c->inline_buf",
+		"struct ctx.buf read 13 c->buf",
+		"struct buf.len read 13 c->buf->len",
+		"struct ctx.refs write 14 ((struct ctx *)opaque)->refs",
+		"struct ctx.buf write 15 global_ctx->buf",
+		".field write 16 unknown->field",
+	}
+	for _, want := range tests {
+		if !got[want] {
+			t.Errorf("missing field access %q", want)
+		}
+	}
+	if len(result.FieldAccesses) != len(tests) {
+		t.Errorf("got %d field accesses, want %d: %v", len(result.FieldAccesses), len(tests), got)
+	}
+}
+
+func TestFieldAccessesOf(t *testing.T) {
+	result := analyzeC(t, fieldSource)
+	tests := []struct {
+		structType, field string
+		lines             []int
+	}{
+		{"ctx_t", "refs", []int{9, 10, 11, 14}},
+		{"struct ctx *", "buf", []int{12, 13, 15}},
+		{"struct buf", "len", []int{13, 13}},
+		{"struct buf", "refs", nil},
+	}
+	for _, tt := range tests {
+		var lines []int
+		for _, access := range result.FieldAccessesOf(tt.structType, tt.field) {
+			lines = append(lines, access.Line)
+		}
+		if fmt.Sprint(lines) != fmt.Sprint(tt.lines) {
+			t.Errorf("FieldAccessesOf(%q, %q) lines = %v, want %v", tt.structType, tt.field, lines, tt.lines)
+		}
+	}
+}
+
+func TestFieldAccessesIn(t *testing.T) {
+	result := analyzeC(t, fieldSource)
+	function := result.LookupFunction("touch", "")
+	if function == nil {
+		t.Fatal("touch not parsed")
+	}
+	if got := result.FieldAccessesIn(function.ID); len(got) != len(result.FieldAccesses) {
+		t.Errorf("FieldAccessesIn(touch) = %d accesses, want %d", len(got), len(result.FieldAccesses))
+	}
+	if got := result.FieldAccessesIn("test.c:1:missing"); len(got) != 0 {
+		t.Errorf("FieldAccessesIn(missing) = %v, want none", got)
+	}
+}
@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// parseC parses a C source file and returns its functions by name
+func parseC(t *testing.T, source string) map[string]*Function {
+	t.Helper()
+	result := analyzeC(t, source)
+	functions := make(map[string]*Function)
+	for i := range result.Functions {
+		functions[result.Functions[i].Name] = &result.Functions[i]
+	}
+	return functions
+}
+
+// analyzeC analyzes a directory holding a single C source file
+func analyzeC(t *testing.T, source string) *AnalysisResult {
+	t.Helper()
+	return analyzeFiles(t, map[string]string{"test.c": source})
+}
+
+// analyzeFiles analyzes a directory holding the given C source files, keyed by file name
+func analyzeFiles(t *testing.T, files map[string]string) *AnalysisResult {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := analyzeDirectory(dir)
+	if err != nil {
+		t.Fatalf("analyzeDirectory: %v", err)
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// FunctionPointer records a function stored into a struct member, either by a designated
+// initializer such as "{ .read = dev_read }" or an assignment such as "ops->read = dev_read".
+// Calls through a member of that name may reach the function.
+type FunctionPointer struct {
+	Field    string `json:"field"`
+	Function string `json:"func"`
+	Filename string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// findFunctionPointers collects identifiers stored into struct members anywhere in a file.
+// Whether the identifier names a function is left to the caller, once every file is parsed.
+func findFunctionPointers(node *sitter.Node, content []byte, filename string) []FunctionPointer {
+	var pointers []FunctionPointer
+
+	for i := uint(0); i < node.ChildCount(); i++ {
+		child := node.Child(i)
+
+		var field, value *sitter.Node
+		switch child.Kind() {
+		case "initializer_pair":
+			if designators := child.ChildByFieldName("designator"); designators != nil && designators.Kind() == "field_designator" {
+				field = designators.NamedChild(0)
+			}
+			value = child.ChildByFieldName("value")
+		case "assignment_expression":
+			if left := child.ChildByFieldName("left"); left != nil && left.Kind() == "field_expression" {
+				field = left.ChildByFieldName("field")
+			}
+			value = child.ChildByFieldName("right")
+		}
+
+		if field != nil && value != nil {
+			name := strings.TrimLeft(getNodeText(unwrapExpression(value), content), "&")
+			if isIdentifier(name) {
+				pointers = append(pointers, FunctionPointer{
+					Field:    getNodeText(field, content),
+					Function: name,
+					Filename: filename,
+					Line:     int(child.StartPosition().Row) + 1,
+				})
+			}
+		}
+
+		pointers = append(pointers, findFunctionPointers(child, content, filename)...)
+	}
+
+	return pointers
+}
+
+// CalledField returns the struct member a call goes through, e.g. "read" for "dev->ops->read"
+// or "(*ops->read)", or "" for a direct call
+func CalledField(callee string) string {
+	callee = strings.Trim(strings.TrimSpace(callee), "()*")
+	callee = strings.ReplaceAll(callee, "->", ".")
+	i := strings.LastIndex(callee, ".")
+	if i < 0 {
+		return ""
+	}
+	field := callee[i+1:]
+	if !isIdentifier(field) {
+		return ""
+	}
+	return field
+}
@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Global is a file-scope variable together with the functions that touch it
+type Global struct {
+	Variable
+	ID       string         `json:"id"`
+	Filename string         `json:"file"`
+	Accesses []GlobalAccess `json:"accesses,omitempty"`
+}
+
+// GlobalAccess records a read, write or free of a global inside a function
+type GlobalAccess struct {
+	FunctionID string `json:"func_id"`
+	Function   string `json:"func"`
+	Kind       string `json:"kind"` // read, write, free
+	Line       int    `json:"line"`
+}
+
+// identifierRef is a reference to a non-local identifier inside a function body,
+// kept until all files are parsed and the global index can be resolved
+type identifierRef struct {
+	Name string
+	Kind string
+	Line int
+}
+
+// findGlobalDeclarations finds file-scope variable declarations, looking through preprocessor blocks
+func findGlobalDeclarations(node *sitter.Node, content []byte, filename string) []Global {
+	var globals []Global
+
+	for i := uint(0); i < node.ChildCount(); i++ {
+		child := node.Child(i)
+		switch child.Kind() {
+		case "declaration":
+			for _, v := range extractVariableDeclaration(child, content, "global") {
+				globals = append(globals, Global{
+					Variable: v,
+					ID:       fmt.Sprintf("%s:%d:%s", filename, v.Line, v.Name),
+					Filename: filename,
+				})
+			}
+		case "preproc_if", "preproc_ifdef", "preproc_else", "preproc_elif", "linkage_specification", "declaration_list":
+			globals = append(globals, findGlobalDeclarations(child, content, filename)...)
+		}
+	}
+
+	return globals
+}
+
+// findIdentifierRefs collects references to identifiers that are not params or locals of the function
+func findIdentifierRefs(node *sitter.Node, content []byte, locals map[string]bool) []identifierRef {
+	var refs []identifierRef
+
+	for i := uint(0); i < node.ChildCount(); i++ {
+		child := node.Child(i)
+
+		if child.Kind() == "identifier" {
+			name := getNodeText(child, content)
+			if !locals[name] && !isCalleeName(child) {
+				refs = append(refs, identifierRef{
+					Name: name,
+					Kind: expressionAccessKind(child, content),
+					Line: int(child.StartPosition().Row) + 1,
+				})
+			}
+		}
+
+		refs = append(refs, findIdentifierRefs(child, content, locals)...)
+	}
+
+	return refs
+}
+
+// isCalleeName reports whether an identifier is the function being called in a call expression
+func isCalleeName(node *sitter.Node) bool {
+	parent := node.Parent()
+	if parent == nil || parent.Kind() != "call_expression" {
+		return false
+	}
+	function := parent.ChildByFieldName("function")
+	return function != nil && function.Id() == node.Id()
+}
+
+// expressionAccessKind classifies how an identifier or field is used: written, freed or read
+func expressionAccessKind(node *sitter.Node, content []byte) string {
+	parent := node.Parent()
+	if parent == nil {
+		return "read"
+	}
+
+	switch parent.Kind() {
+	case "assignment_expression":
+		if left := parent.ChildByFieldName("left"); left != nil && left.Id() == node.Id() {
+			return "write"
+		}
+	case "update_expression":
+		return "write"
+	}
+
+	// Walk out of casts and parentheses to see if the value is passed to a deallocator
+	expr := node
+	for expr.Parent() != nil && (expr.Parent().Kind() == "cast_expression" || expr.Parent().Kind() == "parenthesized_expression") {
+		expr = expr.Parent()
+	}
+	if argList := expr.Parent(); argList != nil && argList.Kind() == "argument_list" {
+		if call := argList.Parent(); call != nil && call.Kind() == "call_expression" {
+			function := call.ChildByFieldName("function")
+			if function != nil && function.Kind() == "identifier" && IsDeallocator(getNodeText(function, content)) {
+				return "free"
+			}
+		}
+	}
+
+	return "read"
+}
+
+// indexGlobals deduplicates global declarations and resolves which functions access each one.
+// Non-static globals are matched by name across files, static globals only within their own file.
+func indexGlobals(globals []Global, functions []Function) []Global {
+	type globalKey struct {
+		name string
+		file string // empty for globals with external linkage
+	}
+
+	keyFor := func(g Global) globalKey {
+		if g.Storage == "static" {
+			return globalKey{name: g.Name, file: g.Filename}
+		}
+		return globalKey{name: g.Name}
+	}
+
+	index := make(map[globalKey]int)
+	var result []Global
+	for _, g := range globals {
+		key := keyFor(g)
+		if existing, ok := index[key]; ok {
+			// Prefer the definition over an extern declaration
+			if result[existing].Storage == "extern" && g.Storage != "extern" {
+				result[existing] = g
+			}
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, g)
+	}
+
+	type seenKey struct {
+		global int
+		access GlobalAccess
+	}
+
+	for _, function := range functions {
+		seen := make(map[seenKey]bool)
+		for _, ref := range function.globalRefs {
+			i, ok := index[globalKey{name: ref.Name, file: function.Filename}]
+			if !ok {
+				i, ok = index[globalKey{name: ref.Name}]
+			}
+			if !ok {
+				continue
+			}
+			access := GlobalAccess{
+				FunctionID: function.ID,
+				Function:   function.Name,
+				Kind:       ref.Kind,
+				Line:       ref.Line,
+			}
+			if !seen[seenKey{i, access}] {
+				seen[seenKey{i, access}] = true
+				result[i].Accesses = append(result[i].Accesses, access)
+			}
+		}
+	}
+
+	for i := range result {
+		sort.SliceStable(result[i].Accesses, func(a, b int) bool {
+			if result[i].Accesses[a].FunctionID != result[i].Accesses[b].FunctionID {
+				return result[i].Accesses[a].FunctionID < result[i].Accesses[b].FunctionID
+			}
+			return result[i].Accesses[a].Line < result[i].Accesses[b].Line
+		})
+	}
+
+	return result
+}
+
+// LookupGlobal finds the global a name refers to from within the given file,
+// preferring a static global of that file over one with external linkage
+func (r *AnalysisResult) LookupGlobal(name, filename string) *Global {
+	var fallback *Global
+	candidates := r.globalIndex[name]
+	if r.globalIndex == nil {
+		for i := range r.Globals {
+			candidates = append(candidates, i)
+		}
+	}
+	for _, i := range candidates {
+		g := &r.Globals[i]
+		if g.Name != name {
+			continue
+		}
+		if g.Storage == "static" {
+			if g.Filename == filename {
+				return g
+			}
+			continue
+		}
+		if fallback == nil {
+			fallback = g
+		}
+	}
+	return fallback
+}
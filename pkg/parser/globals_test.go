@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var globalFiles = map[string]string{
+	"a.c": `struct dev;
+void kfree(const void *p);
+int count;
+static struct dev *dev;
+static int table[8];
+void update(int n)
+{
+	int local = n;
+	count = local;
+	count++;
+	kfree(dev);
+	report(table[0]);
+}
+`,
+	"b.c": `extern int count;
+static int dev;
+int read_both(void)
+{
+	return count + dev;
+}
+int shadowed(int count)
+{
+	return count;
+}
+`,
+}
+
+// describeAccesses renders accesses as "func:kind:line" for comparison
+func describeAccesses(accesses []GlobalAccess) []string {
+	var out []string
+	for _, access := range accesses {
+		out = append(out, fmt.Sprintf("%s:%s:%d", access.Function, access.Kind, access.Line))
+	}
+	return out
+}
+
+func TestIndexGlobals(t *testing.T) {
+	result := analyzeFiles(t, globalFiles)
+
+	tests := []struct {
+		name     string
+		file     string
+		storage  string
+		typ      string
+		accesses []string
+	}{
+		{"count", "a.c", "", "int", []string{"update:write:9", "update:write:10", "read_both:read:5"}},
+		{"count", "b.c", "", "int", []string{"update:write:9", "update:write:10", "read_both:read:5"}},
+		{"dev", "a.c", "static", "struct dev *", []string{"update:free:11"}},
+		{"dev", "b.c", "static", "int", []string{"read_both:read:5"}},
+		{"table", "a.c", "static", "int [8]", []string{"update:read:12"}},
+	}
+	for _, tt := range tests {
+		var file string
+		for _, function := range result.Functions {
+			if filepath.Base(function.Filename) == tt.file {
+				file = function.Filename
+			}
+		}
+		g := result.LookupGlobal(tt.name, file)
+		if g == nil {
+			t.Errorf("LookupGlobal(%s, %s) = nil", tt.name, tt.file)
+			continue
+		}
+		if g.Storage != tt.storage || g.Type != tt.typ {
+			t.Errorf("LookupGlobal(%s, %s) = %s %s, want %s %s", tt.name, tt.file, g.Storage, g.Type, tt.storage, tt.typ)
+		}
+		// Accesses are sorted by function ID, which starts with the file name
+		if got := describeAccesses(g.Accesses); !reflect.DeepEqual(got, tt.accesses) {
+			t.Errorf("%s in %s accesses = %v, want %v", tt.name, tt.file, got, tt.accesses)
+		}
+	}
+
+	// extern int count and int count collapse into a single definition
+	var counts int
+	for _, g := range result.Globals {
+		if g.Name == "count" {
+			counts++
+			if g.Storage == "extern" {
+				t.Error("extern declaration kept over the definition")
+			}
+		}
+	}
+	if counts != 1 {
+		t.Errorf("count indexed %d times, want 1", counts)
+	}
+	if result.LookupGlobal("local", "") != nil {
+		t.Error("local variable indexed as a global")
+	}
+}
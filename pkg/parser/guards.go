@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// Guard is a branch that must be taken for a line to run
+type Guard struct {
+	Line      int    `json:"line"`
+	Kind      string `json:"kind"`                 // if, while, for, do, switch
+	Condition string `json:"cond"`                 // Condition text, e.g. "(!ctx->alive)"
+	Branch    string `json:"branch"`               // "true", "false", or the case value taken
+	EarlyExit bool   `json:"early_exit,omitempty"` // The other branch leaves the function, e.g. "if (!p) return;"
+}
+
+// FindGuards returns, for each requested line, the conditions that dominate it and the branch
+// each must take, outermost first. A condition only counts when exactly one of its branches
+// can reach the line.
+func FindGuards(function *Function, lines []int) (map[int][]Guard, error) {
+	parsed, err := ParseFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	defer parsed.Close()
+
+	return parsed.FindGuards(lines), nil
+}
+
+// FindGuards is FindGuards on an already parsed function
+func (p *ParsedFunction) FindGuards(lines []int) map[int][]Guard {
+	cfg := p.CFG()
+	syntax := p.syntax
+	dominators := p.Dominators()
+
+	guards := make(map[int][]Guard)
+	for _, line := range lines {
+		target := cfg.NodeAtLine(line)
+		if target < 0 {
+			continue
+		}
+		for _, id := range dominators[target] {
+			node := cfg.Nodes[id]
+			if node.Kind != "cond" || id == target {
+				continue
+			}
+
+			var taken []CFGEdge
+			var others []CFGEdge
+			for _, edge := range node.Succs {
+				if edge.To == target || cfg.reachableAvoiding(edge.To, target, id) {
+					taken = append(taken, edge)
+				} else {
+					others = append(others, edge)
+				}
+			}
+			if len(taken) != 1 || len(others) == 0 {
+				continue
+			}
+
+			guard := Guard{
+				Line:      node.Line,
+				Kind:      strings.TrimSuffix(syntax[id].Parent().Kind(), "_statement"),
+				Condition: node.Text,
+				Branch:    taken[0].Label,
+			}
+			for _, edge := range others {
+				switch cfg.Nodes[edge.To].Kind {
+				case "return", "goto", "exit":
+					guard.EarlyExit = true
+				}
+			}
+			guards[line] = append(guards[line], guard)
+		}
+		sort.Slice(guards[line], func(i, j int) bool { return guards[line][i].Line < guards[line][j].Line })
+	}
+
+	return guards
+}
+
+// Dominators returns, for every node, the nodes that lie on every path from the entry to it
+func (c *CFG) Dominators() [][]int {
+	n := len(c.Nodes)
+	preds := c.Predecessors()
+	dom := make([][]bool, n)
+	for id := range dom {
+		dom[id] = make([]bool, n)
+		for other := range dom[id] {
+			dom[id][other] = id != c.Entry || other == c.Entry
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for id := 0; id < n; id++ {
+			if id == c.Entry {
+				continue
+			}
+			next := make([]bool, n)
+			first := true
+			for _, pred := range preds[id] {
+				for other := range next {
+					if first {
+						next[other] = dom[pred][other]
+					} else {
+						next[other] = next[other] && dom[pred][other]
+					}
+				}
+				first = false
+			}
+			next[id] = true
+			for other := range next {
+				if next[other] != dom[id][other] {
+					dom[id] = next
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	result := make([][]int, n)
+	for id := range dom {
+		for other, dominates := range dom[id] {
+			if dominates {
+				result[id] = append(result[id], other)
+			}
+		}
+	}
+	return result
+}
+
+// reachableAvoiding reports whether to can be reached from from without passing through avoid
+func (c *CFG) reachableAvoiding(from, to, avoid int) bool {
+	if from == avoid {
+		return false
+	}
+	visited := make([]bool, len(c.Nodes))
+	visited[from] = true
+	stack := []int{from}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if current == to {
+			return true
+		}
+		for _, edge := range c.Nodes[current].Succs {
+			if !visited[edge.To] && edge.To != avoid {
+				visited[edge.To] = true
+				stack = append(stack, edge.To)
+			}
+		}
+	}
+	return false
+}
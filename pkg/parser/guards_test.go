@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+)
+
+const guardSource = `void kfree(const void *p);
+int release(int *p, int mode, int force)
+{
+	if (!p)
+		return -1;
+	if (mode > 0) {
+		if (force)
+			kfree(p);
+	} else {
+		p[0] = 0;
+	}
+	switch (mode) {
+	case 2:
+		p[1] = 1;
+		break;
+	}
+	while (mode--)
+		p[2] = 2;
+	return 0;
+}
+`
+
+// describeGuards renders guards as "line kind cond branch[!]", with ! marking an early exit
+func describeGuards(guards []Guard) []string {
+	var out []string
+	for _, guard := range guards {
+		text := fmt.Sprintf("%d %s %s %s", guard.Line, guard.Kind, guard.Condition, guard.Branch)
+		if guard.EarlyExit {
+			text += "!"
+		}
+		out = append(out, text)
+	}
+	return out
+}
+
+func TestFindGuards(t *testing.T) {
+	function := parseC(t, guardSource)["release"]
+	tests := []struct {
+		line   int
+		guards []string
+	}{
+		{8, []string{"4 if (!p) false!", "6 if (mode > 0) true", "7 if (force) true"}},
+		{10, []string{"4 if (!p) false!", "6 if (mode > 0) false"}},
+		{14, []string{"4 if (!p) false!", "12 switch (mode) 2"}},
+		// Leaving the loop goes straight to the return, and the return needs the loop to end
+		{18, []string{"4 if (!p) false!", "17 while (mode--) true!"}},
+		{19, []string{"4 if (!p) false!", "17 while (mode--) false"}},
+		{5, []string{"4 if (!p) true"}},
+	}
+	lines := make([]int, len(tests))
+	for i, tt := range tests {
+		lines[i] = tt.line
+	}
+	guards, err := FindGuards(function, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if got := describeGuards(guards[tt.line]); fmt.Sprint(got) != fmt.Sprint(tt.guards) {
+			t.Errorf("guards of L%d = %q, want %q", tt.line, got, tt.guards)
+		}
+	}
+}
+
+func TestDominators(t *testing.T) {
+	cfg, err := BuildCFG(parseC(t, guardSource)["release"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dominators := cfg.Dominators()
+	dominates := func(a, b int) bool {
+		for _, id := range dominators[b] {
+			if id == a {
+				return true
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		from, to int
+		want     bool
+	}{
+		{4, 8, true},   // the null check runs before everything after it
+		{6, 10, true},  // the branch decides both arms
+		{7, 10, false}, // the inner check is only on the then arm
+		{8, 12, false}, // the free is conditional
+		{6, 19, true},
+		{17, 19, true},
+		{14, 19, false},
+	}
+	for _, tt := range tests {
+		from, to := cfg.NodeAtLine(tt.from), cfg.NodeAtLine(tt.to)
+		if from < 0 || to < 0 {
+			t.Fatalf("no node at L%d or L%d", tt.from, tt.to)
+		}
+		if got := dominates(from, to); got != tt.want {
+			t.Errorf("L%d dominates L%d = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+	if !dominates(cfg.Entry, cfg.Exit) {
+		t.Error("entry does not dominate exit")
+	}
+}
@@ -0,0 +1,286 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// lockPrimitive describes a function that acquires or releases a lock
+type lockPrimitive struct {
+	Kind    string // spin, mutex, rwlock, rwsem, sem, rcu, pthread
+	Acquire bool
+}
+
+// lockPrimitives are common kernel and pthread lock functions; conditional forms such as
+// mutex_trylock are left out because the lock is only held on one branch
+var lockPrimitives = map[string]lockPrimitive{
+	"spin_lock":                  {"spin", true},
+	"spin_lock_bh":               {"spin", true},
+	"spin_lock_irq":              {"spin", true},
+	"spin_lock_irqsave":          {"spin", true},
+	"raw_spin_lock":              {"spin", true},
+	"raw_spin_lock_irqsave":      {"spin", true},
+	"spin_unlock":                {"spin", false},
+	"spin_unlock_bh":             {"spin", false},
+	"spin_unlock_irq":            {"spin", false},
+	"spin_unlock_irqrestore":     {"spin", false},
+	"raw_spin_unlock":            {"spin", false},
+	"raw_spin_unlock_irqrestore": {"spin", false},
+	"mutex_lock":                 {"mutex", true},
+	"mutex_lock_nested":          {"mutex", true},
+	"mutex_unlock":               {"mutex", false},
+	"read_lock":                  {"rwlock", true},
+	"read_lock_bh":               {"rwlock", true},
+	"read_lock_irqsave":          {"rwlock", true},
+	"write_lock":                 {"rwlock", true},
+	"write_lock_bh":              {"rwlock", true},
+	"write_lock_irqsave":         {"rwlock", true},
+	"read_unlock":                {"rwlock", false},
+	"read_unlock_bh":             {"rwlock", false},
+	"read_unlock_irqrestore":     {"rwlock", false},
+	"write_unlock":               {"rwlock", false},
+	"write_unlock_bh":            {"rwlock", false},
+	"write_unlock_irqrestore":    {"rwlock", false},
+	"down_read":                  {"rwsem", true},
+	"down_write":                 {"rwsem", true},
+	"up_read":                    {"rwsem", false},
+	"up_write":                   {"rwsem", false},
+	"down":                       {"sem", true},
+	"up":                         {"sem", false},
+	"rcu_read_lock":              {"rcu", true},
+	"rcu_read_lock_bh":           {"rcu", true},
+	"rcu_read_unlock":            {"rcu", false},
+	"rcu_read_unlock_bh":         {"rcu", false},
+	"srcu_read_lock":             {"rcu", true},
+	"srcu_read_unlock":           {"rcu", false},
+	"pthread_mutex_lock":         {"pthread", true},
+	"pthread_mutex_unlock":       {"pthread", false},
+	"pthread_rwlock_rdlock":      {"pthread", true},
+	"pthread_rwlock_wrlock":      {"pthread", true},
+	"pthread_rwlock_unlock":      {"pthread", false},
+	"pthread_spin_lock":          {"pthread", true},
+	"pthread_spin_unlock":        {"pthread", false},
+}
+
+// entryRegistrars map functions that start a thread or schedule a callback to the arguments
+// holding the entry point and the kind of context it runs in
+var entryRegistrars = map[string]map[int]string{
+	"pthread_create":            {2: "thread"},
+	"thrd_create":               {1: "thread"},
+	"g_thread_new":              {1: "thread"},
+	"kthread_create":            {0: "thread"},
+	"kthread_run":               {0: "thread"},
+	"kthread_create_on_node":    {0: "thread"},
+	"INIT_WORK":                 {1: "work"},
+	"INIT_DELAYED_WORK":         {1: "work"},
+	"INIT_RCU_WORK":             {1: "work"},
+	"timer_setup":               {1: "timer"},
+	"setup_timer":               {1: "timer"},
+	"tasklet_init":              {1: "tasklet"},
+	"tasklet_setup":             {1: "tasklet"},
+	"request_irq":               {1: "irq"},
+	"request_threaded_irq":      {1: "irq", 2: "irq_thread"},
+	"devm_request_irq":          {2: "irq"},
+	"devm_request_threaded_irq": {2: "irq", 3: "irq_thread"},
+	"call_rcu":                  {1: "rcu_callback"},
+	"signal":                    {1: "signal"},
+}
+
+// LockOp is a lock acquired or released inside a function
+type LockOp struct {
+	Lock      string `json:"lock"` // Lock expression without "&", e.g. "dev->lock"; "rcu" for RCU read sections
+	Kind      string `json:"kind"` // spin, mutex, rwlock, rwsem, sem, rcu, pthread
+	Primitive string `json:"primitive"`
+	Acquire   bool   `json:"acquire"`
+	Line      int    `json:"line"`
+}
+
+// HeldLock is a lock held on every path reaching a line
+type HeldLock struct {
+	Function string `json:"func,omitempty"` // Set when the lock is held by a caller in the chain
+	Lock     string `json:"lock"`
+	Kind     string `json:"kind"`
+	Line     int    `json:"line"`               // Where it was acquired
+	Key      string `json:"key,omitempty"`      // Identity across functions, e.g. "struct dev::lock"
+	MayHold  bool   `json:"may_hold,omitempty"` // Held on some paths from callers but not all
+}
+
+// EntryPoint is a function started as a thread or run asynchronously as a callback
+type EntryPoint struct {
+	Function  string `json:"func"`
+	Kind      string `json:"kind"` // thread, work, timer, tasklet, irq, irq_thread, rcu_callback, signal
+	Registrar string `json:"registrar"`
+	Caller    string `json:"caller"`
+	Line      int    `json:"line"`
+}
+
+// FindLockOps lists the locks a function acquires and releases, in line order
+func FindLockOps(function *Function) []LockOp {
+	var ops []LockOp
+	for _, callee := range function.Callees {
+		primitive, ok := lockPrimitives[callee.Name]
+		if !ok {
+			continue
+		}
+		lock := "rcu"
+		if primitive.Kind != "rcu" || strings.HasPrefix(callee.Name, "srcu") {
+			if len(callee.Args) == 0 {
+				continue
+			}
+			lock = NormalizeTarget(strings.TrimPrefix(strings.TrimSpace(callee.Args[0]), "&"))
+		}
+		ops = append(ops, LockOp{Lock: lock, Kind: primitive.Kind, Primitive: callee.Name, Acquire: primitive.Acquire, Line: callee.Line})
+	}
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Line < ops[j].Line })
+	return ops
+}
+
+// HeldLocks returns, for each requested line, the locks held on every path from the function's
+// entry to it. Locks acquired and released on the line itself are not counted.
+func HeldLocks(function *Function, lines []int) (map[int][]HeldLock, error) {
+	ops := FindLockOps(function)
+	if len(ops) == 0 {
+		return make(map[int][]HeldLock), nil
+	}
+
+	cfg, err := BuildCFG(function)
+	if err != nil {
+		return nil, err
+	}
+	return heldLocks(cfg, ops, lines), nil
+}
+
+// HeldLocks is HeldLocks on an already parsed function
+func (p *ParsedFunction) HeldLocks(lines []int) map[int][]HeldLock {
+	ops := FindLockOps(p.Function)
+	if len(ops) == 0 {
+		return make(map[int][]HeldLock)
+	}
+	return heldLocks(p.CFG(), ops, lines)
+}
+
+// heldLocks runs the held-lock analysis of HeldLocks over a CFG and the function's lock operations
+func heldLocks(cfg *CFG, ops []LockOp, lines []int) map[int][]HeldLock {
+	held := make(map[int][]HeldLock)
+
+	nodeOps := make(map[int][]LockOp)
+	for _, op := range ops {
+		if node := cfg.NodeAtLine(op.Line); node >= 0 {
+			nodeOps[node] = append(nodeOps[node], op)
+		}
+	}
+	apply := func(locks map[string]HeldLock, ops []LockOp, before int) map[string]HeldLock {
+		out := make(map[string]HeldLock, len(locks))
+		for lock, h := range locks {
+			out[lock] = h
+		}
+		for _, op := range ops {
+			if before > 0 && op.Line >= before {
+				break
+			}
+			if op.Acquire {
+				out[op.Lock] = HeldLock{Lock: op.Lock, Kind: op.Kind, Line: op.Line}
+			} else {
+				delete(out, op.Lock)
+			}
+		}
+		return out
+	}
+
+	// Forward must-analysis: a lock is held on entry to a node only if every predecessor holds it
+	preds := cfg.Predecessors()
+	in := make([]map[string]HeldLock, len(cfg.Nodes))
+	out := make([]map[string]HeldLock, len(cfg.Nodes))
+	out[cfg.Entry] = map[string]HeldLock{}
+	for changed := true; changed; {
+		changed = false
+		for id := range cfg.Nodes {
+			if id == cfg.Entry {
+				continue
+			}
+			var state map[string]HeldLock
+			for _, pred := range preds[id] {
+				if out[pred] == nil {
+					continue // Not yet visited; acts as "every lock"
+				}
+				if state == nil {
+					state = apply(out[pred], nil, 0)
+					continue
+				}
+				for lock := range state {
+					if _, ok := out[pred][lock]; !ok {
+						delete(state, lock)
+					}
+				}
+			}
+			if state == nil {
+				continue
+			}
+			next := apply(state, nodeOps[id], 0)
+			if out[id] == nil || len(next) != len(out[id]) || !sameLocks(next, out[id]) {
+				changed = true
+			}
+			in[id], out[id] = state, next
+		}
+	}
+
+	for _, line := range lines {
+		node := cfg.NodeAtLine(line)
+		if node < 0 || in[node] == nil {
+			continue
+		}
+		for _, h := range apply(in[node], nodeOps[node], line) {
+			held[line] = append(held[line], h)
+		}
+		sort.Slice(held[line], func(i, j int) bool { return held[line][i].Line < held[line][j].Line })
+	}
+	return held
+}
+
+// sameLocks reports whether two lock sets hold the same locks
+func sameLocks(a, b map[string]HeldLock) bool {
+	for lock := range a {
+		if _, ok := b[lock]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// FindEntryPoints lists the functions passed to thread creation, work queue, timer, interrupt
+// and RCU callback registration calls
+func FindEntryPoints(result *AnalysisResult) []EntryPoint {
+	var entries []EntryPoint
+	for _, function := range result.Functions {
+		for _, callee := range function.Callees {
+			for arg, kind := range entryRegistrars[callee.Name] {
+				if arg >= len(callee.Args) {
+					continue
+				}
+				name := strings.TrimLeft(StripCasts(callee.Args[arg]), "&")
+				if !isIdentifier(name) || name == "NULL" {
+					continue
+				}
+				entries = append(entries, EntryPoint{
+					Function:  name,
+					Kind:      kind,
+					Registrar: callee.Name,
+					Caller:    function.Name,
+					Line:      callee.Line,
+				})
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Function != entries[j].Function {
+			return entries[i].Function < entries[j].Function
+		}
+		return entries[i].Kind < entries[j].Kind
+	})
+	return entries
+}
+
+// isIdentifier reports whether a string is a plain C identifier
+func isIdentifier(s string) bool {
+	return s != "" && ParameterName(s) == s && (s[0] < '0' || s[0] > '9')
+}
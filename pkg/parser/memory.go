@@ -0,0 +1,87 @@
+package parser
+
+import "strings"
+
+// knownAllocators lists common C and Linux kernel allocation functions
+var knownAllocators = map[string]bool{
+	"malloc":            true,
+	"calloc":            true,
+	"realloc":           true,
+	"reallocarray":      true,
+	"aligned_alloc":     true,
+	"strdup":            true,
+	"strndup":           true,
+	"kmalloc":           true,
+	"kzalloc":           true,
+	"kcalloc":           true,
+	"krealloc":          true,
+	"kmalloc_array":     true,
+	"kmemdup":           true,
+	"kstrdup":           true,
+	"kmem_cache_alloc":  true,
+	"kmem_cache_zalloc": true,
+	"vmalloc":           true,
+	"vzalloc":           true,
+	"kvmalloc":          true,
+	"kvzalloc":          true,
+	"devm_kzalloc":      true,
+	"devm_kmalloc":      true,
+}
+
+// activeModel holds the deallocators and allocators declared with UseMemoryModel
+var activeModel struct {
+	model        *MemoryModel
+	deallocators map[string]bool
+	allocators   map[string]bool
+}
+
+// UseMemoryModel makes IsAllocator and IsDeallocator honor a declared or inferred model,
+// keeping the enrichment in line with the model the query ran with. Call it before analysis starts.
+func UseMemoryModel(model *MemoryModel) {
+	activeModel.model = model
+	activeModel.deallocators = make(map[string]bool)
+	activeModel.allocators = make(map[string]bool)
+	for _, function := range model.Deallocators {
+		activeModel.deallocators[function.Name] = true
+	}
+	for _, function := range model.Allocators {
+		activeModel.allocators[function.Name] = true
+	}
+}
+
+// IsAllocator reports whether a function name looks like a memory allocator
+func IsAllocator(name string) bool {
+	if knownAllocators[name] || activeModel.allocators[name] {
+		return true
+	}
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, "_alloc") || strings.HasSuffix(lower, "alloc_obj")
+}
+
+// IsDeallocator reports whether a function name looks like a memory deallocator.
+// This mirrors the FreeFunction class used by spec/uaf/query.ql.
+func IsDeallocator(name string) bool {
+	if activeModel.deallocators[name] || name == "delete" || name == "operator delete" {
+		return true
+	}
+	if activeModel.model != nil && !activeModel.model.NameHeuristic {
+		return false
+	}
+	return strings.Contains(name, "free")
+}
+
+// destructorSuffixes are name endings of functions that tear an object down
+var destructorSuffixes = []string{
+	"_destroy", "_release", "_free", "_cleanup", "_deinit", "_fini", "_teardown", "_dtor", "_put", "_unref", "_close",
+}
+
+// IsDestructor reports whether a function name looks like an object destructor, e.g. ctx_destroy
+func IsDestructor(name string) bool {
+	lower := strings.ToLower(name)
+	for _, suffix := range destructorSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return strings.HasPrefix(lower, "destroy_") || strings.HasPrefix(lower, "release_") || strings.HasPrefix(lower, "free_")
+}
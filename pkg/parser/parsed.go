@@ -0,0 +1,50 @@
+package parser
+
+import (
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// ParsedFunction is a function parsed once for the analyses run on it: its CFG, dataflow and
+// dominators are built on first use and shared. Callers must Close it.
+type ParsedFunction struct {
+	Function   *Function
+	ast        *functionAST
+	dataflow   *Dataflow
+	syntax     []*sitter.Node // Syntax node behind each CFG node
+	dominators [][]int
+}
+
+// ParseFunction parses a function's definition for repeated analysis
+func ParseFunction(function *Function) (*ParsedFunction, error) {
+	ast, err := parseFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	return &ParsedFunction{Function: function, ast: ast}, nil
+}
+
+// Close releases the tree-sitter resources held by the parse
+func (p *ParsedFunction) Close() {
+	p.ast.Close()
+}
+
+// Dataflow returns the function's CFG and definitions
+func (p *ParsedFunction) Dataflow() *Dataflow {
+	if p.dataflow == nil {
+		p.dataflow, p.syntax = analyzeDataflow(p.ast, p.Function)
+	}
+	return p.dataflow
+}
+
+// CFG returns the function's control-flow graph
+func (p *ParsedFunction) CFG() *CFG {
+	return p.Dataflow().CFG
+}
+
+// Dominators returns, for every CFG node, the nodes on every path from the entry to it
+func (p *ParsedFunction) Dominators() [][]int {
+	if p.dominators == nil {
+		p.dominators = p.CFG().Dominators()
+	}
+	return p.dominators
+}
@@ -12,9 +12,19 @@ import (
 )
 
 type Variable struct {
-	Name   string `json:"name"`
-	Origin string `json:"origin"`
-	Type   string `json:"type"`
+	Name         string   `json:"name"`
+	Origin       string   `json:"origin"`
+	Type         string   `json:"type"`
+	BaseType     string   `json:"base_type,omitempty"`
+	Storage      string   `json:"storage,omitempty"`
+	PointerDepth int      `json:"ptr,omitempty"`
+	ArraySizes   []string `json:"array,omitempty"`
+	FuncPointer  bool     `json:"func_ptr,omitempty"`
+	Line         int      `json:"line,omitempty"`
+	Init         string   `json:"init,omitempty"`
+	Allocator    string   `json:"allocator,omitempty"`
+	AllocCall    string   `json:"alloc,omitempty"`
+	AllocLine    int      `json:"alloc_ln,omitempty"`
 }
 
 type Callee struct {
@@ -37,22 +47,40 @@ type Function struct {
 	StartLine                     int         `json:"start"`
 	EndLine                       int         `json:"end"`
 	Signature                     string      `json:"sig"`
+	Macro                         string      `json:"macro,omitempty"`   // Defining macro, e.g. SYSCALL_DEFINE3, when not a plain C definition
+	Aliases                       []string    `json:"aliases,omitempty"` // Other names calls reach it by, e.g. sys_read for __do_sys_read
 	Definition                    string      `json:"def"`
 	DefinitionWithLineNumbers     string      `json:"def_ln"`
 	Length                        int         `json:"len"`
 	Params                        []Parameter `json:"params"`
 	Callees                       []Callee    `json:"callees"`
 	Vars                          []Variable  `json:"vars"`
+
+	globalRefs []identifierRef // non-local identifier references, resolved into AnalysisResult.Globals
+	fieldRefs  []fieldRef      // field accesses, resolved into AnalysisResult.FieldAccesses
 }
 
 
 type AnalysisResult struct {
 	Functions []Function `json:"functions"`
+	Globals   []Global   `json:"globals"`
+	Types     []TypeDef  `json:"types"`
+
+	FieldAccesses    []FieldAccess     `json:"field_accesses"`
+	FunctionPointers []FunctionPointer `json:"fptrs"` // Identifiers stored into struct members, for resolving indirect calls
+
+	typeIndex     map[string][]int // type name -> indexes into Types
+	globalIndex   map[string][]int // global name -> indexes into Globals
+	functionIndex map[string][]int // function name -> indexes into Functions
+	functionIDs   map[string]int   // function ID -> index into Functions
+	callerIndex   map[string][]int // callee name -> indexes into Functions of its callers
+	accessIndex   map[string][]int // function ID -> indexes into FieldAccesses
 }
 
 
 func analyzeDirectory(dir string) (*AnalysisResult, error) {
-	result := &AnalysisResult{Functions: []Function{}}
+	result := &AnalysisResult{Functions: []Function{}, Globals: []Global{}, Types: []TypeDef{}}
+	var globals []Global
 	
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -60,43 +88,58 @@ func analyzeDirectory(dir string) (*AnalysisResult, error) {
 		}
 		
 		if strings.HasSuffix(path, ".c") || strings.HasSuffix(path, ".h") {
-			functions, err := analyzeCFile(path)
+			functions, fileGlobals, types, pointers, err := analyzeCFile(path)
 			if err != nil {
 				return nil
 			}
 			result.Functions = append(result.Functions, functions...)
+			result.Types = append(result.Types, types...)
+			result.FunctionPointers = append(result.FunctionPointers, pointers...)
+			globals = append(globals, fileGlobals...)
 		}
 		
 		return nil
 	})
 	
+	// Resolve global and field accesses now that every file has been parsed
+	result.Globals = indexGlobals(globals, result.Functions)
+	for i := range result.Functions {
+		result.Functions[i].globalRefs = nil
+	}
+	result.buildIndexes()
+	result.indexFieldAccesses()
+	
 	return result, err
 }
 
-func analyzeCFile(filename string) ([]Function, error) {
+func analyzeCFile(filename string) ([]Function, []Global, []TypeDef, []FunctionPointer, error) {
 	content, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, nil, err
 	}
 	
 	parser := sitter.NewParser()
 	language := sitter.NewLanguage(tree_sitter_c.Language())
 	err = parser.SetLanguage(language)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, nil, err
 	}
 	
 	tree := parser.Parse(content, nil)
 	if tree == nil {
-		return nil, fmt.Errorf("failed to parse file: %s", filename)
+		return nil, nil, nil, nil, fmt.Errorf("failed to parse file: %s", filename)
 	}
 	
 	root := tree.RootNode()
 	var functions []Function
 	
 	functions = append(functions, findFunctionDefinitions(root, content, filename)...)
+	functions = append(functions, findSyscallDefinitions(root, content, filename)...)
+	globals := findGlobalDeclarations(root, content, filename)
+	types := findTypeDefinitions(root, content, filename)
+	pointers := findFunctionPointers(root, content, filename)
 	
-	return functions, nil
+	return functions, globals, types, pointers, nil
 }
 
 func findFunctionDefinitions(node *sitter.Node, content []byte, filename string) []Function {
@@ -133,9 +176,17 @@ func analyzeFunctionDefinition(node *sitter.Node, content []byte, filename strin
 		Vars:      []Variable{},
 	}
 	
-	// Extract function signature and parameters
-	declarator := findChildByType(node, "function_declarator")
-	if declarator == nil {
+	// Extract function signature and parameters; functions returning pointers wrap the
+	// function_declarator in one pointer_declarator per level. Without them, constructors
+	// such as "struct buf *buf_create(void)" are missing and allocation tracing stops at
+	// their call.
+	declarator := node.ChildByFieldName("declarator")
+	pointers := ""
+	for declarator != nil && declarator.Kind() == "pointer_declarator" {
+		pointers += "*"
+		declarator = declarator.ChildByFieldName("declarator")
+	}
+	if declarator == nil || declarator.Kind() != "function_declarator" {
 		return nil
 	}
 	
@@ -152,11 +203,12 @@ func analyzeFunctionDefinition(node *sitter.Node, content []byte, filename strin
 		returnType := ""
 		for i := uint(0); i < node.ChildCount(); i++ {
 			child := node.Child(i)
-			if child.Kind() != "function_declarator" && child.Kind() != "compound_statement" {
-				returnType += getNodeText(child, content) + " "
-			} else if child.Kind() == "function_declarator" {
+			if node.FieldNameForChild(uint32(i)) == "declarator" {
 				break
 			}
+			if child.Kind() != "compound_statement" {
+				returnType += getNodeText(child, content) + " "
+			}
 		}
 		
 		// Get parameters
@@ -170,22 +222,35 @@ func analyzeFunctionDefinition(node *sitter.Node, content []byte, filename strin
 		for _, param := range function.Params {
 			paramStrings = append(paramStrings, param.Snippet)
 		}
-		function.Signature = strings.TrimSpace(returnType) + " " + functionName + "(" + strings.Join(paramStrings, ", ") + ")"
+		function.Signature = strings.TrimSpace(returnType) + " " + pointers + functionName + "(" + strings.Join(paramStrings, ", ") + ")"
 	}
 	
 	// Find function body
 	body := findChildByType(node, "compound_statement")
 	if body != nil {
-		// Extract function calls
-		function.Callees = findFunctionCalls(body, content)
-		
-		// Extract variables
-		function.Vars = findVariables(body, content, function.Params)
+		analyzeFunctionBody(function, body, content)
 	}
 	
 	return function
 }
 
+// analyzeFunctionBody collects the calls, variables and non-local references of a function body
+func analyzeFunctionBody(function *Function, body *sitter.Node, content []byte) {
+	// Extract function calls
+	function.Callees = findFunctionCalls(body, content)
+	
+	// Extract variables
+	function.Vars = findVariables(body, content, function.Params)
+	
+	// Collect references to non-local identifiers for the global index
+	locals := make(map[string]bool)
+	for _, v := range function.Vars {
+		locals[v.Name] = true
+	}
+	function.globalRefs = findIdentifierRefs(body, content, locals)
+	function.fieldRefs = findFieldRefs(body, content)
+}
+
 func findChildByType(node *sitter.Node, nodeType string) *sitter.Node {
 	for i := uint(0); i < node.ChildCount(); i++ {
 		child := node.Child(i)
@@ -222,11 +287,43 @@ func extractParameters(paramList *sitter.Node, content []byte) []Parameter {
 	return params
 }
 
+// ParameterName returns the declared name in a parameter snippet such as "struct buf *b"
+func ParameterName(snippet string) string {
+	snippet = strings.TrimSpace(snippet)
+	if i := strings.Index(snippet, "["); i >= 0 {
+		snippet = snippet[:i]
+	}
+	start := len(snippet)
+	for start > 0 {
+		c := snippet[start-1]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			break
+		}
+		start--
+	}
+	return snippet[start:]
+}
+
 func parseParameterDeclaration(paramText string) *Parameter {
 	if paramText == "" {
 		return nil
 	}
 	
+	// Function pointers such as "void (*handler)(int)" are named inside their declarator
+	if open := strings.Index(paramText, "(*"); open >= 0 {
+		if end := strings.Index(paramText[open:], ")"); end >= 0 {
+			declarator := paramText[open+1 : open+end]
+			name := strings.TrimSpace(strings.TrimLeft(declarator, "* "))
+			if isIdentifier(name) {
+				return &Parameter{
+					Snippet: paramText,
+					Name:    name,
+					Type:    paramText[:open+1] + strings.TrimSpace(strings.TrimSuffix(declarator, name)) + paramText[open+end:],
+				}
+			}
+		}
+	}
+
 	// Split the parameter text into words
 	words := strings.Fields(paramText)
 	if len(words) == 0 {
@@ -360,15 +457,20 @@ func findVariables(node *sitter.Node, content []byte, params []Parameter) []Vari
 	for _, param := range params {
 		if param.Name != "" {
 			varMap[param.Name] = &Variable{
-				Name:   param.Name,
-				Origin: "param",
-				Type:   param.Type,
+				Name:         param.Name,
+				Origin:       "param",
+				Type:         param.Type,
+				BaseType:     strings.TrimSpace(strings.TrimRight(param.Type, "*& ")),
+				PointerDepth: strings.Count(param.Type, "*"),
 			}
 		}
 	}
 	
-	// Find basic local variable declarations
+	// Find local variable declarations
 	findLocalVariableDeclarations(node, content, varMap)
+
+	// Record allocations that happen through a later assignment (p = malloc(...))
+	findAllocatingAssignments(node, content, varMap)
 	
 	// Convert map to slice
 	var variables []Variable
@@ -379,14 +481,18 @@ func findVariables(node *sitter.Node, content []byte, params []Parameter) []Vari
 	return variables
 }
 
-// findLocalVariableDeclarations finds basic local variable declarations
+// findLocalVariableDeclarations finds local variable declarations
 func findLocalVariableDeclarations(node *sitter.Node, content []byte, varMap map[string]*Variable) {
 	for i := uint(0); i < node.ChildCount(); i++ {
 		child := node.Child(i)
 		
 		if child.Kind() == "declaration" {
-			// Extract basic variable declarations without complex analysis
-			extractBasicVariableDeclaration(child, content, varMap)
+			for _, v := range extractVariableDeclaration(child, content, "local") {
+				if varMap[v.Name] == nil {
+					v := v
+					varMap[v.Name] = &v
+				}
+			}
 		}
 		
 		// Recurse into child nodes
@@ -394,45 +500,199 @@ func findLocalVariableDeclarations(node *sitter.Node, content []byte, varMap map
 	}
 }
 
-// extractBasicVariableDeclaration extracts simple variable declarations
-func extractBasicVariableDeclaration(node *sitter.Node, content []byte, varMap map[string]*Variable) {
-	var typeParts []string
+// extractVariableDeclaration extracts every variable declared by a declaration node,
+// including pointer, array and function pointer declarators
+func extractVariableDeclaration(node *sitter.Node, content []byte, origin string) []Variable {
+	baseType, storage := declarationBaseType(node, content)
+	if baseType == "" {
+		baseType = "unknown"
+	}
+
+	var variables []Variable
 	for i := uint(0); i < node.ChildCount(); i++ {
+		if node.FieldNameForChild(uint32(i)) != "declarator" {
+			continue
+		}
 		child := node.Child(i)
-		if child.Kind() == "primitive_type" || child.Kind() == "type_identifier" || 
-		   child.Kind() == "struct_specifier" || child.Kind() == "storage_class_specifier" {
+
+		// Function prototypes declare functions, not variables
+		if declaresFunction(child) {
+			continue
+		}
+
+		v := Variable{
+			Origin:   origin,
+			BaseType: baseType,
+			Storage:  storage,
+			Line:     int(child.StartPosition().Row) + 1,
+		}
+		var funcParams string
+		describeDeclarator(child, content, &v, &funcParams)
+		if v.Name == "" {
+			continue
+		}
+		v.Type = formatDeclaratorType(baseType, v.PointerDepth, v.ArraySizes, v.FuncPointer, funcParams)
+
+		if value := child.ChildByFieldName("value"); child.Kind() == "init_declarator" && value != nil {
+			v.Init = strings.TrimSpace(getNodeText(value, content))
+			if call := allocationCall(value, content); call != nil {
+				v.Allocator = getNodeText(call.ChildByFieldName("function"), content)
+				v.AllocCall = getNodeText(call, content)
+				v.AllocLine = int(call.StartPosition().Row) + 1
+			}
+		}
+
+		variables = append(variables, v)
+	}
+
+	return variables
+}
+
+// declarationBaseType returns the type specifier (with qualifiers) and storage class of a declaration
+func declarationBaseType(node *sitter.Node, content []byte) (string, string) {
+	var typeParts, storageParts []string
+	for i := uint(0); i < node.ChildCount(); i++ {
+		if node.FieldNameForChild(uint32(i)) == "declarator" {
+			continue
+		}
+		child := node.Child(i)
+		switch child.Kind() {
+		case "storage_class_specifier":
+			storageParts = append(storageParts, getNodeText(child, content))
+		case "type_qualifier":
+			typeParts = append(typeParts, getNodeText(child, content))
+		case "primitive_type", "type_identifier", "sized_type_specifier", "macro_type_specifier":
 			typeParts = append(typeParts, getNodeText(child, content))
+		case "struct_specifier", "union_specifier", "enum_specifier":
+			typeParts = append(typeParts, typeSpecifierName(child, content))
 		}
 	}
-	
-	declarationType := "unknown"
-	if len(typeParts) > 0 {
-		declarationType = strings.Join(typeParts, " ")
+	return strings.Join(typeParts, " "), strings.Join(storageParts, " ")
+}
+
+// typeSpecifierName renders a struct/union/enum specifier without its body
+func typeSpecifierName(node *sitter.Node, content []byte) string {
+	keyword := strings.TrimSuffix(node.Kind(), "_specifier")
+	if name := node.ChildByFieldName("name"); name != nil {
+		return keyword + " " + getNodeText(name, content)
 	}
-	
-	// Find declarators
+	return keyword + " <anonymous>"
+}
+
+// describeDeclarator walks a (possibly nested) declarator, filling in the name,
+// pointer depth, array sizes and function pointer shape of the variable
+func describeDeclarator(node *sitter.Node, content []byte, v *Variable, funcParams *string) {
+	switch node.Kind() {
+	case "identifier", "field_identifier":
+		v.Name = getNodeText(node, content)
+		return
+	case "pointer_declarator":
+		v.PointerDepth++
+	case "array_declarator":
+		size := ""
+		if sizeNode := node.ChildByFieldName("size"); sizeNode != nil {
+			size = getNodeText(sizeNode, content)
+		}
+		// The outermost array declarator holds the last dimension
+		v.ArraySizes = append([]string{size}, v.ArraySizes...)
+	case "function_declarator":
+		v.FuncPointer = true
+		if params := node.ChildByFieldName("parameters"); params != nil {
+			*funcParams = getNodeText(params, content)
+		}
+	case "parenthesized_declarator", "attributed_declarator":
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			if child.Kind() != "attribute_declaration" {
+				describeDeclarator(child, content, v, funcParams)
+				return
+			}
+		}
+		return
+	}
+
+	if inner := node.ChildByFieldName("declarator"); inner != nil {
+		describeDeclarator(inner, content, v, funcParams)
+	}
+}
+
+// declaresFunction reports whether a declarator names a function (int f(void), char *f(int))
+// rather than a variable, which includes function pointers such as int (*f)(void)
+func declaresFunction(node *sitter.Node) bool {
+	for node != nil {
+		switch node.Kind() {
+		case "function_declarator":
+			inner := node.ChildByFieldName("declarator")
+			return inner != nil && inner.Kind() == "identifier"
+		case "pointer_declarator", "init_declarator", "array_declarator", "attributed_declarator":
+			node = node.ChildByFieldName("declarator")
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// formatDeclaratorType builds a readable C type such as "char *", "int [16]" or "int (*)(int, int)"
+func formatDeclaratorType(baseType string, pointerDepth int, arraySizes []string, funcPointer bool, funcParams string) string {
+	stars := strings.Repeat("*", pointerDepth)
+	if funcPointer {
+		return baseType + " (" + stars + ")" + funcParams
+	}
+
+	typ := baseType
+	if stars != "" {
+		typ += " " + stars
+	}
+	if len(arraySizes) > 0 {
+		typ += " "
+		for _, size := range arraySizes {
+			typ += "[" + size + "]"
+		}
+	}
+	return typ
+}
+
+// allocationCall returns the allocator call an expression evaluates to, looking through casts and parentheses
+func allocationCall(node *sitter.Node, content []byte) *sitter.Node {
+	for node != nil {
+		switch node.Kind() {
+		case "cast_expression":
+			node = node.ChildByFieldName("value")
+		case "parenthesized_expression":
+			node = node.NamedChild(0)
+		case "call_expression":
+			function := node.ChildByFieldName("function")
+			if function != nil && function.Kind() == "identifier" && IsAllocator(getNodeText(function, content)) {
+				return node
+			}
+			return nil
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// findAllocatingAssignments records "name = alloc(...)" assignments for variables without an allocating initializer
+func findAllocatingAssignments(node *sitter.Node, content []byte, varMap map[string]*Variable) {
 	for i := uint(0); i < node.ChildCount(); i++ {
 		child := node.Child(i)
-		if child.Kind() == "init_declarator" || child.Kind() == "identifier" {
-			varName := ""
-			if child.Kind() == "identifier" {
-				varName = getNodeText(child, content)
-			} else {
-				// init_declarator - find the identifier
-				identifier := findChildByType(child, "identifier")
-				if identifier != nil {
-					varName = getNodeText(identifier, content)
-				}
-			}
-			
-			if varName != "" && varMap[varName] == nil {
-				varMap[varName] = &Variable{
-					Name:   varName,
-					Origin: "local",
-					Type:   declarationType,
+
+		if child.Kind() == "assignment_expression" {
+			left := child.ChildByFieldName("left")
+			if left != nil && left.Kind() == "identifier" {
+				if v := varMap[getNodeText(left, content)]; v != nil && v.Allocator == "" {
+					if call := allocationCall(child.ChildByFieldName("right"), content); call != nil {
+						v.Allocator = getNodeText(call.ChildByFieldName("function"), content)
+						v.AllocCall = getNodeText(call, content)
+						v.AllocLine = int(call.StartPosition().Row) + 1
+					}
 				}
 			}
 		}
+
+		findAllocatingAssignments(child, content, varMap)
 	}
 }
 
@@ -495,13 +755,84 @@ func FindFunctionByID(directory, functionID string) (*Function, error) {
 		return nil, err
 	}
 	
-	for i := range result.Functions {
-		if result.Functions[i].ID == functionID {
-			return &result.Functions[i], nil
-		}
+	if function := result.FunctionByID(functionID); function != nil {
+		return function, nil
 	}
 	
 	return nil, fmt.Errorf("function not found: %s", functionID)
 }
 
+// FunctionByID returns the function with an ID, or nil
+func (r *AnalysisResult) FunctionByID(id string) *Function {
+	if r.functionIDs != nil {
+		if i, ok := r.functionIDs[id]; ok {
+			return &r.Functions[i]
+		}
+		return nil
+	}
+	for i := range r.Functions {
+		if r.Functions[i].ID == id {
+			return &r.Functions[i]
+		}
+	}
+	return nil
+}
+
+// LookupFunction finds a function definition by name, preferring one in the given file
+func (r *AnalysisResult) LookupFunction(name, filename string) *Function {
+	candidates := r.functionIndex[name]
+	if r.functionIndex == nil {
+		for i := range r.Functions {
+			candidates = append(candidates, i)
+		}
+	}
+	var match *Function
+	for _, i := range candidates {
+		function := &r.Functions[i]
+		if function.Name != name {
+			continue
+		}
+		if function.Filename == filename {
+			return function
+		}
+		if match == nil {
+			match = function
+		}
+	}
+	return match
+}
+
+// CallersOfFunction returns the functions that call a function: the callers of its name, less
+// those whose own file defines another function of that name, which their calls reach instead
+func (r *AnalysisResult) CallersOfFunction(function *Function) []*Function {
+	var callers []*Function
+	for _, caller := range r.CallersOf(function.Name) {
+		if local := r.LookupFunction(function.Name, caller.Filename); local != nil && local.Filename == caller.Filename && local.ID != function.ID {
+			continue
+		}
+		callers = append(callers, caller)
+	}
+	return callers
+}
+
+// CallersOf returns the functions that call a function by name, in definition order
+func (r *AnalysisResult) CallersOf(name string) []*Function {
+	var callers []*Function
+	if r.callerIndex != nil {
+		for _, i := range r.callerIndex[name] {
+			callers = append(callers, &r.Functions[i])
+		}
+		return callers
+	}
+	for i := range r.Functions {
+		for _, callee := range r.Functions[i].Callees {
+			if callee.Name == name {
+				callers = append(callers, &r.Functions[i])
+				break
+			}
+		}
+	}
+	return callers
+}
+
 
@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+const declaratorSource = `struct foo { int n; };
+void *kmalloc(unsigned long size, int flags);
+int declarators(int n, char *name)
+{
+	static const char *label = "x";
+	int counts[16], grid[4][8];
+	int (*handler)(int, int);
+	struct foo *p = kmalloc(sizeof(*p), 0);
+	struct foo *q;
+	char *buf = (char *)malloc(n);
+	unsigned long size = n;
+	q = kzalloc(sizeof(*q), 0);
+	char *lookup(int);
+	return 0;
+}
+`
+
+func TestVariableDeclarators(t *testing.T) {
+	function := parseC(t, declaratorSource)["declarators"]
+	if function == nil {
+		t.Fatal("declarators not parsed")
+	}
+	vars := make(map[string]Variable)
+	for _, v := range function.Vars {
+		vars[v.Name] = v
+	}
+
+	tests := []struct {
+		name string
+		want Variable
+	}{
+		{"n", Variable{Name: "n", Origin: "param", Type: "int", BaseType: "int"}},
+		{"name", Variable{Name: "name", Origin: "param", Type: "char *", BaseType: "char", PointerDepth: 1}},
+		{"label", Variable{Name: "label", Origin: "local", Type: "const char *", BaseType: "const char", Storage: "static", PointerDepth: 1, Line: 5, Init: `"x"`}},
+		{"counts", Variable{Name: "counts", Origin: "local", Type: "int [16]", BaseType: "int", ArraySizes: []string{"16"}, Line: 6}},
+		{"grid", Variable{Name: "grid", Origin: "local", Type: "int [4][8]", BaseType: "int", ArraySizes: []string{"4", "8"}, Line: 6}},
+		{"handler", Variable{Name: "handler", Origin: "local", Type: "int (*)(int, int)", BaseType: "int", PointerDepth: 1, FuncPointer: true, Line: 7}},
+		{"p", Variable{Name: "p", Origin: "local", Type: "struct foo *", BaseType: "struct foo", PointerDepth: 1, Line: 8,
+			Init: "kmalloc(sizeof(*p), 0)", Allocator: "kmalloc", AllocCall: "kmalloc(sizeof(*p), 0)", AllocLine: 8}},
+		{"q", Variable{Name: "q", Origin: "local", Type: "struct foo *", BaseType: "struct foo", PointerDepth: 1, Line: 9,
+			Allocator: "kzalloc", AllocCall: "kzalloc(sizeof(*q), 0)", AllocLine: 12}},
+		{"buf", Variable{Name: "buf", Origin: "local", Type: "char *", BaseType: "char", PointerDepth: 1, Line: 10,
+			Init: "(char *)malloc(n)", Allocator: "malloc", AllocCall: "malloc(n)", AllocLine: 10}},
+		{"size", Variable{Name: "size", Origin: "local", Type: "unsigned long", BaseType: "unsigned long", Line: 11, Init: "n"}},
+	}
+	for _, tt := range tests {
+		got, ok := vars[tt.name]
+		if !ok {
+			t.Errorf("%s not extracted", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := vars["lookup"]; ok {
+		t.Error("function prototype extracted as a variable")
+	}
+}
+
+// Pointer-returning functions wrap their function_declarator in pointer_declarators; allocation
+// tracing needs them parsed to follow constructors such as buf_alloc
+const pointerSource = `struct buf { char *data; };
+struct buf *buf_alloc(int n)
+{
+	return 0;
+}
+char **names(void)
+{
+	return 0;
+}
+static const char *label(int i)
+{
+	return "x";
+}
+int plain(void)
+{
+	return 0;
+}
+`
+
+func TestPointerReturningFunctions(t *testing.T) {
+	functions := parseC(t, pointerSource)
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{"buf_alloc", "struct buf *buf_alloc(int n)"},
+		{"names", "char **names(void)"},
+		{"label", "static const char *label(int i)"},
+		{"plain", "int plain(void)"},
+	}
+	for _, tt := range tests {
+		function, ok := functions[tt.name]
+		if !ok {
+			t.Errorf("%s not parsed", tt.name)
+			continue
+		}
+		if function.Signature != tt.signature {
+			t.Errorf("%s signature = %q, want %q", tt.name, function.Signature, tt.signature)
+		}
+	}
+}
+
+func TestParseParameterDeclaration(t *testing.T) {
+	tests := []struct {
+		text string
+		name string
+		typ  string
+	}{
+		{"int n", "n", "int"},
+		{"struct dev *dev", "dev", "struct dev *"},
+		{"void (*handler)(int)", "handler", "void (*)(int)"},
+		{"int (**table)(void *, int)", "table", "int (**)(void *, int)"},
+		{"void", "", "void"},
+	}
+	for _, tt := range tests {
+		param := parseParameterDeclaration(tt.text)
+		if param == nil || param.Name != tt.name || param.Type != tt.typ {
+			t.Errorf("parseParameterDeclaration(%q) = %+v, want name %q and type %q", tt.text, param, tt.name, tt.typ)
+		}
+	}
+}
+
+func TestParameterName(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"struct buf *b", "b"},
+		{"const char *name", "name"},
+		{"int table[16]", "table"},
+		{"unsigned long size_2", "size_2"},
+		{"void", "void"},
+	}
+	for _, tt := range tests {
+		if got := ParameterName(tt.snippet); got != tt.want {
+			t.Errorf("ParameterName(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
+
+func TestSyscallDefinitions(t *testing.T) {
+	functions := parseC(t, `SYSCALL_DEFINE3(read, unsigned int, fd, char *, buf, int, count)
+{
+	return ksys_read(fd, buf, count);
+}
+static COMPAT_SYSCALL_DEFINE1(close, int, fd)
+{
+	return 0;
+}
+`)
+	tests := []struct {
+		name    string
+		macro   string
+		aliases []string
+	}{
+		{"__do_sys_read", "SYSCALL_DEFINE3", []string{"__se_sys_read", "sys_read"}},
+		{"__do_compat_sys_close", "COMPAT_SYSCALL_DEFINE1", []string{"__se_compat_sys_close", "compat_sys_close"}},
+	}
+	for _, tt := range tests {
+		function, ok := functions[tt.name]
+		if !ok {
+			t.Errorf("%s not parsed", tt.name)
+			continue
+		}
+		if function.Macro != tt.macro || len(function.Aliases) != len(tt.aliases) {
+			t.Errorf("%s macro %q, aliases %v, want %q and %v", tt.name, function.Macro, function.Aliases, tt.macro, tt.aliases)
+			continue
+		}
+		for i := range tt.aliases {
+			if function.Aliases[i] != tt.aliases[i] {
+				t.Errorf("%s aliases = %v, want %v", tt.name, function.Aliases, tt.aliases)
+			}
+		}
+	}
+}
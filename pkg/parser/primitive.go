@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Use primitive classes
+const (
+	UseDoubleFree   = "double_free"   // The object is freed again
+	UseIndirectCall = "indirect_call" // A function pointer read from the object is called
+	UseCopyDest     = "memcpy_dest"   // The object is the destination of memcpy, strcpy and friends
+	UseArrayWrite   = "array_write"   // An element of the object is written
+	UseFieldWrite   = "field_write"   // A member of the object is written
+	UseWrite        = "write"         // The object is written through a dereference
+	UseArrayRead    = "array_read"
+	UseFieldRead    = "field_read"
+	UseRead         = "read"
+	UsePass         = "pass" // The object is only passed to another function
+)
+
+// useSeverity orders use classes from most to least severe
+var useSeverity = []string{
+	UseDoubleFree, UseIndirectCall, UseCopyDest, UseArrayWrite, UseFieldWrite, UseWrite,
+	UseArrayRead, UseFieldRead, UseRead, UsePass,
+}
+
+// copyFunctions write through their first argument
+var copyFunctions = map[string]bool{
+	"memcpy": true, "memmove": true, "memset": true, "strcpy": true, "strncpy": true, "strcat": true,
+	"strncat": true, "sprintf": true, "snprintf": true, "vsprintf": true, "vsnprintf": true,
+	"strlcpy": true, "strlcat": true, "copy_from_user": true, "__builtin_memcpy": true,
+}
+
+// UsePrimitive is the classified operation a use line performs on the freed object
+type UsePrimitive struct {
+	Class      string `json:"class"`
+	Expression string `json:"expr"`             // The use expression, e.g. "c->data->cb(c->data)"
+	Callee     string `json:"callee,omitempty"` // Function the object is passed to, for copies, frees and passes
+}
+
+// ClassifyUse finds the expressions on a line that refer to one of the objects and returns
+// the most severe operation among them. An object matches an expression it covers, or, when
+// nothing is covered, one whose last member has the object's name (CodeQL may report "data"
+// for "ctx->data").
+func ClassifyUse(function *Function, line int, objects []string) (*UsePrimitive, error) {
+	parsed, err := ParseFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	defer parsed.Close()
+
+	return parsed.ClassifyUse(line, objects), nil
+}
+
+// ClassifyUse is ClassifyUse on an already parsed function
+func (p *ParsedFunction) ClassifyUse(line int, objects []string) *UsePrimitive {
+	ast := p.ast
+
+	var exact, byName []*sitter.Node
+	collectObjectRefs(ast, ast.body, line, objects, &exact, &byName)
+	refs := exact
+	if len(refs) == 0 {
+		refs = byName
+	}
+
+	var best *UsePrimitive
+	rank := func(class string) int {
+		for i, candidate := range useSeverity {
+			if candidate == class {
+				return i
+			}
+		}
+		return len(useSeverity)
+	}
+	for _, ref := range refs {
+		primitive := classifyRef(ast, ref)
+		if best == nil || rank(primitive.Class) < rank(best.Class) {
+			best = primitive
+		}
+	}
+
+	return best
+}
+
+// collectObjectRefs gathers the outermost lvalues on a line that refer to one of the objects
+func collectObjectRefs(ast *functionAST, node *sitter.Node, line int, objects []string, exact, byName *[]*sitter.Node) {
+	if ast.startLine(node) > line || ast.endLine(node) < line {
+		return
+	}
+	if isLvalue(node) && ast.startLine(node) == line {
+		expr := NormalizeTarget(ast.text(node))
+		for _, object := range objects {
+			object = NormalizeTarget(object)
+			if object == "" {
+				continue
+			}
+			if TargetCovers(object, expr) {
+				*exact = append(*exact, node)
+				return
+			}
+			if expr == object || strings.HasSuffix(expr, "->"+object) || strings.HasSuffix(expr, "."+object) {
+				*byName = append(*byName, node)
+				return
+			}
+		}
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		collectObjectRefs(ast, node.NamedChild(i), line, objects, exact, byName)
+	}
+}
+
+// classifyRef classifies the operation around a reference to the object
+func classifyRef(ast *functionAST, ref *sitter.Node) *UsePrimitive {
+	// Widen to the whole access path, e.g. "c->data" in "c->data->cb"
+	outer := ref
+	for parent := outer.Parent(); parent != nil; parent = parent.Parent() {
+		widened := false
+		switch parent.Kind() {
+		case "field_expression", "subscript_expression":
+			if argument := parent.ChildByFieldName("argument"); argument != nil && argument.Id() == outer.Id() {
+				widened = true
+			}
+		case "pointer_expression":
+			if operator := parent.ChildByFieldName("operator"); operator != nil && ast.text(operator) == "*" {
+				widened = true
+			}
+		case "parenthesized_expression":
+			widened = true
+		}
+		if !widened {
+			break
+		}
+		outer = parent
+	}
+
+	primitive := &UsePrimitive{Expression: strings.TrimSpace(ast.text(outer))}
+	parent := outer.Parent()
+
+	// Passed as an argument
+	if parent != nil && parent.Kind() == "argument_list" {
+		call := parent.Parent()
+		name, direct := "", false
+		if function := call.ChildByFieldName("function"); function != nil {
+			name, direct = ast.text(function), function.Kind() == "identifier"
+		}
+		primitive.Callee = name
+		primitive.Expression = strings.TrimSpace(ast.text(call))
+		switch {
+		case direct && IsDeallocator(name):
+			primitive.Class = UseDoubleFree
+		case direct && copyFunctions[name] && parent.NamedChildCount() > 0 && parent.NamedChild(0).Id() == outer.Id():
+			primitive.Class = UseCopyDest
+		case direct && copyFunctions[name] && outer.Id() == ref.Id():
+			primitive.Class = UseArrayRead // The copy reads the object's contents
+		case outer.Id() != ref.Id():
+			primitive.Class = accessClass(outer, false)
+		default:
+			primitive.Class = UsePass
+		}
+		return primitive
+	}
+
+	if parent != nil && parent.Kind() == "call_expression" {
+		if function := parent.ChildByFieldName("function"); function != nil && function.Id() == outer.Id() {
+			primitive.Class = UseIndirectCall
+			primitive.Expression = strings.TrimSpace(ast.text(parent))
+			return primitive
+		}
+	}
+
+	written := false
+	if parent != nil {
+		switch parent.Kind() {
+		case "assignment_expression":
+			if left := parent.ChildByFieldName("left"); left != nil && left.Id() == outer.Id() {
+				written = true
+				primitive.Expression = strings.TrimSpace(ast.text(parent))
+			}
+		case "update_expression":
+			written = true
+			primitive.Expression = strings.TrimSpace(ast.text(parent))
+		}
+	}
+	primitive.Class = accessClass(outer, written)
+	return primitive
+}
+
+// accessClass names a read or write of an access path by its outermost operator
+func accessClass(node *sitter.Node, written bool) string {
+	for node.Kind() == "parenthesized_expression" && node.NamedChildCount() > 0 {
+		node = node.NamedChild(0)
+	}
+	switch node.Kind() {
+	case "subscript_expression":
+		if written {
+			return UseArrayWrite
+		}
+		return UseArrayRead
+	case "field_expression":
+		if written {
+			return UseFieldWrite
+		}
+		return UseFieldRead
+	}
+	if written {
+		return UseWrite
+	}
+	return UseRead
+}
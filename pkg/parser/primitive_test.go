@@ -0,0 +1,76 @@
+package parser
+
+import "testing"
+
+const primitiveSource = `struct ops { void (*cb)(void *); void (*free)(void *); };
+struct ctx { char *data; struct ops *ops; int len; };
+void kfree(const void *p);
+void consume(char *p);
+void *memcpy(void *dst, const void *src, unsigned long n);
+void uses(struct ctx *c, char *src, int i)
+{
+	kfree(c->data);
+	c->ops->cb(c);
+	memcpy(c->data, src, 4);
+	c->data[i] = 0;
+	c->len = 1;
+	*c->data = 1;
+	src[0] = c->data[i];
+	i = c->len;
+	i = *c->data;
+	consume(c->data);
+	consume(&c->data[1]);
+	c->data[0] = c->data[1];
+	memcpy(src, c->data, 4);
+	c->ops->free(c);
+}
+`
+
+func TestClassifyUse(t *testing.T) {
+	function := parseC(t, primitiveSource)["uses"]
+	tests := []struct {
+		line    int
+		objects []string
+		class   string
+		expr    string
+		callee  string
+	}{
+		{8, []string{"c->data"}, UseDoubleFree, "kfree(c->data)", "kfree"},
+		{9, []string{"c->ops"}, UseIndirectCall, "c->ops->cb(c)", ""},
+		{10, []string{"c->data"}, UseCopyDest, "memcpy(c->data, src, 4)", "memcpy"},
+		{11, []string{"c->data"}, UseArrayWrite, "c->data[i] = 0", ""},
+		{12, []string{"c"}, UseFieldWrite, "c->len = 1", ""},
+		{13, []string{"c->data"}, UseWrite, "*c->data = 1", ""},
+		{14, []string{"c->data"}, UseArrayRead, "c->data[i]", ""},
+		{15, []string{"c"}, UseFieldRead, "c->len", ""},
+		{16, []string{"c->data"}, UseRead, "*c->data", ""},
+		{17, []string{"c->data"}, UsePass, "consume(c->data)", "consume"},
+		{18, []string{"c->data"}, UseArrayRead, "c->data[1]", ""},
+		// The write outranks the read on the same line
+		{19, []string{"c->data"}, UseArrayWrite, "c->data[0] = c->data[1]", ""},
+		// A copy's source is read, not just passed
+		{20, []string{"c->data"}, UseArrayRead, "memcpy(src, c->data, 4)", "memcpy"},
+		// A free through a function pointer is a call through the object, not a double free
+		{21, []string{"c"}, UseIndirectCall, "c->ops->free(c)", ""},
+		// CodeQL may name only the last member
+		{11, []string{"data"}, UseArrayWrite, "c->data[i] = 0", ""},
+	}
+	for _, tt := range tests {
+		primitive, err := ClassifyUse(function, tt.line, tt.objects)
+		if err != nil {
+			t.Fatalf("ClassifyUse(L%d): %v", tt.line, err)
+		}
+		if primitive == nil {
+			t.Errorf("ClassifyUse(L%d, %v) = nil, want %s", tt.line, tt.objects, tt.class)
+			continue
+		}
+		want := UsePrimitive{Class: tt.class, Expression: tt.expr, Callee: tt.callee}
+		if *primitive != want {
+			t.Errorf("ClassifyUse(L%d, %v) = %+v, want %+v", tt.line, tt.objects, *primitive, want)
+		}
+	}
+
+	if primitive, err := ClassifyUse(function, 15, []string{"src"}); err != nil || primitive != nil {
+		t.Errorf("ClassifyUse(L15, src) = %+v, %v, want nil", primitive, err)
+	}
+}
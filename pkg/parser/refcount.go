@@ -0,0 +1,274 @@
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// refcountPrimitives are kernel and library functions that take ("get") or drop ("put") a reference
+var refcountPrimitives = map[string]string{
+	"kref_get":                 "get",
+	"kref_get_unless_zero":     "get",
+	"refcount_inc":             "get",
+	"refcount_inc_not_zero":    "get",
+	"atomic_inc":               "get",
+	"atomic_inc_not_zero":      "get",
+	"atomic_long_inc":          "get",
+	"get_device":               "get",
+	"kobject_get":              "get",
+	"sock_hold":                "get",
+	"dget":                     "get",
+	"get_task_struct":          "get",
+	"of_node_get":              "get",
+	"g_object_ref":             "get",
+	"kref_put":                 "put",
+	"kref_put_mutex":           "put",
+	"kref_put_lock":            "put",
+	"refcount_dec":             "put",
+	"refcount_dec_and_test":    "put",
+	"refcount_dec_and_lock":    "put",
+	"atomic_dec":               "put",
+	"atomic_dec_and_test":      "put",
+	"atomic_long_dec_and_test": "put",
+	"put_device":               "put",
+	"kobject_put":              "put",
+	"sock_put":                 "put",
+	"dput":                     "put",
+	"put_task_struct":          "put",
+	"of_node_put":              "put",
+	"g_object_unref":           "put",
+}
+
+// releaseCallbackPrimitives pass the release function as their second argument
+var releaseCallbackPrimitives = map[string]bool{
+	"kref_put":       true,
+	"kref_put_mutex": true,
+	"kref_put_lock":  true,
+}
+
+var (
+	// refcountFieldName matches member names used for reference counts
+	refcountFieldName = regexp.MustCompile(`^(kref|ref|refs|refcnt|refcount|ref_count|refcounter|nref|nrefs|usage|users|use_count)$`)
+
+	// refcountHelperName matches function names of get/put helpers, e.g. obj_get, put_ctx, buf_unref
+	refcountHelperName = regexp.MustCompile(`(^|_)(get|put|ref|unref|hold|release|grab|drop)(_|$)`)
+)
+
+// maxRefcountHelperLines bounds the length of a helper whose name gives no hint, so long
+// functions that merely take and drop references along the way are not mistaken for helpers
+const maxRefcountHelperLines = 15
+
+// RefcountField is a struct member counting references to its container
+type RefcountField struct {
+	Type      string `json:"type"`
+	Field     string `json:"field"`
+	FieldType string `json:"field_type"`
+}
+
+// RefcountHelper is a function that takes or drops a reference to an object
+type RefcountHelper struct {
+	Name       string `json:"name"`
+	FunctionID string `json:"func_id"`
+	Kind       string `json:"kind"`              // get or put
+	Via        string `json:"via"`               // Primitive, helper or "field++"/"field--" it is built on
+	Release    string `json:"release,omitempty"` // Function run when the last reference is dropped
+}
+
+// RefcountModel lists the reference counts and get/put helpers of a codebase
+type RefcountModel struct {
+	Fields  []RefcountField  `json:"fields"`
+	Helpers []RefcountHelper `json:"helpers"`
+
+	helpers map[string]int
+}
+
+// RefcountOp is a single reference taken or dropped inside a function
+type RefcountOp struct {
+	Kind    string `json:"kind"`   // get or put
+	Callee  string `json:"callee"` // Helper or primitive called, or the counter for a bare ++/--
+	Line    int    `json:"line"`
+	Snippet string `json:"snippet"`
+}
+
+// FindRefcounting detects reference-count members and the get/put helpers built on them.
+// Helpers are found by iterating to a fixpoint, so wrappers of helpers are included.
+func FindRefcounting(result *AnalysisResult) *RefcountModel {
+	model := &RefcountModel{helpers: make(map[string]int)}
+
+	counters := make(map[string]bool)
+	for _, typeDef := range result.Types {
+		if typeDef.Kind != "struct" && typeDef.Kind != "union" {
+			continue
+		}
+		for _, field := range typeDef.Fields {
+			if isRefcountField(field) {
+				model.Fields = append(model.Fields, RefcountField{Type: typeDef.Name, Field: field.Name, FieldType: field.Type})
+				counters[field.Name] = true
+			}
+		}
+	}
+
+	for round := 0; round < maxInferenceRounds; round++ {
+		changed := false
+		for i := range result.Functions {
+			function := &result.Functions[i]
+			if _, ok := model.helpers[function.Name]; ok || refcountPrimitives[function.Name] != "" {
+				continue
+			}
+			if helper, ok := model.inferHelper(function, counters); ok {
+				model.helpers[function.Name] = len(model.Helpers)
+				model.Helpers = append(model.Helpers, helper)
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	sort.Slice(model.Fields, func(i, j int) bool {
+		return model.Fields[i].Type+"."+model.Fields[i].Field < model.Fields[j].Type+"."+model.Fields[j].Field
+	})
+	return model
+}
+
+// isRefcountField reports whether a struct member looks like a reference count
+func isRefcountField(field Variable) bool {
+	fieldType := NormalizeTypeName(field.Type)
+	if fieldType == "struct kref" || fieldType == "refcount_t" || fieldType == "kref_t" {
+		return true
+	}
+	if !refcountFieldName.MatchString(field.Name) || strings.Contains(field.Type, "*") {
+		return false
+	}
+	return strings.HasPrefix(fieldType, "atomic") || strings.Contains(fieldType, "int") || strings.Contains(fieldType, "long")
+}
+
+// inferHelper reports whether a function is a get or put helper: all of its reference
+// operations are of one kind, and either its name says so or it is short
+func (m *RefcountModel) inferHelper(function *Function, counters map[string]bool) (RefcountHelper, bool) {
+	ops := m.Operations(function, counters)
+	if len(ops) == 0 {
+		return RefcountHelper{}, false
+	}
+	kind := ops[0].Kind
+	for _, op := range ops[1:] {
+		if op.Kind != kind {
+			return RefcountHelper{}, false
+		}
+	}
+	if !refcountHelperName.MatchString(strings.ToLower(function.Name)) && function.EndLine-function.StartLine+1 > maxRefcountHelperLines {
+		return RefcountHelper{}, false
+	}
+
+	helper := RefcountHelper{Name: function.Name, FunctionID: function.ID, Kind: kind, Via: ops[0].Callee}
+	if kind == "put" {
+		helper.Release = m.releaseOf(function)
+	}
+	return helper, true
+}
+
+// releaseOf returns the function run when a put helper drops the last reference: the callback
+// handed to kref_put, a nested helper's release, or the helper itself when it frees directly
+func (m *RefcountModel) releaseOf(function *Function) string {
+	for _, callee := range function.Callees {
+		if releaseCallbackPrimitives[callee.Name] && len(callee.Args) > 1 {
+			return strings.TrimLeft(strings.TrimSpace(callee.Args[1]), "&")
+		}
+		if helper := m.Helper(callee.Name); helper != nil && helper.Kind == "put" && helper.Release != "" {
+			return helper.Release
+		}
+	}
+	for _, callee := range function.Callees {
+		if IsDeallocator(callee.Name) {
+			return function.Name
+		}
+	}
+	return ""
+}
+
+// Helper returns the get/put helper with the given name, or nil
+func (m *RefcountModel) Helper(name string) *RefcountHelper {
+	if m == nil {
+		return nil
+	}
+	if i, ok := m.helpers[name]; ok {
+		return &m.Helpers[i]
+	}
+	return nil
+}
+
+// ReleasedBy returns the put helpers whose last reference runs the given function
+func (m *RefcountModel) ReleasedBy(name string) []RefcountHelper {
+	var helpers []RefcountHelper
+	for _, helper := range m.Helpers {
+		if helper.Kind == "put" && helper.Release == name && helper.Name != name {
+			helpers = append(helpers, helper)
+		}
+	}
+	return helpers
+}
+
+// counterOpPattern matches a bare increment or decrement of a member, e.g. "o->refs++" or "--o->refs"
+var counterOpPattern = regexp.MustCompile(`(\+\+|--)\s*[\w\]\)]+(?:->|\.)(\w+)|(?:->|\.)(\w+)\s*(\+\+|--)|(?:->|\.)(\w+)\s*(\+|-)=\s*1\b`)
+
+// counterMemberPattern captures the last member of an atomic's argument, e.g. "refs" in "&obj->refs"
+var counterMemberPattern = regexp.MustCompile(`(?:->|\.)(\w+)$`)
+
+// countsReferences reports whether an atomic primitive works on a refcount member rather than
+// on some other counter, such as statistics or a sequence number
+func countsReferences(args []string, counters map[string]bool) bool {
+	if len(args) == 0 {
+		return false
+	}
+	match := counterMemberPattern.FindStringSubmatch(strings.TrimSpace(StripCasts(args[0])))
+	return match != nil && counters[match[1]]
+}
+
+// Operations lists the references a function takes and drops: calls to primitives and helpers,
+// and bare increments or decrements of a refcount member
+func (m *RefcountModel) Operations(function *Function, counters map[string]bool) []RefcountOp {
+	if counters == nil {
+		counters = make(map[string]bool)
+		for _, field := range m.Fields {
+			counters[field.Field] = true
+		}
+	}
+
+	var ops []RefcountOp
+	for _, callee := range function.Callees {
+		kind := refcountPrimitives[callee.Name]
+		if helper := m.Helper(callee.Name); helper != nil {
+			kind = helper.Kind
+		} else if strings.HasPrefix(callee.Name, "atomic") && !countsReferences(callee.Args, counters) {
+			continue
+		}
+		if kind == "get" || kind == "put" {
+			ops = append(ops, RefcountOp{Kind: kind, Callee: callee.Name, Line: callee.Line, Snippet: callee.Snippet})
+		}
+	}
+
+	for i, line := range strings.Split(function.Definition, "\n") {
+		for _, match := range counterOpPattern.FindAllStringSubmatch(line, -1) {
+			field, op := match[2], match[1]
+			if field == "" {
+				field, op = match[3], match[4]
+			}
+			if field == "" {
+				field, op = match[5], match[6]+match[6]
+			}
+			if !counters[field] {
+				continue
+			}
+			kind := "get"
+			if op == "--" {
+				kind = "put"
+			}
+			ops = append(ops, RefcountOp{Kind: kind, Callee: field + op, Line: function.StartLine + i, Snippet: strings.TrimSpace(line)})
+		}
+	}
+
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Line < ops[j].Line })
+	return ops
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+const refcountSource = `typedef struct { int counter; } atomic_t;
+struct kref { atomic_t refcount; };
+struct obj {
+	struct kref kref;
+	atomic_t stats;
+	char *name;
+};
+struct conn {
+	atomic_t users;
+	int refs;
+};
+void kfree(const void *p);
+void kref_get(struct kref *kref);
+int kref_put(struct kref *kref, void (*release)(struct kref *kref));
+void atomic_inc(atomic_t *v);
+void obj_release(struct kref *kref)
+{
+	kfree(kref);
+}
+void obj_get(struct obj *o)
+{
+	kref_get(&o->kref);
+}
+void obj_put(struct obj *o)
+{
+	kref_put(&o->kref, obj_release);
+}
+void obj_drop(struct obj *o)
+{
+	obj_put(o);
+}
+void stats_bump(struct obj *o)
+{
+	atomic_inc(&o->stats);
+}
+void conn_hold(struct conn *c)
+{
+	atomic_inc(&c->users);
+}
+void conn_grab(struct conn *c)
+{
+	c->refs++;
+}
+`
+
+func TestFindRefcountingFields(t *testing.T) {
+	model := FindRefcounting(analyzeC(t, refcountSource))
+	want := []RefcountField{
+		{Type: "struct conn", Field: "refs", FieldType: "int"},
+		{Type: "struct conn", Field: "users", FieldType: "atomic_t"},
+		{Type: "struct kref", Field: "refcount", FieldType: "atomic_t"},
+		{Type: "struct obj", Field: "kref", FieldType: "struct kref"},
+	}
+	if !reflect.DeepEqual(model.Fields, want) {
+		t.Errorf("fields = %+v, want %+v", model.Fields, want)
+	}
+}
+
+func TestFindRefcountingHelpers(t *testing.T) {
+	model := FindRefcounting(analyzeC(t, refcountSource))
+	tests := []struct {
+		name    string
+		kind    string
+		via     string
+		release string
+	}{
+		{"obj_get", "get", "kref_get", ""},
+		{"obj_put", "put", "kref_put", "obj_release"},
+		{"obj_drop", "put", "obj_put", "obj_release"},
+		{"conn_hold", "get", "atomic_inc", ""},
+		{"conn_grab", "get", "refs++", ""},
+	}
+	for _, tt := range tests {
+		helper := model.Helper(tt.name)
+		if helper == nil {
+			t.Errorf("%s not detected as a helper", tt.name)
+			continue
+		}
+		if helper.Kind != tt.kind || helper.Via != tt.via || helper.Release != tt.release {
+			t.Errorf("%s = %+v, want kind %q, via %q and release %q", tt.name, *helper, tt.kind, tt.via, tt.release)
+		}
+	}
+
+	for _, name := range []string{"stats_bump", "obj_release"} {
+		if helper := model.Helper(name); helper != nil {
+			t.Errorf("%s detected as a helper: %+v", name, *helper)
+		}
+	}
+
+	var released []string
+	for _, helper := range model.ReleasedBy("obj_release") {
+		released = append(released, helper.Name)
+	}
+	if !reflect.DeepEqual(released, []string{"obj_put", "obj_drop"}) {
+		t.Errorf("ReleasedBy(obj_release) = %v, want [obj_put obj_drop]", released)
+	}
+}
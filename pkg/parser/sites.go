@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// SiteContext classifies the code around a free or use line
+type SiteContext struct {
+	Line         int    `json:"line"`
+	Destructor   bool   `json:"destructor,omitempty"`    // The function looks like a destructor, e.g. *_destroy or *_release
+	CleanupLabel string `json:"cleanup_label,omitempty"` // goto target whose block holds the line, e.g. "out_free"
+	ErrorPath    bool   `json:"error_path,omitempty"`    // The line only runs on failure
+	ErrorCheck   string `json:"error_check,omitempty"`   // Failure check guarding the line, e.g. "(!buf)"
+}
+
+// ClassifySite reports whether a line sits in a destructor, in a goto cleanup block, or on a
+// path that only runs on failure: behind a failed check, or with only error returns after it
+func ClassifySite(function *Function, line int) (*SiteContext, error) {
+	parsed, err := ParseFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	defer parsed.Close()
+
+	return parsed.ClassifySite(line), nil
+}
+
+// ClassifySite is ClassifySite on an already parsed function
+func (p *ParsedFunction) ClassifySite(line int) *SiteContext {
+	cfg := p.CFG()
+	site := &SiteContext{
+		Line:       line,
+		Destructor: IsDestructor(p.Function.Name),
+	}
+
+	target := cfg.NodeAtLine(line)
+	if target < 0 {
+		return site
+	}
+
+	site.CleanupLabel = cleanupLabel(p.ast, p.syntax, cfg, target)
+
+	for _, guard := range p.FindGuards([]int{line})[line] {
+		if isErrorCheck(guard.Condition, guard.Branch) {
+			site.ErrorCheck = guard.Condition
+			site.ErrorPath = true
+		}
+	}
+	if !site.ErrorPath {
+		site.ErrorPath = onlyErrorReturns(cfg, target)
+	}
+
+	return site
+}
+
+// cleanupLabel returns the goto-targeted label whose block a node falls in: the closest label
+// before it in the same or an enclosing block
+func cleanupLabel(ast *functionAST, syntax []*sitter.Node, cfg *CFG, target int) string {
+	targeted := make(map[string]bool)
+	for id, node := range cfg.Nodes {
+		if node.Kind == "goto" {
+			if label := syntax[id].ChildByFieldName("label"); label != nil {
+				targeted[ast.text(label)] = true
+			}
+		}
+	}
+
+	node := syntax[target]
+	for node != nil && node.Id() != ast.body.Id() {
+		if node.Kind() == "labeled_statement" {
+			if label := node.ChildByFieldName("label"); label != nil && targeted[ast.text(label)] {
+				return ast.text(label)
+			}
+		}
+		for sibling := node.PrevNamedSibling(); sibling != nil; sibling = sibling.PrevNamedSibling() {
+			if sibling.Kind() != "labeled_statement" {
+				continue
+			}
+			if label := sibling.ChildByFieldName("label"); label != nil && targeted[ast.text(label)] {
+				return ast.text(label)
+			}
+		}
+		node = node.Parent()
+	}
+	return ""
+}
+
+// errorCheckPattern matches conditions that hold when something failed: "!p", "p == NULL",
+// "ret < 0", "IS_ERR(p)", "err", "rc != 0"
+var errorCheckPattern = regexp.MustCompile(`^(!\w[\w.>-]*|\w[\w.>-]*==NULL|NULL==\w[\w.>-]*|\w+<0|IS_ERR(_OR_NULL)?\(.*\)|(err|error|ret|rc|rv)(!=0)?)$`)
+
+// successCheckPattern matches conditions that hold when something succeeded
+var successCheckPattern = regexp.MustCompile(`^(\w[\w.>-]*!=NULL|\w+>=0|(err|error|ret|rc|rv)==0)$`)
+
+// isErrorCheck reports whether taking a branch of a condition means something failed
+func isErrorCheck(condition, branch string) bool {
+	condition = NormalizeTarget(condition)
+	for _, wrapper := range []string{"unlikely(", "likely("} {
+		if strings.HasPrefix(condition, wrapper) && strings.HasSuffix(condition, ")") {
+			condition = NormalizeTarget(condition[len(wrapper) : len(condition)-1])
+		}
+	}
+	switch branch {
+	case "true":
+		return errorCheckPattern.MatchString(condition)
+	case "false":
+		return successCheckPattern.MatchString(condition)
+	}
+	return false
+}
+
+// errorReturnPattern matches return values that signal failure
+var errorReturnPattern = regexp.MustCompile(`^return(-\w+|NULL|false|ERR_PTR\(.*\)|\(-\w+\))?;$`)
+
+// onlyErrorReturns reports whether every return reachable from a node returns a failure value
+func onlyErrorReturns(cfg *CFG, from int) bool {
+	if node := cfg.Nodes[from]; node.Kind == "return" {
+		return isErrorReturn(node.Text)
+	}
+
+	visited := make([]bool, len(cfg.Nodes))
+	stack := []int{from}
+	returns := 0
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, edge := range cfg.Nodes[current].Succs {
+			if visited[edge.To] {
+				continue
+			}
+			visited[edge.To] = true
+			node := cfg.Nodes[edge.To]
+			switch node.Kind {
+			case "exit":
+				// Falling off the end returns nothing meaningful
+				return false
+			case "return":
+				if !isErrorReturn(node.Text) {
+					return false
+				}
+				returns++
+				continue
+			}
+			stack = append(stack, edge.To)
+		}
+	}
+	return returns > 0
+}
+
+// isErrorReturn reports whether a return statement returns a failure value
+func isErrorReturn(statement string) bool {
+	text := strings.Join(strings.Fields(statement), "")
+	return text != "return;" && errorReturnPattern.MatchString(text)
+}
@@ -0,0 +1,109 @@
+package parser
+
+import "testing"
+
+const siteSource = `struct dev { char *buf; char *name; };
+void kfree(const void *p);
+void *kmalloc(unsigned long size, int flags);
+int dev_setup(struct dev *dev)
+{
+	dev->buf = kmalloc(16, 0);
+	if (!dev->buf)
+		goto out;
+	dev->name = kmalloc(8, 0);
+	if (dev->name == NULL)
+		goto out_free_buf;
+	return 0;
+out_free_buf:
+	kfree(dev->buf);
+out:
+	return -ENOMEM;
+}
+int dev_check(struct dev *dev, int ret)
+{
+	if (ret < 0) {
+		kfree(dev->name);
+		return ret;
+	}
+	kfree(dev->buf);
+	return 0;
+}
+int dev_fail(struct dev *dev)
+{
+	kfree(dev->buf);
+	return -EINVAL;
+}
+void dev_destroy(struct dev *dev)
+{
+	kfree(dev->buf);
+}
+`
+
+func TestClassifySite(t *testing.T) {
+	functions := parseC(t, siteSource)
+	tests := []struct {
+		function string
+		line     int
+		want     SiteContext
+	}{
+		{"dev_setup", 14, SiteContext{CleanupLabel: "out_free_buf", ErrorPath: true, ErrorCheck: "(dev->name == NULL)"}},
+		{"dev_setup", 16, SiteContext{CleanupLabel: "out", ErrorPath: true}},
+		{"dev_setup", 9, SiteContext{}},
+		{"dev_setup", 8, SiteContext{ErrorPath: true, ErrorCheck: "(!dev->buf)"}},
+		{"dev_setup", 11, SiteContext{ErrorPath: true, ErrorCheck: "(dev->name == NULL)"}},
+		{"dev_check", 21, SiteContext{ErrorPath: true, ErrorCheck: "(ret < 0)"}},
+		{"dev_check", 24, SiteContext{}},
+		{"dev_fail", 29, SiteContext{ErrorPath: true}},
+		{"dev_destroy", 33, SiteContext{Destructor: true}},
+	}
+	for _, tt := range tests {
+		site, err := ClassifySite(functions[tt.function], tt.line)
+		if err != nil {
+			t.Fatalf("ClassifySite(%s, %d): %v", tt.function, tt.line, err)
+		}
+		tt.want.Line = tt.line
+		if *site != tt.want {
+			t.Errorf("ClassifySite(%s, %d) = %+v, want %+v", tt.function, tt.line, *site, tt.want)
+		}
+	}
+}
+
+func TestIsErrorCheck(t *testing.T) {
+	tests := []struct {
+		condition, branch string
+		want              bool
+	}{
+		{"(!p)", "true", true},
+		{"(!p)", "false", false},
+		{"(p == NULL)", "true", true},
+		{"(ret < 0)", "true", true},
+		{"(unlikely(err))", "true", true},
+		{"(IS_ERR(dev))", "true", true},
+		{"(p != NULL)", "false", true},
+		{"(ret == 0)", "false", true},
+		{"(count > 4)", "true", false},
+		{"(mode)", "2", false},
+	}
+	for _, tt := range tests {
+		if got := isErrorCheck(tt.condition, tt.branch); got != tt.want {
+			t.Errorf("isErrorCheck(%q, %q) = %v, want %v", tt.condition, tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestIsDestructor(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"dev_destroy", true},
+		{"ctx_release", true},
+		{"free_netdev", true},
+		{"dev_setup", false},
+	}
+	for _, tt := range tests {
+		if got := IsDestructor(tt.name); got != tt.want {
+			t.Errorf("IsDestructor(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
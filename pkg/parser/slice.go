@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// SliceCriterion selects what a function slice is built around
+type SliceCriterion struct {
+	Lines   []int    // Lines that are always kept, e.g. the free, the use and chain call sites
+	Objects []string // Object expressions to follow, e.g. "ctx->data"
+}
+
+// maxSliceObjects caps how many aliases a slice follows
+const maxSliceObjects = 32
+
+// SliceFunction returns the function's numbered definition reduced to the statements that
+// touch the criterion's objects or their aliases, the criterion lines, and the control
+// structures around them. Elided runs are replaced by "..." markers.
+func SliceFunction(function *Function, criterion SliceCriterion) (string, error) {
+	parsed, err := ParseFunction(function)
+	if err != nil {
+		return "", err
+	}
+	defer parsed.Close()
+
+	return parsed.Slice(criterion), nil
+}
+
+// Slice is SliceFunction on an already parsed function
+func (p *ParsedFunction) Slice(criterion SliceCriterion) string {
+	function, ast := p.Function, p.ast
+	dataflow := p.Dataflow()
+	syntax := p.syntax
+	cfg := dataflow.CFG
+
+	// Follow copies in both directions: "q = p" makes q an alias of p, and "p = q" means
+	// the object came from q
+	objects := make(map[string]bool)
+	for _, object := range criterion.Objects {
+		if object = NormalizeTarget(object); object != "" {
+			objects[object] = true
+		}
+	}
+	for changed := true; changed && len(objects) < maxSliceObjects; {
+		changed = false
+		for _, def := range dataflow.Defs {
+			if def.Source == "" {
+				continue
+			}
+			if objects[def.Source] && !objects[def.Target] {
+				objects[def.Target] = true
+				changed = true
+			}
+			if objects[def.Target] && !objects[def.Source] {
+				objects[def.Source] = true
+				changed = true
+			}
+		}
+	}
+
+	relevant := make([]bool, len(cfg.Nodes))
+	for _, line := range criterion.Lines {
+		if id := cfg.NodeAtLine(line); id >= 0 {
+			relevant[id] = true
+		}
+	}
+	for id, node := range syntax {
+		if node == nil || relevant[id] {
+			continue
+		}
+		switch cfg.Nodes[id].Kind {
+		case "stmt", "cond", "return":
+			relevant[id] = mentionsObject(ast, node, objects)
+		}
+		for _, def := range dataflow.DefsAt(id) {
+			for object := range objects {
+				if TargetCovers(def.Target, object) {
+					relevant[id] = true
+				}
+			}
+		}
+	}
+
+	keep := make(map[int]bool)
+	keepRange := func(start, end int) {
+		for line := start; line <= end; line++ {
+			keep[line] = true
+		}
+	}
+
+	// Signature up to the opening brace, and the closing brace
+	keepRange(function.StartLine, ast.startLine(ast.body))
+	keep[ast.endLine(ast.body)] = true
+
+	keptStructures := make(map[uintptr]bool)
+	for id, node := range syntax {
+		if !relevant[id] {
+			continue
+		}
+		keepRange(cfg.Nodes[id].Line, cfg.Nodes[id].EndLine)
+		for parent := node.Parent(); parent != nil && parent.Id() != ast.body.Id(); parent = parent.Parent() {
+			keepStructure(ast, parent, keepRange)
+			keptStructures[parent.Id()] = true
+		}
+	}
+
+	// Jumps decide which paths reach the kept statements, so keep those in kept structures
+	for id, node := range syntax {
+		switch cfg.Nodes[id].Kind {
+		case "return", "goto", "break", "continue", "label":
+		default:
+			continue
+		}
+		if owner := controlOwner(node, ast.body); owner == nil || keptStructures[owner.Id()] {
+			keepRange(cfg.Nodes[id].Line, cfg.Nodes[id].EndLine)
+		}
+	}
+
+	return renderSlice(function, keep)
+}
+
+// mentionsObject reports whether a statement reads or writes one of the objects or anything
+// reached through them
+func mentionsObject(ast *functionAST, node *sitter.Node, objects map[string]bool) bool {
+	if isLvalue(node) {
+		expr := NormalizeTarget(ast.text(node))
+		for object := range objects {
+			if TargetCovers(object, expr) {
+				return true
+			}
+		}
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if mentionsObject(ast, node.NamedChild(i), objects) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepStructure keeps the header and braces of a control structure enclosing a kept statement
+func keepStructure(ast *functionAST, node *sitter.Node, keepRange func(start, end int)) {
+	start := ast.startLine(node)
+	switch node.Kind() {
+	case "if_statement", "while_statement", "switch_statement", "for_statement":
+		end := start
+		for _, field := range []string{"condition", "update"} {
+			if child := node.ChildByFieldName(field); child != nil && ast.endLine(child) > end {
+				end = ast.endLine(child)
+			}
+		}
+		keepRange(start, end)
+	case "do_statement":
+		keepRange(start, start)
+		if condition := node.ChildByFieldName("condition"); condition != nil {
+			keepRange(ast.startLine(condition), ast.endLine(node))
+		}
+	case "compound_statement":
+		keepRange(start, start)
+		keepRange(ast.endLine(node), ast.endLine(node))
+	case "else_clause", "case_statement", "labeled_statement":
+		keepRange(start, start)
+	}
+}
+
+// controlOwner returns the closest statement that decides whether a node runs, or nil at the top level
+func controlOwner(node *sitter.Node, body *sitter.Node) *sitter.Node {
+	for parent := node.Parent(); parent != nil && parent.Id() != body.Id(); parent = parent.Parent() {
+		switch parent.Kind() {
+		case "if_statement", "else_clause", "while_statement", "do_statement", "for_statement",
+			"switch_statement", "case_statement":
+			return parent
+		}
+	}
+	return nil
+}
+
+// renderSlice numbers the kept lines like DefinitionWithLineNumbers and collapses each run of
+// elided lines into an indented "..." marker
+func renderSlice(function *Function, keep map[int]bool) string {
+	lines := strings.Split(function.Definition, "\n")
+	var result strings.Builder
+	elided := false
+	for i, text := range lines {
+		line := function.StartLine + i
+		if !keep[line] {
+			if !elided && strings.TrimSpace(text) != "" {
+				indent := text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+				result.WriteString(fmt.Sprintf("%5s  %s...\n", "", indent))
+				elided = true
+			}
+			continue
+		}
+		elided = false
+		result.WriteString(fmt.Sprintf("%5d  %s\n", line, text))
+	}
+
+	return strings.TrimSuffix(result.String(), "\n")
+}
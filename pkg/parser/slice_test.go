@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const sliceSource = `struct ctx { char *data; int len; };
+void kfree(const void *p);
+void log_msg(const char *msg);
+int process(struct ctx *ctx, int flags)
+{
+	int count = 0;
+	char *buf = ctx->data;
+	log_msg("start");
+	count++;
+	if (flags) {
+		log_msg("flags");
+		kfree(buf);
+		return -1;
+	}
+	while (count < 4)
+		count++;
+	ctx->len = 0;
+	return count;
+}
+`
+
+// keptLines returns the line numbers a rendered slice keeps and how many elision markers it has
+func keptLines(slice string) ([]int, int) {
+	var lines []int
+	markers := 0
+	for _, text := range strings.Split(slice, "\n") {
+		if strings.TrimSpace(text) == "..." {
+			markers++
+			continue
+		}
+		if line, err := strconv.Atoi(strings.TrimSpace(text[:5])); err == nil {
+			lines = append(lines, line)
+		}
+	}
+	return lines, markers
+}
+
+func TestSliceFunction(t *testing.T) {
+	function := parseC(t, sliceSource)["process"]
+	tests := []struct {
+		name      string
+		criterion SliceCriterion
+		lines     []int
+		markers   int
+	}{
+		{
+			// buf aliases ctx->data, so the free through it and the branch around it stay; top-level
+			// returns are always kept
+			name:      "alias",
+			criterion: SliceCriterion{Lines: []int{12}, Objects: []string{"ctx->data"}},
+			lines:     []int{4, 5, 7, 10, 12, 13, 14, 18, 19},
+			markers:   4,
+		},
+		{
+			// ctx->len is a member of ctx, so only the statements reaching through ctx stay
+			name:      "member",
+			criterion: SliceCriterion{Objects: []string{"ctx"}},
+			lines:     []int{4, 5, 7, 17, 18, 19},
+			markers:   2,
+		},
+		{
+			name:      "lines only",
+			criterion: SliceCriterion{Lines: []int{16}},
+			lines:     []int{4, 5, 15, 16, 18, 19},
+			markers:   2,
+		},
+	}
+	for _, tt := range tests {
+		slice, err := SliceFunction(function, tt.criterion)
+		if err != nil {
+			t.Fatalf("%s: SliceFunction: %v", tt.name, err)
+		}
+		lines, markers := keptLines(slice)
+		if fmt.Sprint(lines) != fmt.Sprint(tt.lines) || markers != tt.markers {
+			t.Errorf("%s: kept lines %v with %d markers, want %v with %d\n%s", tt.name, lines, markers, tt.lines, tt.markers, slice)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// syscallMacros maps the Linux system call definition macros to the prefix of the system call
+// they define. SYSCALL_DEFINE3(read, ...) expands to __se_sys_read, which calls __do_sys_read
+// holding the body; older kernels and the syscall tables call it sys_read.
+var syscallMacros = map[string]string{
+	"SYSCALL_DEFINE":        "sys_",
+	"COMPAT_SYSCALL_DEFINE": "compat_sys_",
+}
+
+// syscallMacro returns the function name prefix for a system call definition macro such as
+// SYSCALL_DEFINE3, or false for any other name
+func syscallMacro(name string) (string, bool) {
+	base := strings.TrimRight(name, "0123456789")
+	prefix, ok := syscallMacros[base]
+	return prefix, ok && len(base) < len(name)
+}
+
+// findSyscallDefinitions collects functions defined through SYSCALL_DEFINEn macros, which
+// tree-sitter cannot read as function definitions. Without a storage class the macro parses as
+// a call statement followed by a bare block; with one, as a function definition whose type is
+// the macro.
+func findSyscallDefinitions(node *sitter.Node, content []byte, filename string) []Function {
+	var functions []Function
+
+	for i := uint(0); i < node.ChildCount(); i++ {
+		child := node.Child(i)
+
+		var macro, body *sitter.Node
+		switch child.Kind() {
+		case "expression_statement":
+			next := child.NextSibling()
+			call := child.NamedChild(0)
+			if next != nil && next.Kind() == "compound_statement" && call != nil && call.Kind() == "call_expression" {
+				macro, body = call, next
+			}
+		case "function_definition":
+			macro = findChildByType(child, "macro_type_specifier")
+			body = child.ChildByFieldName("body")
+		}
+
+		if macro != nil && body != nil {
+			if function := analyzeSyscallDefinition(child, macro, body, content, filename); function != nil {
+				functions = append(functions, *function)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(child.Kind(), "preproc_") {
+			functions = append(functions, findSyscallDefinitions(child, content, filename)...)
+		}
+	}
+
+	return functions
+}
+
+// analyzeSyscallDefinition builds a function from a system call definition macro and its body,
+// naming it after the expanded function holding the body, as CodeQL does, and aliasing it by
+// the wrapper and plain system call names
+func analyzeSyscallDefinition(start, macro, body *sitter.Node, content []byte, filename string) *Function {
+	text := getNodeText(macro, content)
+	open := strings.Index(text, "(")
+	if open < 0 || !strings.HasSuffix(text, ")") {
+		return nil
+	}
+	prefix, ok := syscallMacro(strings.TrimSpace(text[:open]))
+	if !ok {
+		return nil
+	}
+
+	// SYSCALL_DEFINE3(read, unsigned int, fd, char __user *, buf, size_t, count)
+	args := strings.Split(text[open+1:len(text)-1], ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	if !isIdentifier(args[0]) {
+		return nil
+	}
+	var params []Parameter
+	for i := 1; i+1 < len(args); i += 2 {
+		params = append(params, Parameter{
+			Snippet: args[i] + " " + args[i+1],
+			Name:    args[i+1],
+			Type:    args[i],
+		})
+	}
+
+	startLine := int(start.StartPosition().Row) + 1
+	definition := string(content[start.StartByte():body.EndByte()])
+	name := "__do_" + prefix + args[0]
+	function := &Function{
+		ID:                        fmt.Sprintf("%s:%d:%s", filename, startLine, name),
+		Filename:                  filename,
+		Name:                      name,
+		StartLine:                 startLine,
+		EndLine:                   int(body.EndPosition().Row) + 1,
+		Signature:                 text,
+		Macro:                     strings.TrimSpace(text[:open]),
+		Aliases:                   []string{"__se_" + prefix + args[0], prefix + args[0]},
+		Definition:                definition,
+		DefinitionWithLineNumbers: addLineNumbers(definition, startLine),
+		Length:                    len(definition),
+		Params:                    append([]Parameter{}, params...),
+		Callees:                   []Callee{},
+		Vars:                      []Variable{},
+	}
+	analyzeFunctionBody(function, body, content)
+	return function
+}
@@ -0,0 +1,246 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// TypeDef is a struct, union, enum or typedef definition
+type TypeDef struct {
+	Name                      string     `json:"name"` // e.g. "struct ctx", "enum state", "ctx_t"
+	Kind                      string     `json:"kind"` // struct, union, enum, typedef
+	Filename                  string     `json:"file"`
+	StartLine                 int        `json:"start"`
+	EndLine                   int        `json:"end"`
+	Definition                string     `json:"def"`
+	DefinitionWithLineNumbers string     `json:"def_ln"`
+	Underlying                string     `json:"underlying,omitempty"` // typedef target type
+	Fields                    []Variable `json:"fields,omitempty"`     // struct/union members or enumerators
+}
+
+// findTypeDefinitions finds struct, union and enum definitions with a body, plus typedefs,
+// outside of function bodies
+func findTypeDefinitions(node *sitter.Node, content []byte, filename string) []TypeDef {
+	var types []TypeDef
+
+	for i := uint(0); i < node.ChildCount(); i++ {
+		child := node.Child(i)
+		switch child.Kind() {
+		case "function_definition":
+			continue
+		case "struct_specifier", "union_specifier", "enum_specifier":
+			if child.ChildByFieldName("body") != nil && child.ChildByFieldName("name") != nil {
+				types = append(types, newTypeDef(child, content, filename, typeSpecifierName(child, content), strings.TrimSuffix(child.Kind(), "_specifier")))
+			}
+		case "type_definition":
+			types = append(types, extractTypedefs(child, content, filename)...)
+		}
+		types = append(types, findTypeDefinitions(child, content, filename)...)
+	}
+
+	return types
+}
+
+// newTypeDef builds a TypeDef from a definition node, extracting fields from any body it has
+func newTypeDef(node *sitter.Node, content []byte, filename, name, kind string) TypeDef {
+	defText := getNodeText(node, content)
+	startLine := int(node.StartPosition().Row) + 1
+	typeDef := TypeDef{
+		Name:                      name,
+		Kind:                      kind,
+		Filename:                  filename,
+		StartLine:                 startLine,
+		EndLine:                   int(node.EndPosition().Row) + 1,
+		Definition:                defText,
+		DefinitionWithLineNumbers: addLineNumbers(defText, startLine),
+	}
+
+	specifier := node
+	if kind == "typedef" {
+		specifier = node.ChildByFieldName("type")
+	}
+	if specifier != nil {
+		if body := specifier.ChildByFieldName("body"); body != nil {
+			typeDef.Fields = extractFields(body, content)
+		}
+	}
+
+	return typeDef
+}
+
+// extractTypedefs returns one TypeDef per name declared by a typedef
+func extractTypedefs(node *sitter.Node, content []byte, filename string) []TypeDef {
+	baseType, _ := declarationBaseType(node, content)
+
+	var types []TypeDef
+	for i := uint(0); i < node.ChildCount(); i++ {
+		if node.FieldNameForChild(uint32(i)) != "declarator" {
+			continue
+		}
+		v := Variable{}
+		var funcParams string
+		describeDeclarator(node.Child(i), content, &v, &funcParams)
+		if v.Name == "" {
+			// describeDeclarator only knows identifiers; typedef names are type_identifiers
+			v.Name = typedefName(node.Child(i), content)
+		}
+		if v.Name == "" {
+			continue
+		}
+
+		typeDef := newTypeDef(node, content, filename, v.Name, "typedef")
+		typeDef.Underlying = formatDeclaratorType(baseType, v.PointerDepth, v.ArraySizes, v.FuncPointer, funcParams)
+		types = append(types, typeDef)
+	}
+
+	return types
+}
+
+// typedefName finds the type_identifier a typedef declarator introduces
+func typedefName(node *sitter.Node, content []byte) string {
+	if node.Kind() == "type_identifier" {
+		return getNodeText(node, content)
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if name := typedefName(node.NamedChild(i), content); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// extractFields returns the members of a struct/union body or the enumerators of an enum body
+func extractFields(body *sitter.Node, content []byte) []Variable {
+	var fields []Variable
+	for i := uint(0); i < body.ChildCount(); i++ {
+		child := body.Child(i)
+		switch child.Kind() {
+		case "field_declaration":
+			fields = append(fields, extractVariableDeclaration(child, content, "field")...)
+		case "enumerator":
+			if name := child.ChildByFieldName("name"); name != nil {
+				field := Variable{
+					Name:   getNodeText(name, content),
+					Origin: "enumerator",
+					Type:   "int",
+					Line:   int(child.StartPosition().Row) + 1,
+				}
+				if value := child.ChildByFieldName("value"); value != nil {
+					field.Init = getNodeText(value, content)
+				}
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// NormalizeTypeName strips qualifiers, pointers and array suffixes from a type,
+// e.g. "const struct ctx *" becomes "struct ctx"
+func NormalizeTypeName(typeName string) string {
+	if i := strings.IndexAny(typeName, "*[("); i >= 0 {
+		typeName = typeName[:i]
+	}
+	var words []string
+	for _, word := range strings.Fields(typeName) {
+		switch word {
+		case "const", "volatile", "restrict", "static", "extern", "register", "inline":
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+// LookupType finds the definition of a named type, preferring definitions that carry fields
+func (r *AnalysisResult) LookupType(name string) *TypeDef {
+	name = NormalizeTypeName(name)
+	var fallback *TypeDef
+	for _, i := range r.typeCandidates(name) {
+		if r.Types[i].Name != name {
+			continue
+		}
+		if len(r.Types[i].Fields) > 0 {
+			return &r.Types[i]
+		}
+		if fallback == nil {
+			fallback = &r.Types[i]
+		}
+	}
+	return fallback
+}
+
+// ResolveType follows typedefs from a type name to the definition that declares its fields.
+// It returns every definition visited along the way, outermost first.
+func (r *AnalysisResult) ResolveType(name string) []TypeDef {
+	var chain []TypeDef
+	seen := make(map[string]bool)
+	for name = NormalizeTypeName(name); name != "" && !seen[name]; {
+		seen[name] = true
+		typeDef := r.LookupType(name)
+		if typeDef == nil {
+			break
+		}
+		chain = append(chain, *typeDef)
+		if typeDef.Kind != "typedef" || len(typeDef.Fields) > 0 {
+			break
+		}
+		name = NormalizeTypeName(typeDef.Underlying)
+	}
+	return chain
+}
+
+// FieldOf returns the member of a struct or union, following typedefs to find it
+func (r *AnalysisResult) FieldOf(typeName, field string) (*Variable, error) {
+	chain := r.ResolveType(typeName)
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("type not found: %s", typeName)
+	}
+	definition := chain[len(chain)-1]
+	for i := range definition.Fields {
+		if definition.Fields[i].Name == field {
+			return &definition.Fields[i], nil
+		}
+	}
+	return nil, fmt.Errorf("field %s not found in %s", field, definition.Name)
+}
+
+// typeCandidates returns the indexes of Types that may be named name
+func (r *AnalysisResult) typeCandidates(name string) []int {
+	if r.typeIndex != nil {
+		return r.typeIndex[name]
+	}
+	candidates := make([]int, len(r.Types))
+	for i := range r.Types {
+		candidates[i] = i
+	}
+	return candidates
+}
+
+// buildIndexes builds the name lookups used while resolving types, globals, functions and
+// field accesses
+func (r *AnalysisResult) buildIndexes() {
+	r.typeIndex = make(map[string][]int)
+	for i, typeDef := range r.Types {
+		r.typeIndex[typeDef.Name] = append(r.typeIndex[typeDef.Name], i)
+	}
+	r.globalIndex = make(map[string][]int)
+	for i, global := range r.Globals {
+		r.globalIndex[global.Name] = append(r.globalIndex[global.Name], i)
+	}
+	r.functionIndex = make(map[string][]int)
+	r.functionIDs = make(map[string]int, len(r.Functions))
+	r.callerIndex = make(map[string][]int)
+	for i := range r.Functions {
+		function := &r.Functions[i]
+		r.functionIndex[function.Name] = append(r.functionIndex[function.Name], i)
+		r.functionIDs[function.ID] = i
+		for _, callee := range function.Callees {
+			if callers := r.callerIndex[callee.Name]; len(callers) == 0 || callers[len(callers)-1] != i {
+				r.callerIndex[callee.Name] = append(callers, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package parser
+
+import "testing"
+
+const typeSource = `struct list { struct list *next; };
+struct ctx;
+struct ctx {
+	int refs;
+	char name[32];
+	void (*release)(struct ctx *);
+	struct list node;
+};
+typedef struct ctx ctx_t;
+typedef ctx_t *ctx_ptr;
+typedef struct {
+	int x, y;
+} point_t;
+union value { int i; long l; };
+enum state { IDLE, BUSY = 4 };
+int use(ctx_t *c)
+{
+	struct local { int z; } l;
+	return c->refs;
+}
+`
+
+func TestNormalizeTypeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"const struct ctx *", "struct ctx"},
+		{"ctx_t **", "ctx_t"},
+		{"static volatile int [4]", "int"},
+		{"int (*)(int)", "int"},
+		{"unsigned long", "unsigned long"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeTypeName(tt.in); got != tt.want {
+			t.Errorf("NormalizeTypeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLookupType(t *testing.T) {
+	result := analyzeC(t, typeSource)
+	tests := []struct {
+		name       string
+		kind       string
+		start, end int
+		underlying string
+		fields     []string
+	}{
+		{"struct ctx", "struct", 3, 8, "", []string{"refs", "name", "release", "node"}},
+		{"const struct ctx *", "struct", 3, 8, "", []string{"refs", "name", "release", "node"}},
+		{"ctx_t", "typedef", 9, 9, "struct ctx", nil},
+		{"ctx_ptr", "typedef", 10, 10, "ctx_t *", nil},
+		{"point_t", "typedef", 11, 13, "struct <anonymous>", []string{"x", "y"}},
+		{"union value", "union", 14, 14, "", []string{"i", "l"}},
+		{"enum state", "enum", 15, 15, "", []string{"IDLE", "BUSY"}},
+		{"struct local", "", 0, 0, "", nil},
+		{"struct missing", "", 0, 0, "", nil},
+	}
+	for _, tt := range tests {
+		typeDef := result.LookupType(tt.name)
+		if tt.kind == "" {
+			if typeDef != nil {
+				t.Errorf("LookupType(%q) = %s, want nil", tt.name, typeDef.Name)
+			}
+			continue
+		}
+		if typeDef == nil {
+			t.Errorf("LookupType(%q) = nil", tt.name)
+			continue
+		}
+		if typeDef.Kind != tt.kind || typeDef.StartLine != tt.start || typeDef.EndLine != tt.end || typeDef.Underlying != tt.underlying {
+			t.Errorf("LookupType(%q) = %s %d-%d %q, want %s %d-%d %q", tt.name,
+				typeDef.Kind, typeDef.StartLine, typeDef.EndLine, typeDef.Underlying, tt.kind, tt.start, tt.end, tt.underlying)
+		}
+		var fields []string
+		for _, field := range typeDef.Fields {
+			fields = append(fields, field.Name)
+		}
+		if len(fields) != len(tt.fields) {
+			t.Errorf("LookupType(%q) fields = %v, want %v", tt.name, fields, tt.fields)
+			continue
+		}
+		for i := range fields {
+			if fields[i] != tt.fields[i] {
+				t.Errorf("LookupType(%q) fields = %v, want %v", tt.name, fields, tt.fields)
+				break
+			}
+		}
+	}
+
+	if enum := result.LookupType("enum state"); enum != nil && enum.Fields[1].Init != "4" {
+		t.Errorf("BUSY init = %q, want 4", enum.Fields[1].Init)
+	}
+}
+
+func TestResolveType(t *testing.T) {
+	result := analyzeC(t, typeSource)
+	tests := []struct {
+		name  string
+		chain []string
+	}{
+		{"ctx_ptr", []string{"ctx_ptr", "ctx_t", "struct ctx"}},
+		{"ctx_t *", []string{"ctx_t", "struct ctx"}},
+		{"struct ctx", []string{"struct ctx"}},
+		{"point_t", []string{"point_t"}},
+		{"int", nil},
+	}
+	for _, tt := range tests {
+		var chain []string
+		for _, typeDef := range result.ResolveType(tt.name) {
+			chain = append(chain, typeDef.Name)
+		}
+		if len(chain) != len(tt.chain) {
+			t.Errorf("ResolveType(%q) = %v, want %v", tt.name, chain, tt.chain)
+			continue
+		}
+		for i := range chain {
+			if chain[i] != tt.chain[i] {
+				t.Errorf("ResolveType(%q) = %v, want %v", tt.name, chain, tt.chain)
+				break
+			}
+		}
+	}
+}
+
+func TestFieldOf(t *testing.T) {
+	result := analyzeC(t, typeSource)
+	tests := []struct {
+		typeName, field string
+		typ             string
+		wantErr         bool
+	}{
+		{"ctx_ptr", "refs", "int", false},
+		{"struct ctx *", "name", "char [32]", false},
+		{"ctx_t", "release", "void (*)(struct ctx *)", false},
+		{"struct ctx", "node", "struct list", false},
+		{"point_t", "y", "int", false},
+		{"struct ctx", "missing", "", true},
+		{"struct missing", "refs", "", true},
+	}
+	for _, tt := range tests {
+		field, err := result.FieldOf(tt.typeName, tt.field)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("FieldOf(%q, %q) = %+v, want an error", tt.typeName, tt.field, field)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("FieldOf(%q, %q): %v", tt.typeName, tt.field, err)
+			continue
+		}
+		if field.Type != tt.typ {
+			t.Errorf("FieldOf(%q, %q) type = %q, want %q", tt.typeName, tt.field, field.Type, tt.typ)
+		}
+	}
+}
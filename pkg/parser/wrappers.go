@@ -0,0 +1,280 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// knownDeallocators maps common C and Linux kernel deallocators to the argument they free
+var knownDeallocators = map[string]int{
+	"free":            0,
+	"cfree":           0,
+	"g_free":          0,
+	"xfree":           0,
+	"OPENSSL_free":    0,
+	"kfree":           0,
+	"kvfree":          0,
+	"vfree":           0,
+	"kzfree":          0,
+	"kfree_sensitive": 0,
+	"kmem_cache_free": 1,
+	"devm_kfree":      1,
+	"operator delete": 0,
+}
+
+// ModelFunction is a function that frees one of its arguments or returns fresh memory
+type ModelFunction struct {
+	Name       string `json:"name"`
+	Arg        int    `json:"arg"`               // Index of the freed argument; -1 for allocators
+	Via        string `json:"via,omitempty"`     // Known or inferred function it wraps, empty for built-ins
+	FunctionID string `json:"func_id,omitempty"` // Definition the function was inferred from
+}
+
+// MemoryModel lists the deallocators and allocators of a codebase, built-in, declared and inferred
+type MemoryModel struct {
+	Deallocators []ModelFunction `json:"deallocators"`
+	Allocators   []ModelFunction `json:"allocators"`
+	Barriers     []ModelFunction `json:"barriers,omitempty"` // Functions that reset the argument at Arg, ending a free's lifetime
+
+	// Whether any function named like "free" also counts as a deallocator
+	NameHeuristic bool `json:"name_heuristic"`
+}
+
+// BuiltinMemoryModel returns the known C and Linux kernel allocators and deallocators
+func BuiltinMemoryModel() *MemoryModel {
+	model := &MemoryModel{NameHeuristic: true}
+	for name, arg := range knownDeallocators {
+		model.Deallocators = append(model.Deallocators, ModelFunction{Name: name, Arg: arg})
+	}
+	for name := range knownAllocators {
+		model.Allocators = append(model.Allocators, ModelFunction{Name: name, Arg: -1})
+	}
+	model.Sort()
+	return model
+}
+
+// Sort orders each list by function name so generated models are stable
+func (m *MemoryModel) Sort() {
+	for _, functions := range [][]ModelFunction{m.Deallocators, m.Allocators, m.Barriers} {
+		sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	}
+}
+
+// maxInferenceRounds bounds the fixpoint iteration; each round can only add wrappers one call deeper
+const maxInferenceRounds = 16
+
+// InferMemoryModel finds wrapper deallocators, functions that pass a parameter to a known
+// deallocator on every path except the one where it is NULL, and wrapper allocators, functions
+// whose every non-NULL return comes from an allocator. Wrappers of wrappers are found by
+// iterating to a fixpoint. Inference starts from the seed model, or the built-ins when nil.
+// The inferred model lists every deallocator it found, so it turns the name heuristic off.
+func InferMemoryModel(functions []Function, seed *MemoryModel) *MemoryModel {
+	if seed == nil {
+		seed = BuiltinMemoryModel()
+	}
+	deallocators := make(map[string]ModelFunction)
+	for _, function := range seed.Deallocators {
+		deallocators[function.Name] = function
+	}
+	allocators := make(map[string]ModelFunction)
+	for _, function := range seed.Allocators {
+		allocators[function.Name] = function
+	}
+
+	type summary struct {
+		function *Function
+		dataflow *Dataflow
+		reaching [][]int
+	}
+	var summaries []summary
+	for i := range functions {
+		dataflow, err := AnalyzeDataflow(&functions[i])
+		if err != nil {
+			continue
+		}
+		summaries = append(summaries, summary{&functions[i], dataflow, dataflow.ReachingDefinitions()})
+	}
+
+	for round := 0; round < maxInferenceRounds; round++ {
+		changed := false
+		for _, s := range summaries {
+			name := s.function.Name
+			if _, ok := deallocators[name]; !ok {
+				if model, ok := inferDeallocator(s.function, s.dataflow, s.reaching, deallocators); ok {
+					deallocators[name] = model
+					changed = true
+				}
+			}
+			if _, ok := allocators[name]; !ok {
+				if model, ok := inferAllocator(s.function, s.dataflow, s.reaching, allocators); ok {
+					allocators[name] = model
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	model := &MemoryModel{Barriers: seed.Barriers}
+	for _, function := range deallocators {
+		model.Deallocators = append(model.Deallocators, function)
+	}
+	for _, function := range allocators {
+		model.Allocators = append(model.Allocators, function)
+	}
+	model.Sort()
+	return model
+}
+
+// inferDeallocator reports whether a function unconditionally frees one of its parameters
+func inferDeallocator(function *Function, dataflow *Dataflow, reaching [][]int, deallocators map[string]ModelFunction) (ModelFunction, bool) {
+	cfg := dataflow.CFG
+	for _, callee := range function.Callees {
+		wrapped, ok := deallocators[callee.Name]
+		if !ok || wrapped.Arg >= len(callee.Args) {
+			continue
+		}
+		param := NormalizeTarget(StripCasts(callee.Args[wrapped.Arg]))
+		index := -1
+		for i, p := range function.Params {
+			if ParameterName(p.Snippet) == param {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			continue
+		}
+
+		node := cfg.NodeAtLine(callee.Line)
+		if node < 0 {
+			continue
+		}
+		reassigned := false
+		for _, def := range reaching[node] {
+			if dataflow.Defs[def].Target == param {
+				reassigned = true
+			}
+		}
+		if reassigned || cfg.exitAvoiding(node, param) {
+			continue
+		}
+
+		return ModelFunction{Name: function.Name, Arg: index, Via: callee.Name, FunctionID: function.ID}, true
+	}
+	return ModelFunction{}, false
+}
+
+// exitAvoiding reports whether the exit can be reached without passing through a node,
+// ignoring branches taken only when param is NULL
+func (c *CFG) exitAvoiding(avoid int, param string) bool {
+	visited := make([]bool, len(c.Nodes))
+	visited[c.Entry] = true
+	stack := []int{c.Entry}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if current == c.Exit {
+			return true
+		}
+		node := c.Nodes[current]
+		nullBranch := ""
+		if node.Kind == "cond" {
+			nullBranch = nullCheckBranch(node.Text, param)
+		}
+		for _, edge := range node.Succs {
+			if edge.To == avoid || visited[edge.To] || (nullBranch != "" && edge.Label == nullBranch) {
+				continue
+			}
+			visited[edge.To] = true
+			stack = append(stack, edge.To)
+		}
+	}
+	return false
+}
+
+// nullCheckBranch returns the branch of a condition taken when param is NULL, or "" if the
+// condition does not test param for NULL
+func nullCheckBranch(condition, param string) string {
+	condition = NormalizeTarget(condition)
+	for _, wrapper := range []string{"unlikely(", "likely("} {
+		if strings.HasPrefix(condition, wrapper) && strings.HasSuffix(condition, ")") {
+			condition = NormalizeTarget(condition[len(wrapper) : len(condition)-1])
+		}
+	}
+	switch condition {
+	case "!" + param, param + "==NULL", "NULL==" + param, param + "==0", "IS_ERR_OR_NULL(" + param + ")", "ZERO_OR_NULL_PTR(" + param + ")":
+		return "true"
+	case param, param + "!=NULL", "NULL!=" + param, param + "!=0":
+		return "false"
+	}
+	return ""
+}
+
+// inferAllocator reports whether every non-NULL return of a pointer-returning function
+// hands back memory from an allocator
+func inferAllocator(function *Function, dataflow *Dataflow, reaching [][]int, allocators map[string]ModelFunction) (ModelFunction, bool) {
+	if !returnsPointer(function) {
+		return ModelFunction{}, false
+	}
+
+	via := ""
+	allocatorCall := func(value string, line int) string {
+		value = strings.TrimSpace(StripCasts(value))
+		for _, callee := range function.Callees {
+			if _, ok := allocators[callee.Name]; ok && callee.Line == line && strings.HasPrefix(value, callee.Name+"(") {
+				return callee.Name
+			}
+		}
+		return ""
+	}
+
+	returns := 0
+	for _, node := range dataflow.CFG.Nodes {
+		if node.Kind != "return" {
+			continue
+		}
+		value := strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(node.Text, "return")), ";")
+		if value == "" || IsNullValue(value) || strings.HasPrefix(NormalizeTarget(value), "ERR_PTR(") {
+			continue
+		}
+		returns++
+
+		if name := allocatorCall(value, node.Line); name != "" {
+			via = name
+			continue
+		}
+
+		// A returned variable must only ever hold fresh memory at this point
+		variable := NormalizeTarget(StripCasts(value))
+		defs := 0
+		for _, index := range reaching[node.ID] {
+			def := dataflow.Defs[index]
+			if def.Target != variable {
+				continue
+			}
+			defs++
+			name := allocatorCall(def.Value, def.Line)
+			if name == "" {
+				return ModelFunction{}, false
+			}
+			via = name
+		}
+		if defs == 0 {
+			return ModelFunction{}, false
+		}
+	}
+	if returns == 0 {
+		return ModelFunction{}, false
+	}
+
+	return ModelFunction{Name: function.Name, Arg: -1, Via: via, FunctionID: function.ID}, true
+}
+
+// returnsPointer reports whether a function's signature returns a pointer
+func returnsPointer(function *Function) bool {
+	end := strings.Index(function.Signature, function.Name+"(")
+	return end > 0 && strings.Contains(function.Signature[:end], "*")
+}
@@ -0,0 +1,64 @@
+package parser
+
+import "testing"
+
+const wrapperSource = `void kfree(const void *p);
+void buf_put(char *b)
+{
+	if (!b)
+		return;
+	kfree(b);
+}
+void freeze_queue(int *q)
+{
+	q[0] = 1;
+}
+`
+
+func TestInferMemoryModel(t *testing.T) {
+	result := analyzeC(t, wrapperSource)
+	tests := []struct {
+		name string
+		seed *MemoryModel
+	}{
+		{"builtin seed", nil},
+		{"seed with name heuristic", &MemoryModel{Deallocators: []ModelFunction{{Name: "kfree"}}, NameHeuristic: true}},
+		{"seed without name heuristic", &MemoryModel{Deallocators: []ModelFunction{{Name: "kfree"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := InferMemoryModel(result.Functions, tt.seed)
+			if model.NameHeuristic {
+				t.Error("inferred model keeps the name heuristic")
+			}
+			found := false
+			for _, function := range model.Deallocators {
+				found = found || function.Name == "buf_put"
+			}
+			if !found {
+				t.Errorf("buf_put not inferred as a deallocator: %+v", model.Deallocators)
+			}
+		})
+	}
+}
+
+func TestInferredModelDeallocators(t *testing.T) {
+	model := InferMemoryModel(analyzeC(t, wrapperSource).Functions, nil)
+	UseMemoryModel(model)
+	t.Cleanup(func() { activeModel.model, activeModel.deallocators, activeModel.allocators = nil, nil, nil })
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"kfree", true},
+		{"buf_put", true},
+		{"freeze_queue", false},
+		{"free_netdev", false},
+	}
+	for _, tt := range tests {
+		if got := IsDeallocator(tt.name); got != tt.want {
+			t.Errorf("IsDeallocator(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}